@@ -0,0 +1,68 @@
+package availability
+
+import (
+	"context"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/spf13/cobra"
+
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/xcobra"
+)
+
+type (
+	availabilityArgs struct {
+		Name        string
+		ID          string
+		RunLocation string
+		Message     string
+		Success     bool
+		Duration    time.Duration
+		Start       int64
+		Tags        map[string]string
+	}
+)
+
+// NewAvailabilityCommand creates a new `apmz availability` command
+func NewAvailabilityCommand(sl service.CommandServicer) (*cobra.Command, error) {
+	var oArgs availabilityArgs
+	cmd := &cobra.Command{
+		Use:   "availability",
+		Short: "send an availability test result (availabilityResults) to Application Insights",
+		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			avail := apmz.NewAvailabilityTelemetry(oArgs.Name, oArgs.Duration, oArgs.Success)
+			avail.ID = oArgs.ID
+			avail.RunLocation = oArgs.RunLocation
+			avail.Message = oArgs.Message
+			if oArgs.Start != 0 {
+				avail.Timestamp = time.Unix(0, oArgs.Start)
+			}
+
+			for k, v := range oArgs.Tags {
+				avail.Properties[k] = v
+			}
+
+			apmer, err := sl.GetAPMer()
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("unable to create App Insight client: %v\n", err)
+				return err
+			}
+
+			apmer.Track(avail)
+			return nil
+		}),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&oArgs.Name, "name", "n", "", "name of the availability test")
+	f.StringVar(&oArgs.ID, "id", "", "identifier of the test run used for correlation")
+	f.StringVarP(&oArgs.RunLocation, "run-location", "r", "", "name of the location where the test was run; eg the hostname or region")
+	f.StringVarP(&oArgs.Message, "message", "m", "", "diagnostic message for the result")
+	f.BoolVarP(&oArgs.Success, "success", "s", true, "indication of a passing or failing test")
+	f.DurationVarP(&oArgs.Duration, "duration", "d", 0, "duration of the test run; eg '1.5s' or '300ms'")
+	f.Int64Var(&oArgs.Start, "start", 0, "start time of the test run in unixnano format; defaults to now")
+	f.StringToStringVarP(&oArgs.Tags, "tags", "t", map[string]string{}, "custom tags to be applied to the result formatted as key=value")
+	err := cmd.MarkFlagRequired("name")
+	return cmd, err
+}
@@ -0,0 +1,77 @@
+package availability
+
+import (
+	"testing"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	mocks "github.com/devigned/apmz/internal/test"
+)
+
+func TestNewAvailabilityCommand(t *testing.T) {
+	cases := []struct {
+		name       string
+		setup      func(t *testing.T) *mocks.ServiceMock
+		assertions func(t *testing.T, cmd *cobra.Command)
+	}{
+		{
+			name: "CommandConstruction",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				return nil
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				assert.Equal(t, "availability", cmd.Name())
+				name := cmd.Flags().Lookup("name")
+				if assert.NotNil(t, name) {
+					assert.Equal(t, name.Shorthand, "n")
+				}
+				tags := cmd.Flags().Lookup("tags")
+				if assert.NotNil(t, tags) {
+					assert.Equal(t, tags.Shorthand, "t")
+				}
+				loc := cmd.Flags().Lookup("run-location")
+				if assert.NotNil(t, loc) {
+					assert.Equal(t, loc.Shorthand, "r")
+				}
+			},
+		},
+		{
+			name: "WithFailingTest",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				sl := new(mocks.ServiceMock)
+				apm := new(mocks.APMMock)
+				apm.On("Track", mock.MatchedBy(func(item apmz.Telemetry) bool {
+					avail, ok := item.(*apmz.AvailabilityTelemetry)
+					return ok && avail.Name == "smoke" &&
+						avail.RunLocation == "westus2" &&
+						avail.Message == "timeout" &&
+						!avail.Success &&
+						avail.Duration == 3*time.Second &&
+						avail.Properties["suite"] == "api"
+				}))
+				sl.On("GetAPMer").Return(apm, nil)
+				return sl
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				cmd.SetArgs([]string{"-n", "smoke", "-r", "westus2", "-m", "timeout", "-s=false", "-d", "3s", "-t", "suite=api"})
+				assert.NoError(t, cmd.Execute())
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s := c.setup(t)
+			cmd, err := NewAvailabilityCommand(s)
+			assert.NoError(t, err)
+			assert.NotNil(t, cmd)
+			c.assertions(t, cmd)
+		})
+	}
+}
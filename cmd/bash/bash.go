@@ -15,9 +15,10 @@ import (
 
 type (
 	bashFlags struct {
-		Disable     bool
-		ScriptName  string
-		DefaultTags map[string]string
+		Disable       bool
+		ExitAsRequest bool
+		ScriptName    string
+		DefaultTags   map[string]string
 	}
 )
 
@@ -39,7 +40,9 @@ func NewBashCommand(sl service.CommandServicer) (*cobra.Command, error) {
 				}
 			} else {
 				// enabled, so we need to have the AppInsightsKey set
-				if sl.GetKeys() == nil || len(sl.GetKeys()) == 0 {
+				if len(sl.GetKeys()) == 0 && len(stringSliceFlag(cmd, "otlp-endpoints")) == 0 && stringFlag(cmd, "prometheus-textfile-dir") == "" &&
+					stringFlag(cmd, "pushgateway-url") == "" && stringFlag(cmd, "statsd-address") == "" &&
+					len(stringSliceFlag(cmd, "destinations")) == 0 && stringFlag(cmd, "routes-file") == "" {
 					warning := "Warning: apmz event collection is enabled, but --api-keys is not specified. You must override the __APP_INSIGHTS_KEY env var or events will not be set to Application Insights on script exit.\n"
 					sl.GetPrinter().ErrPrintf(warning)
 				}
@@ -49,15 +52,42 @@ func NewBashCommand(sl service.CommandServicer) (*cobra.Command, error) {
 			for k, v := range oArgs.DefaultTags {
 				kvs = append(kvs, fmt.Sprintf("%s=%s", k, v))
 			}
+			for k, v := range stringToStringFlag(cmd, "global-tags") {
+				if _, ok := oArgs.DefaultTags[k]; !ok {
+					kvs = append(kvs, fmt.Sprintf("%s=%s", k, v))
+				}
+			}
 
 			tags := strings.Join(kvs, ",")
 			input := struct {
 				ScriptName      string
 				DefaultTags     string
 				AppInsightsKeys string
+				OTLPEndpoints   string
+				TextfileDir     string
+				PushgatewayURL  string
+				StatsdAddress   string
+				StatsdType      string
+				StatsdEvents    bool
+				Destinations    string
+				RoutesFile      string
+				Profile         string
+				Config          string
+				ExitAsRequest   bool
 			}{
-				ScriptName:  oArgs.ScriptName,
-				DefaultTags: tags,
+				ScriptName:     oArgs.ScriptName,
+				DefaultTags:    tags,
+				OTLPEndpoints:  strings.Join(stringSliceFlag(cmd, "otlp-endpoints"), ","),
+				TextfileDir:    stringFlag(cmd, "prometheus-textfile-dir"),
+				PushgatewayURL: stringFlag(cmd, "pushgateway-url"),
+				StatsdAddress:  stringFlag(cmd, "statsd-address"),
+				StatsdType:     stringFlag(cmd, "statsd-metric-type"),
+				StatsdEvents:   boolFlag(cmd, "statsd-events"),
+				Destinations:   strings.Join(stringSliceFlag(cmd, "destinations"), ","),
+				RoutesFile:     stringFlag(cmd, "routes-file"),
+				Profile:        stringFlag(cmd, "profile"),
+				Config:         stringFlag(cmd, "config"),
+				ExitAsRequest:  oArgs.ExitAsRequest,
 			}
 
 			if sl.GetKeys() != nil {
@@ -82,7 +112,44 @@ func NewBashCommand(sl service.CommandServicer) (*cobra.Command, error) {
 	}
 
 	cmd.Flags().BoolVarP(&oArgs.Disable, "disabled", "d", false, "disable event collection; if disabled, then all script functions are defined, but do not collect events.")
+	cmd.Flags().BoolVarP(&oArgs.ExitAsRequest, "exit-request", "r", false, "on script exit, send a single request event with the script duration and exit code rather than an exit trace and a duration metric")
 	cmd.Flags().StringVarP(&oArgs.ScriptName, "name", "n", "script", "name of script for use in script start and exit events")
 	cmd.Flags().StringToStringVarP(&oArgs.DefaultTags, "default-tags", "t", map[string]string{}, "default tags for all events and metrics formatted as key=value")
 	return cmd, nil
 }
+
+// stringSliceFlag returns the values of a string slice flag given to the root command, if any
+func stringSliceFlag(cmd *cobra.Command, name string) []string {
+	values, err := cmd.Flags().GetStringSlice(name)
+	if err != nil {
+		return nil
+	}
+	return values
+}
+
+// stringFlag returns the value of a string flag given to the root command, if any
+func stringFlag(cmd *cobra.Command, name string) string {
+	value, err := cmd.Flags().GetString(name)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// stringToStringFlag returns the values of a key=value flag given to the root command, if any
+func stringToStringFlag(cmd *cobra.Command, name string) map[string]string {
+	values, err := cmd.Flags().GetStringToString(name)
+	if err != nil {
+		return nil
+	}
+	return values
+}
+
+// boolFlag returns the value of a bool flag given to the root command, if any
+func boolFlag(cmd *cobra.Command, name string) bool {
+	value, err := cmd.Flags().GetBool(name)
+	if err != nil {
+		return false
+	}
+	return value
+}
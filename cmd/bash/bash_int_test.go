@@ -6,19 +6,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"text/template"
 	"time"
 
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	bashtest "github.com/devigned/apmz/internal/test/bash"
+	"github.com/devigned/apmz/pkg/ingest"
 	"github.com/devigned/apmz/pkg/service"
 )
 
@@ -31,6 +38,61 @@ type (
 )
 
 func TestNewBashCommandEnabled(t *testing.T) {
+	ingestion := &ingest.Server{}
+	srv := httptest.NewServer(ingestion)
+	defer srv.Close()
+
+	var otlpMu sync.Mutex
+	otlpPaths := make(map[string]int)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otlpMu.Lock()
+		defer otlpMu.Unlock()
+		otlpPaths[r.URL.Path]++
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer collector.Close()
+
+	var hookMu sync.Mutex
+	var hookEvents []service.Event
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []service.Event
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&events))
+		hookMu.Lock()
+		defer hookMu.Unlock()
+		hookEvents = append(hookEvents, events...)
+	}))
+	defer hook.Close()
+
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer agent.Close()
+
+	var statsdMu sync.Mutex
+	var statsdLines []string
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := agent.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			statsdMu.Lock()
+			statsdLines = append(statsdLines, strings.Split(string(buf[:n]), "\n")...)
+			statsdMu.Unlock()
+		}
+	}()
+
+	textfileDir, err := ioutil.TempDir("", "apmz-textfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(textfileDir)
+
+	configPath := filepath.Join(textfileDir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(fmt.Sprintf(`profiles:
+  ci:
+    api-keys: ["InstrumentationKey=profiled;IngestionEndpoint=%s"]
+    global-tags: {team: ops}
+`, srv.URL)), 0600))
+
 	cases := []struct {
 		name       string
 		env        []string
@@ -88,6 +150,25 @@ func TestNewBashCommandEnabled(t *testing.T) {
 				assert.Equal(t, 3, len(lines))
 			},
 		},
+		{
+			name: "ShouldHaveThreeEventsWhenInvokingAnException",
+			env:  []string{"__PRESERVE_TMP_FILE=true", "__DEFAULT_TAGS=foo=bar"},
+			script: `migrate() {
+  trace_exception "migration failed"
+}
+migrate`,
+			assertions: func(t *testing.T, stdout, stderr, eventFilePath string) {
+				_, err := os.Stat(eventFilePath)
+				require.NoError(t, err)
+				lines := readEventFile(t, eventFilePath)
+				require.Equal(t, 3, len(lines))
+				events := eventsFromLines(t, lines)
+				assert.Equal(t, "exception/v1", events[0].Type)
+				assert.Contains(t, lines[0], `"Message":"migration failed"`)
+				assert.Contains(t, lines[0], `"method":"migrate"`)
+				assert.Contains(t, lines[0], `"method":"main"`)
+			},
+		},
 		{
 			name:   "ShouldHaveThreeEventsWhenInvokingAMetricEvent",
 			env:    []string{"__PRESERVE_TMP_FILE=true", "__DEFAULT_TAGS=foo=bar"},
@@ -109,10 +190,109 @@ func TestNewBashCommandEnabled(t *testing.T) {
 				require.NoError(t, err)
 				lines := readEventFile(t, eventFilePath)
 				assert.Equal(t, 2, len(lines))
-				assert.Equal(t, "sent 2 events\n", stderr)
+				assert.True(t, strings.HasPrefix(stderr, "queued 2 events\n"))
+				assert.Contains(t, stderr, "foo: 2 sent")
+				assert.Contains(t, stderr, "something: 2 sent")
 				assert.Equal(t, "foo,something\n", stdout)
 			},
 		},
+		{
+			name:   "WithConnectionStringAsArgs",
+			env:    []string{"__DRY_RUN=true"},
+			args:   []string{"--api-keys", `"InstrumentationKey=foo;IngestionEndpoint=https://localhost/"`},
+			script: "echo $__APP_INSIGHTS_KEYS",
+			assertions: func(t *testing.T, stdout, stderr, eventFilePath string) {
+				assert.Equal(t, "InstrumentationKey=foo;IngestionEndpoint=https://localhost/\n", stdout)
+			},
+		},
+		{
+			name:   "DeliversToLocalIngestion",
+			args:   []string{"--api-keys", fmt.Sprintf(`"InstrumentationKey=wire;IngestionEndpoint=%s"`, srv.URL)},
+			script: `trace_info "info_event"`,
+			assertions: func(t *testing.T, stdout, stderr, eventFilePath string) {
+				assert.Contains(t, stderr, "wire: 3 sent, 3 accepted")
+				records := ingestion.Records(ingest.Query{IKey: "wire"})
+				require.Len(t, records, 3)
+				for _, record := range records {
+					assert.NotEmpty(t, record.Tags["ai.operation.id"])
+					assert.Equal(t, records[0].Tags["ai.operation.id"], record.Tags["ai.operation.id"])
+				}
+				assert.Len(t, ingestion.Records(ingest.Query{IKey: "wire", Type: "trace", Name: "info_event"}), 1)
+			},
+		},
+		{
+			name: "DeliversToBothBackends",
+			args: []string{
+				"--api-keys", fmt.Sprintf(`"InstrumentationKey=both;IngestionEndpoint=%s"`, srv.URL),
+				"--otlp-endpoints", collector.URL,
+			},
+			assertions: func(t *testing.T, stdout, stderr, eventFilePath string) {
+				assert.Contains(t, stderr, "both: 2 sent, 2 accepted")
+				assert.Contains(t, stderr, collector.URL+": 2 sent, 2 accepted")
+				assert.Len(t, ingestion.Records(ingest.Query{IKey: "both"}), 2)
+				otlpMu.Lock()
+				defer otlpMu.Unlock()
+				assert.Equal(t, map[string]int{"/v1/logs": 1, "/v1/metrics": 1}, otlpPaths)
+			},
+		},
+		{
+			name: "RoutesErrorsToWebhook",
+			args: []string{
+				"--destinations", fmt.Sprintf(`"appinsights://routed?endpoint=%s,%s/alerts#severity=error"`, srv.URL, hook.URL),
+			},
+			script: `trace_err "deploy_failed"`,
+			assertions: func(t *testing.T, stdout, stderr, eventFilePath string) {
+				assert.Contains(t, stderr, "routed: 3 sent, 3 accepted")
+				assert.Contains(t, stderr, hook.URL+"/alerts: 1 sent, 1 accepted")
+				assert.Len(t, ingestion.Records(ingest.Query{IKey: "routed"}), 3)
+				hookMu.Lock()
+				defer hookMu.Unlock()
+				require.Len(t, hookEvents, 1)
+				assert.Equal(t, "deploy_failed", hookEvents[0].Item.(*apmz.TraceTelemetry).Message)
+			},
+		},
+		{
+			name: "SendsWithConfigProfile",
+			args: []string{"--config", configPath, "--profile", "ci"},
+			assertions: func(t *testing.T, stdout, stderr, eventFilePath string) {
+				assert.Contains(t, stderr, "profiled: 2 sent, 2 accepted")
+				records := ingestion.Records(ingest.Query{IKey: "profiled"})
+				require.Len(t, records, 2)
+				for _, record := range records {
+					assert.Contains(t, string(record.Data.BaseData), `"team":"ops"`)
+				}
+			},
+		},
+		{
+			name:   "WritesPrometheusTextfile",
+			args:   []string{"--prometheus-textfile-dir", textfileDir},
+			script: `time_metric "time_true" true`,
+			assertions: func(t *testing.T, stdout, stderr, eventFilePath string) {
+				assert.Contains(t, stderr, ": 2 sent, 2 accepted")
+				bits, err := ioutil.ReadFile(filepath.Join(textfileDir, "apmz.prom"))
+				require.NoError(t, err)
+				assert.Contains(t, string(bits), "# TYPE script_duration gauge\nscript_duration ")
+				assert.Contains(t, string(bits), "# TYPE time_true gauge\ntime_true ")
+				assert.NotContains(t, string(bits), "correlation_id")
+			},
+		},
+		{
+			name: "SendsToStatsdAgent",
+			args: []string{"-n", "deploy", "--statsd-address", agent.LocalAddr().String(), "--statsd-events"},
+			assertions: func(t *testing.T, stdout, stderr, eventFilePath string) {
+				assert.Contains(t, stderr, agent.LocalAddr().String()+": 2 sent, 2 accepted")
+				require.Eventually(t, func() bool {
+					statsdMu.Lock()
+					defer statsdMu.Unlock()
+					return len(statsdLines) == 2
+				}, 5*time.Second, 10*time.Millisecond)
+
+				statsdMu.Lock()
+				defer statsdMu.Unlock()
+				assert.Regexp(t, `^_e\{11,11\}:deploy-exit\|deploy-exit\|d:\d+\|t:info\|#code:0,service:deploy$`, statsdLines[0])
+				assert.Regexp(t, `^deploy-duration:[0-9.e-]+\|g\|#service:deploy$`, statsdLines[1])
+			},
+		},
 		{
 			name: "WithNameAsArgs",
 			env:  []string{"__PRESERVE_TMP_FILE=true"},
@@ -127,6 +307,23 @@ func TestNewBashCommandEnabled(t *testing.T) {
 				assert.Contains(t, lines[1], "helloworld-duration")
 			},
 		},
+		{
+			name: "WithExitRequestAsArgs",
+			env:  []string{"__PRESERVE_TMP_FILE=true"},
+			args: []string{"-n", "helloworld", "-r"},
+			assertions: func(t *testing.T, stdout, stderr, eventFilePath string) {
+				_, err := os.Stat(eventFilePath)
+				require.NoError(t, err)
+				lines := readEventFile(t, eventFilePath)
+				events := eventsFromLines(t, lines)
+				require.Equal(t, 1, len(events))
+				assert.Equal(t, "request/v1", events[0].Type)
+				assert.Contains(t, lines[0], `"Name":"helloworld"`)
+				assert.Contains(t, lines[0], `"ResponseCode":"0"`)
+				assert.Contains(t, lines[0], `"Success":true`)
+				assert.NotEmpty(t, events[0].Item.GetProperties()["correlation_id"])
+			},
+		},
 		{
 			name: "WithDefaultTagsAsArgs",
 			env:  []string{"__PRESERVE_TMP_FILE=true"},
@@ -138,14 +335,14 @@ func TestNewBashCommandEnabled(t *testing.T) {
 				events := eventsFromLines(t, lines)
 				assert.Equal(t, 2, len(events))
 
-				assert.Equal(t, events[0].Type, "TraceTelemetry")
+				assert.Equal(t, events[0].Type, "trace/v1")
 				props0 := events[0].Item.GetProperties()
 				assert.Equal(t, props0["code"], "0")
 				assert.Equal(t, props0["fast"], "slow")
 				assert.Equal(t, props0["foo"], "bar")
 				assert.NotEmpty(t, props0["correlation_id"])
 
-				assert.Equal(t, events[1].Type, "MetricTelemetry")
+				assert.Equal(t, events[1].Type, "metric/v1")
 				props1 := events[1].Item.GetProperties()
 				assert.Equal(t, props1["fast"], "slow")
 				assert.Equal(t, props1["foo"], "bar")
@@ -154,6 +351,22 @@ func TestNewBashCommandEnabled(t *testing.T) {
 				assert.Equal(t, props0["correlation_id"], props1["correlation_id"])
 			},
 		},
+		{
+			name: "WithOperationContextTags",
+			env:  []string{"__PRESERVE_TMP_FILE=true", "__SCRIPT_SESSION_ID=session-id", "__SCRIPT_NAME=myscript"},
+			assertions: func(t *testing.T, stdout, stderr, eventFilePath string) {
+				_, err := os.Stat(eventFilePath)
+				require.NoError(t, err)
+				events := eventsFromLines(t, readEventFile(t, eventFilePath))
+				require.Equal(t, 2, len(events))
+				for _, evt := range events {
+					tags := evt.Item.ContextTags()
+					assert.Equal(t, "session-id", tags[contracts.OperationId])
+					assert.Equal(t, "myscript", tags[contracts.OperationName])
+					assert.Equal(t, "myscript", tags[contracts.CloudRole])
+				}
+			},
+		},
 		{
 			name:   "HasSessionIDSet",
 			script: "echo $__SCRIPT_SESSION_ID",
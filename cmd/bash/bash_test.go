@@ -25,6 +25,7 @@ func TestNewBashCommand(t *testing.T) {
 			assertions: func(t *testing.T, cmd *cobra.Command) {
 				assert.Equal(t, "bash", cmd.Name())
 				assert.NotNil(t, cmd.Flags().Lookup("disabled"))
+				assert.NotNil(t, cmd.Flags().Lookup("exit-request"))
 				assert.NotNil(t, cmd.Flags().Lookup("name"))
 				assert.NotNil(t, cmd.Flags().Lookup("default-tags"))
 			},
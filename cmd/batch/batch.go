@@ -1,32 +1,73 @@
 package batch
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
-	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/devigned/apmz/pkg/format"
 	"github.com/devigned/apmz/pkg/service"
 	"github.com/devigned/apmz/pkg/xcobra"
 )
 
 type (
 	batchArgs struct {
-		FilePath string
+		FilePath        string
+		ProgressEvery   int
+		ContinueOnError bool
+		RejectFile      string
+	}
+
+	batchStats struct {
+		Lines    int
+		Sent     int
+		Skipped  int
+		Rejected int
+	}
+
+	// streamer tracks events line by line from a reader
+	streamer struct {
+		APMer           service.APMer
+		Printer         format.Printer
+		ProgressEvery   int
+		ContinueOnError bool
+		Rejects         io.Writer
+	}
+
+	// readError is returned when the underlying reader fails
+	readError struct {
+		error
+	}
+
+	// rejectedLine is written to the reject file for each line which could not be sent
+	rejectedLine struct {
+		Line  int    `json:"line"`
+		Error string `json:"error"`
+		Event string `json:"event"`
 	}
 )
 
+const (
+	// rejectedExitCode is the exit code used when running with --continue-on-error and any line was rejected
+	rejectedExitCode = 3
+)
+
 // NewBatchCommand creates a new `apmz batch` command
 func NewBatchCommand(sl service.CommandServicer) (*cobra.Command, error) {
 	var oArgs batchArgs
 	cmd := &cobra.Command{
 		Use:   "batch",
 		Short: "upload a batch of telemetry to Application Insights",
+		Long: "Upload a batch of telemetry to Application Insights. Once the batch is sent, the delivery result for " +
+			"each Application Insights key is printed to stderr, and " +
+			fmt.Sprintf("apmz exits with code %d if any telemetry was not accepted.", service.UndeliveredExitCode),
 		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
 			apmzer, err := sl.GetAPMer()
 			if err != nil {
@@ -36,44 +77,173 @@ func NewBatchCommand(sl service.CommandServicer) (*cobra.Command, error) {
 
 			reader := io.Reader(os.Stdin)
 			if oArgs.FilePath != "" {
-				bits, err := ioutil.ReadFile(oArgs.FilePath)
+				file, err := os.Open(oArgs.FilePath)
 				if err != nil {
 					sl.GetPrinter().ErrPrintf("unable to read file: %v\n", err)
 					return err
 				}
-				reader = bytes.NewReader(bits)
+				defer file.Close()
+				reader = file
 			}
 
-			eventsBits, err := ioutil.ReadAll(reader)
-			if err != nil {
-				sl.GetPrinter().ErrPrintf("unable to read: %v\n", err)
-				return err
+			s := streamer{
+				APMer:           apmzer,
+				Printer:         sl.GetPrinter(),
+				ProgressEvery:   oArgs.ProgressEvery,
+				ContinueOnError: oArgs.ContinueOnError,
 			}
 
-			lines := strings.Split(string(eventsBits), "\n")
-			sent := 0
-			for _, l := range lines {
-				var evt service.Event
-
-				if strings.TrimSpace(l) == "" {
-					continue
-				}
-
-				if err := json.Unmarshal([]byte(l), &evt); err != nil {
-					sl.GetPrinter().ErrPrintf("unable to unmarshal events: %v -- \n%v", err, l)
+			if oArgs.RejectFile != "" {
+				rejects, err := os.Create(oArgs.RejectFile)
+				if err != nil {
+					sl.GetPrinter().ErrPrintf("unable to create reject file: %v\n", err)
 					return err
 				}
+				defer rejects.Close()
+				s.Rejects = rejects
+			}
+
+			stats, err := s.Stream(ctx, reader)
+			if errors.Is(err, context.Canceled) {
+				sl.GetPrinter().ErrPrintf("stopped reading after line %d on shutdown signal; queued %d events and dropped the rest of the input\n", stats.Lines, stats.Sent)
+				return err
+			}
+			if err != nil {
+				return err
+			}
 
-				apmzer.Track(evt.Item)
-				sent++
+			sl.GetPrinter().ErrPrintf("queued %d events\n", stats.Sent)
+			if stats.Skipped > 0 {
+				sl.GetPrinter().ErrPrintf("skipped %d events of unknown type\n", stats.Skipped)
 			}
 
-			sl.GetPrinter().ErrPrintf("sent %d events\n", sent)
+			if oArgs.ContinueOnError {
+				sl.GetPrinter().ErrPrintf("rejected %d lines\n", stats.Rejected)
+				if stats.Rejected > 0 {
+					return xcobra.ErrorWithCode{Code: rejectedExitCode}
+				}
+			}
 			return nil
 		}),
 	}
 
 	f := cmd.Flags()
 	f.StringVarP(&oArgs.FilePath, "file-path", "f", "", "file path to json events -- if not specified, then stdin will be assumed")
+	f.IntVar(&oArgs.ProgressEvery, "progress-every", 10000, "report progress to stderr every n lines read; 0 disables progress reporting")
+	f.BoolVar(&oArgs.ContinueOnError, "continue-on-error", false, fmt.Sprintf("reject lines which can't be parsed or are of an unknown type rather than stopping; exits with code %d if any line was rejected", rejectedExitCode))
+	f.StringVar(&oArgs.RejectFile, "reject-file", "", "file path to write rejected lines to as json with their line numbers -- if not specified, rejected lines are written to stderr")
+
+	validateCmd, err := newValidateCommand(sl)
+	if err != nil {
+		return cmd, err
+	}
+	cmd.AddCommand(validateCmd)
 	return cmd, nil
 }
+
+// Stream reads events line by line from the reader and tracks each as it is read, so only a single line is held
+// in memory at a time. Once the context is canceled, such as by a shutdown signal, the rest of the input isn't read,
+// since tracking it may send it, and only what was queued is left to the final flush.
+func (s streamer) Stream(ctx context.Context, reader io.Reader) (batchStats, error) {
+	var stats batchStats
+	err := forEachLine(ctx, reader, func(lineNumber int, line []byte) error {
+		stats.Lines = lineNumber
+		if err := s.trackLine(lineNumber, line, &stats); err != nil {
+			if !s.ContinueOnError {
+				return err
+			}
+
+			stats.Rejected++
+			if err := s.reject(lineNumber, line, err); err != nil {
+				return err
+			}
+		}
+
+		if s.ProgressEvery > 0 && lineNumber%s.ProgressEvery == 0 {
+			s.Printer.ErrPrintf("processed %d lines, queued %d events\n", lineNumber, stats.Sent)
+		}
+		return nil
+	})
+
+	var readErr readError
+	if errors.As(err, &readErr) {
+		s.Printer.ErrPrintf("unable to read: %v\n", readErr.error)
+	}
+	return stats, err
+}
+
+func (s streamer) trackLine(lineNumber int, line []byte, stats *batchStats) error {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+
+	var evt service.Event
+	if err := json.Unmarshal(line, &evt); err != nil {
+		// lines of an unknown type are skipped, unless they're being rejected, so a newer batch file can be sent
+		var unknown service.UnknownKindError
+		if errors.As(err, &unknown) && !s.ContinueOnError {
+			s.Printer.ErrPrintf("skipping event of unknown type %q on line %d\n", unknown.Type, lineNumber)
+			stats.Skipped++
+			return nil
+		}
+
+		if !s.ContinueOnError {
+			s.Printer.ErrPrintf("unable to unmarshal event on line %d: %v -- \n%s", lineNumber, err, line)
+		}
+		return err
+	}
+
+	s.APMer.Track(evt.Item)
+	stats.Sent++
+	return nil
+}
+
+func (s streamer) reject(lineNumber int, line []byte, err error) error {
+	if s.Rejects == nil {
+		s.Printer.ErrPrintf("rejected line %d: %v\n", lineNumber, err)
+		return nil
+	}
+
+	bits, mErr := json.Marshal(rejectedLine{
+		Line:  lineNumber,
+		Error: err.Error(),
+		Event: string(bytes.TrimRight(line, "\r\n")),
+	})
+	if mErr != nil {
+		return mErr
+	}
+
+	if _, wErr := s.Rejects.Write(append(bits, '\n')); wErr != nil {
+		s.Printer.ErrPrintf("unable to write to reject file: %v\n", wErr)
+		return wErr
+	}
+	return nil
+}
+
+// forEachLine calls fn with each line read from the reader and its 1-based line number
+func forEachLine(ctx context.Context, reader io.Reader, fn func(lineNumber int, line []byte) error) error {
+	buffered := bufio.NewReader(reader)
+	lineNumber := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		line, err := buffered.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return readError{err}
+		}
+
+		if len(line) > 0 {
+			lineNumber++
+			if fnErr := fn(lineNumber, line); fnErr != nil {
+				return fnErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package batch
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
 	mocks "github.com/devigned/apmz/internal/test"
 )
@@ -26,6 +33,9 @@ func TestNewBatchCommand(t *testing.T) {
 				if assert.NotNil(t, fp) {
 					assert.Equal(t, fp.Shorthand, "f")
 				}
+				assert.NotNil(t, cmd.Flags().Lookup("progress-every"))
+				assert.NotNil(t, cmd.Flags().Lookup("continue-on-error"))
+				assert.NotNil(t, cmd.Flags().Lookup("reject-file"))
 			},
 		},
 	}
@@ -42,3 +52,94 @@ func TestNewBatchCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamerStream(t *testing.T) {
+	lines := []string{
+		`{"type":"trace/v1","item":{"Message":"one"}}`,
+		``,
+		`{"type":"metric/v1","item":{"Name":"two","Value":2}}`,
+		`{"type":"unknown/v1","item":{}}`,
+		`{"type":"TraceTelemetry","item":{"Message":"three"}}`,
+	}
+
+	apm := new(mocks.APMMock)
+	apm.On("Track", mock.Anything)
+	p := new(mocks.PrinterMock)
+	p.On("ErrPrintf", "skipping event of unknown type %q on line %d\n", mock.Anything).Once()
+	p.On("ErrPrintf", "processed %d lines, queued %d events\n", []interface{}{4, 2}).Once()
+
+	s := streamer{APMer: apm, Printer: p, ProgressEvery: 4}
+	stats, err := s.Stream(context.Background(), strings.NewReader(strings.Join(lines, "\n")))
+	require.NoError(t, err)
+	assert.Equal(t, batchStats{Lines: 5, Sent: 3, Skipped: 1}, stats)
+	apm.AssertNumberOfCalls(t, "Track", 3)
+	p.AssertExpectations(t)
+}
+
+func TestStreamerStreamStopsOnMalformedLine(t *testing.T) {
+	apm := new(mocks.APMMock)
+	apm.On("Track", mock.Anything)
+	p := new(mocks.PrinterMock)
+	p.On("ErrPrintf", mock.Anything, mock.Anything)
+
+	s := streamer{APMer: apm, Printer: p}
+	stats, err := s.Stream(context.Background(), strings.NewReader(malformedInput))
+	assert.Error(t, err)
+	assert.Equal(t, 1, stats.Sent)
+}
+
+func TestStreamerStreamContinueOnError(t *testing.T) {
+	apm := new(mocks.APMMock)
+	apm.On("Track", mock.Anything)
+	p := new(mocks.PrinterMock)
+
+	var rejects bytes.Buffer
+	s := streamer{APMer: apm, Printer: p, ContinueOnError: true, Rejects: &rejects}
+	stats, err := s.Stream(context.Background(), strings.NewReader(malformedInput))
+	require.NoError(t, err)
+	assert.Equal(t, batchStats{Lines: 3, Sent: 2, Rejected: 1}, stats)
+
+	var rejected rejectedLine
+	require.NoError(t, json.Unmarshal(rejects.Bytes(), &rejected))
+	assert.Equal(t, 2, rejected.Line)
+	assert.Equal(t, "{not json", rejected.Event)
+	assert.NotEmpty(t, rejected.Error)
+	p.AssertNotCalled(t, "ErrPrintf", mock.Anything, mock.Anything)
+}
+
+func TestStreamerStreamContinueOnErrorRejectsUnknownTypes(t *testing.T) {
+	apm := new(mocks.APMMock)
+	apm.On("Track", mock.Anything)
+	p := new(mocks.PrinterMock)
+
+	var rejects bytes.Buffer
+	s := streamer{APMer: apm, Printer: p, ContinueOnError: true, Rejects: &rejects}
+	input := `{"type":"trace/v1","item":{"Message":"one"}}` + "\n" + `{"type":"unknown/v1","item":{}}`
+	stats, err := s.Stream(context.Background(), strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, batchStats{Lines: 2, Sent: 1, Rejected: 1}, stats)
+
+	var rejected rejectedLine
+	require.NoError(t, json.Unmarshal(rejects.Bytes(), &rejected))
+	assert.Equal(t, 2, rejected.Line)
+	assert.Contains(t, rejected.Error, "unknown/v1")
+	p.AssertNotCalled(t, "ErrPrintf", mock.Anything, mock.Anything)
+}
+
+func TestStreamerStreamStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	apm := new(mocks.APMMock)
+	apm.On("Track", mock.Anything).Run(func(args mock.Arguments) { cancel() })
+	p := new(mocks.PrinterMock)
+
+	s := streamer{APMer: apm, Printer: p}
+	input := strings.Repeat(`{"type":"trace/v1","item":{"Message":"one"}}`+"\n", 3)
+	stats, err := s.Stream(ctx, strings.NewReader(input))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, batchStats{Lines: 1, Sent: 1}, stats)
+	apm.AssertNumberOfCalls(t, "Track", 1)
+}
+
+const malformedInput = `{"type":"trace/v1","item":{"Message":"one"}}` + "\n{not json\n" + `{"type":"trace/v1","item":{"Message":"two"}}`
@@ -0,0 +1,183 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/spf13/cobra"
+
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/telemetry"
+	"github.com/devigned/apmz/pkg/xcobra"
+)
+
+type (
+	validateArgs struct {
+		FilePath string
+		JSON     bool
+	}
+
+	validationReport struct {
+		Lines    int                 `json:"lines"`
+		Valid    int                 `json:"valid"`
+		Invalid  int                 `json:"invalid"`
+		Problems []validationProblem `json:"problems"`
+	}
+
+	validationProblem struct {
+		Line    int    `json:"line"`
+		Type    string `json:"type,omitempty"`
+		Message string `json:"message"`
+	}
+)
+
+// newValidateCommand creates a new `apmz batch validate` command
+func newValidateCommand(sl service.CommandServicer) (*cobra.Command, error) {
+	var oArgs validateArgs
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "check a batch of telemetry for problems without sending it",
+		Long: "Check a batch of telemetry for problems without sending it. Each line is parsed as an event and checked " +
+			"for required fields, Application Insights name and property length limits and severity ranges. " +
+			fmt.Sprintf("Exits with code %d if any line is invalid.", rejectedExitCode),
+		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			reader := io.Reader(os.Stdin)
+			if oArgs.FilePath != "" {
+				file, err := os.Open(oArgs.FilePath)
+				if err != nil {
+					sl.GetPrinter().ErrPrintf("unable to read file: %v\n", err)
+					return err
+				}
+				defer file.Close()
+				reader = file
+			}
+
+			report, err := validateEvents(ctx, reader)
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("unable to read: %v\n", err)
+				return err
+			}
+
+			if oArgs.JSON {
+				if err := sl.GetPrinter().Print(report); err != nil {
+					sl.GetPrinter().ErrPrintf("unable to print: %v\n", err)
+					return err
+				}
+			} else {
+				for _, p := range report.Problems {
+					sl.GetPrinter().Printf("line %d: %s\n", p.Line, p.Message)
+				}
+				sl.GetPrinter().ErrPrintf("validated %d lines: %d valid, %d invalid\n", report.Lines, report.Valid, report.Invalid)
+			}
+
+			if report.Invalid > 0 {
+				return xcobra.ErrorWithCode{Code: rejectedExitCode}
+			}
+			return nil
+		}),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&oArgs.FilePath, "file-path", "f", "", "file path to json events -- if not specified, then stdin will be assumed")
+	f.BoolVar(&oArgs.JSON, "json", false, "print the validation report as json")
+	return cmd, nil
+}
+
+// validateEvents reads events line by line from the reader and reports the problems found in each
+func validateEvents(ctx context.Context, reader io.Reader) (validationReport, error) {
+	report := validationReport{
+		Problems: []validationProblem{},
+	}
+
+	err := forEachLine(ctx, reader, func(lineNumber int, line []byte) error {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			return nil
+		}
+
+		report.Lines++
+		var problems []validationProblem
+		var evt service.Event
+		if err := json.Unmarshal(line, &evt); err != nil {
+			problems = append(problems, validationProblem{Line: lineNumber, Message: err.Error()})
+		} else {
+			for _, msg := range validateItem(evt.Item) {
+				problems = append(problems, validationProblem{Line: lineNumber, Type: evt.Type, Message: msg})
+			}
+		}
+
+		if len(problems) > 0 {
+			report.Invalid++
+			report.Problems = append(report.Problems, problems...)
+		} else {
+			report.Valid++
+		}
+		return nil
+	})
+
+	return report, err
+}
+
+// validateItem checks a telemetry item for missing required fields, values out of range and fields exceeding the
+// Application Insights length limits
+func validateItem(item apmz.Telemetry) []string {
+	var problems []string
+	required := func(field, value string) {
+		if value == "" {
+			problems = append(problems, fmt.Sprintf("%s is required", field))
+		}
+	}
+
+	severity := func(level contracts.SeverityLevel) {
+		if level < contracts.Verbose || level > contracts.Critical {
+			problems = append(problems, fmt.Sprintf("severity level %d is not in the range %d to %d", level, contracts.Verbose, contracts.Critical))
+		}
+	}
+
+	nonNegative := func(field string, value float64) {
+		if value < 0 {
+			problems = append(problems, fmt.Sprintf("%s must not be negative", field))
+		}
+	}
+
+	switch t := item.(type) {
+	case *apmz.TraceTelemetry:
+		required("Message", t.Message)
+		severity(t.SeverityLevel)
+	case *apmz.MetricTelemetry:
+		required("Name", t.Name)
+		if math.IsNaN(t.Value) || math.IsInf(t.Value, 0) {
+			problems = append(problems, "Value must be a finite number")
+		}
+	case *apmz.EventTelemetry:
+		required("Name", t.Name)
+	case *apmz.RemoteDependencyTelemetry:
+		required("Name", t.Name)
+		nonNegative("Duration", float64(t.Duration))
+	case *apmz.RequestTelemetry:
+		required("Name", t.Name)
+		nonNegative("Duration", float64(t.Duration))
+	case *apmz.AvailabilityTelemetry:
+		required("Name", t.Name)
+		nonNegative("Duration", float64(t.Duration))
+	case *telemetry.ExceptionTelemetry:
+		if t.Message == "" && t.TypeName == "" {
+			problems = append(problems, "Message or TypeName is required")
+		}
+		severity(t.SeverityLevel)
+	}
+
+	// sanitizing reports each field which exceeds the Application Insights length limits
+	problems = append(problems, item.TelemetryData().Sanitize()...)
+	if tags := item.ContextTags(); tags != nil {
+		problems = append(problems, contracts.SanitizeTags(tags)...)
+	}
+	return problems
+}
@@ -0,0 +1,118 @@
+package batch
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mocks "github.com/devigned/apmz/internal/test"
+)
+
+func TestNewValidateCommand(t *testing.T) {
+	cases := []struct {
+		name       string
+		setup      func(t *testing.T) *mocks.ServiceMock
+		assertions func(t *testing.T, cmd *cobra.Command)
+	}{
+		{
+			name: "CommandConstruction",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				return nil
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				assert.Equal(t, "validate", cmd.Name())
+				fp := cmd.Flags().Lookup("file-path")
+				if assert.NotNil(t, fp) {
+					assert.Equal(t, fp.Shorthand, "f")
+				}
+				assert.NotNil(t, cmd.Flags().Lookup("json"))
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s := c.setup(t)
+			cmd, err := newValidateCommand(s)
+			assert.NoError(t, err)
+			assert.NotNil(t, cmd)
+			c.assertions(t, cmd)
+		})
+	}
+}
+
+func TestValidateEvents(t *testing.T) {
+	cases := []struct {
+		name     string
+		line     string
+		problems []string
+	}{
+		{
+			name: "ValidTrace",
+			line: `{"type":"trace/v1","item":{"Message":"foo","SeverityLevel":3}}`,
+		},
+		{
+			name:     "TraceSeverityOutOfRange",
+			line:     `{"type":"trace/v1","item":{"Message":"foo","SeverityLevel":5}}`,
+			problems: []string{"severity level 5 is not in the range 0 to 4"},
+		},
+		{
+			name:     "MetricMissingName",
+			line:     `{"type":"MetricTelemetry","item":{"Value":1}}`,
+			problems: []string{"Name is required"},
+		},
+		{
+			name:     "EventNameTooLong",
+			line:     `{"type":"event/v1","item":{"Name":"` + strings.Repeat("a", 513) + `"}}`,
+			problems: []string{"EventData.Name exceeded maximum length of 512"},
+		},
+		{
+			name:     "PropertyKeyTooLong",
+			line:     `{"type":"event/v1","item":{"Name":"foo","Properties":{"` + strings.Repeat("k", 151) + `":"v"}}}`,
+			problems: []string{"EventData.Properties has key with length exceeding max of 150: " + strings.Repeat("k", 151)},
+		},
+		{
+			name:     "NegativeDuration",
+			line:     `{"type":"request/v1","item":{"Name":"foo","Duration":-1}}`,
+			problems: []string{"Duration must not be negative"},
+		},
+		{
+			name:     "UnknownType",
+			line:     `{"type":"pageview/v1","item":{}}`,
+			problems: []string{"don't know how to unmarshal type: pageview/v1"},
+		},
+		{
+			name:     "Malformed",
+			line:     `{not json`,
+			problems: []string{"invalid character 'n' looking for beginning of object key string"},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			report, err := validateEvents(context.Background(), strings.NewReader("\n"+c.line+"\n"))
+			require.NoError(t, err)
+			assert.Equal(t, 1, report.Lines)
+
+			actual := make([]string, len(report.Problems))
+			for i, p := range report.Problems {
+				assert.Equal(t, 2, p.Line)
+				actual[i] = p.Message
+			}
+			assert.ElementsMatch(t, c.problems, actual)
+
+			if len(c.problems) == 0 {
+				assert.Equal(t, 1, report.Valid)
+			} else {
+				assert.Equal(t, 1, report.Invalid)
+			}
+		})
+	}
+}
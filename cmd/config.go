@@ -0,0 +1,347 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/devigned/apmz/pkg/config"
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/xcobra"
+)
+
+var (
+	// settingsFlags choose where the values of the other global flags come from, so a profile can't set them
+	settingsFlags = map[string]bool{"config": true, "profile": true, "env-file": true}
+)
+
+const (
+	// skipProfileAnnotation marks commands which the profile isn't applied to
+	skipProfileAnnotation = "apmz.skip-profile"
+)
+
+// loadSettings fills in the flags of the command which weren't given on the command line; first from the
+// environment, after loading the env file into it, and then the global flags from the profile of the config files
+func loadSettings(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+
+	// the env file can be chosen by the environment, so its flag is bound before the file is loaded
+	if err := bindEnvFlag(cmd, flags.Lookup("env-file")); err != nil {
+		return err
+	}
+
+	envFile, _ := flags.GetString("env-file")
+	if envFile != "" {
+		// the .env in the working directory is loaded if there is one, but a file which was asked for must load
+		if err := godotenv.Load(envFile); err != nil && flags.Changed("env-file") {
+			return fmt.Errorf("unable to load env file %s: %v", envFile, err)
+		}
+	}
+
+	if err := bindEnv(cmd); err != nil {
+		return err
+	}
+
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Annotations[skipProfileAnnotation] != "" {
+			return nil
+		}
+	}
+
+	path, _ := flags.GetString("config")
+	c, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	name, _ := flags.GetString("profile")
+	p, err := c.Select(name)
+	if err != nil {
+		return err
+	}
+
+	if err := applyProfile(cmd.Root().PersistentFlags(), p); err != nil {
+		return fmt.Errorf("profile %q: %v", c.ProfileName(name), err)
+	}
+	return nil
+}
+
+// loadConfig reads the config file given by --config, or else the system and user config files
+func loadConfig(path string) (*config.Config, error) {
+	if path != "" {
+		return config.LoadFile(path)
+	}
+
+	userPath, err := config.UserPath()
+	if err != nil {
+		return config.Load(config.SystemPath)
+	}
+	return config.Load(config.SystemPath, userPath)
+}
+
+// applyProfile sets the flags which haven't been set already to the values of the profile
+func applyProfile(flags *pflag.FlagSet, p config.Profile) error {
+	for _, key := range p.Keys() {
+		f := flags.Lookup(key)
+		if f == nil || settingsFlags[key] {
+			return fmt.Errorf("%s is not a global flag which can be set by a profile", key)
+		}
+
+		if f.Changed {
+			continue
+		}
+
+		values, err := config.Values(p[key])
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+
+		if len(values) > 1 && !isCSVFlag(f) {
+			return fmt.Errorf("%s takes a single value", key)
+		}
+
+		for _, v := range values {
+			if isCSVFlag(f) {
+				// the flag splits its values on commas, so each is quoted to keep commas within it
+				v = `"` + strings.Replace(v, `"`, `""`, -1) + `"`
+			}
+
+			if err := flags.Set(key, v); err != nil {
+				return fmt.Errorf("invalid value for %s: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isCSVFlag is true for flags which take comma separated values, such as --api-keys or --global-tags
+func isCSVFlag(f *pflag.Flag) bool {
+	switch f.Value.Type() {
+	case "stringSlice", "stringToString":
+		return true
+	default:
+		return false
+	}
+}
+
+// profileValue turns the text of a flag value into the value stored in a profile; a list for flags which take
+// several values, a map for flags of key=value pairs, and a bool or number for flags of those types, so the config
+// file reads naturally
+func profileValue(f *pflag.Flag, text string) (interface{}, error) {
+	switch f.Value.Type() {
+	case "stringSlice", "stringToString":
+		fields, err := csv.NewReader(strings.NewReader(text)).Read()
+		if err != nil {
+			return nil, err
+		}
+
+		if f.Value.Type() == "stringSlice" {
+			list := make([]interface{}, len(fields))
+			for i, field := range fields {
+				list[i] = field
+			}
+			return list, nil
+		}
+
+		m := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("%s must be formatted as key=value", field)
+			}
+			m[kv[0]] = kv[1]
+		}
+		return m, nil
+	case "bool":
+		return strconv.ParseBool(text)
+	case "int", "int64":
+		return strconv.ParseInt(text, 10, 64)
+	case "float64":
+		return strconv.ParseFloat(text, 64)
+	default:
+		return text, nil
+	}
+}
+
+// newConfigCommandGroup creates the `apmz config` commands, which read and write the profiles of the config file
+func newConfigCommandGroup(sl service.CommandServicer) (*cobra.Command, error) {
+	rootCmd := &cobra.Command{
+		Use:   "config",
+		Short: "get, set and list the global flag values of a config file profile",
+		// the profile isn't applied, so a profile with a bad value can still be fixed
+		Annotations: map[string]string{skipProfileAnnotation: "true"},
+	}
+
+	cmdFuncs := []func(locator service.CommandServicer) (*cobra.Command, error){
+		newConfigListCommand,
+		newConfigGetCommand,
+		newConfigSetCommand,
+	}
+
+	for _, f := range cmdFuncs {
+		cmd, err := f(sl)
+		if err != nil {
+			return rootCmd, err
+		}
+		rootCmd.AddCommand(cmd)
+	}
+
+	return rootCmd, nil
+}
+
+// newConfigListCommand creates a new `apmz config list` command
+func newConfigListCommand(sl service.CommandServicer) (*cobra.Command, error) {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "prints the flag values of the profile, merged from the system and user config files",
+		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			_, p, err := selectProfile(cmd)
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("%v\n", err)
+				return err
+			}
+
+			if p == nil {
+				p = config.Profile{}
+			}
+			if err := sl.GetPrinter().Print(p); err != nil {
+				sl.GetPrinter().ErrPrintf("unable to print: %v\n", err)
+				return err
+			}
+			return nil
+		}),
+	}
+
+	return cmd, nil
+}
+
+// newConfigGetCommand creates a new `apmz config get` command
+func newConfigGetCommand(sl service.CommandServicer) (*cobra.Command, error) {
+	cmd := &cobra.Command{
+		Use:   "get <flag>",
+		Short: "prints the value of a flag in the profile; lists and maps are formatted by --format, and others print raw",
+		Args:  cobra.ExactArgs(1),
+		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			name, p, err := selectProfile(cmd)
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("%v\n", err)
+				return err
+			}
+
+			v, ok := p[args[0]]
+			if !ok {
+				err := fmt.Errorf("%s is not set in profile %q", args[0], name)
+				sl.GetPrinter().ErrPrintf("%v\n", err)
+				return err
+			}
+
+			switch v.(type) {
+			case []interface{}, map[string]interface{}:
+				if err := sl.GetPrinter().Print(v); err != nil {
+					sl.GetPrinter().ErrPrintf("unable to print: %v\n", err)
+					return err
+				}
+				return nil
+			default:
+				sl.GetPrinter().Printf("%v\n", v)
+				return nil
+			}
+		}),
+	}
+
+	return cmd, nil
+}
+
+// newConfigSetCommand creates a new `apmz config set` command
+func newConfigSetCommand(sl service.CommandServicer) (*cobra.Command, error) {
+	cmd := &cobra.Command{
+		Use:   "set <flag> <value>",
+		Short: "sets the value of a global flag in the profile of the user config file, or the file given by --config; eg 'apmz config set api-keys key1,key2'",
+		Args:  cobra.ExactArgs(2),
+		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			key, text := args[0], args[1]
+			path, _ := cmd.Flags().GetString("config")
+			merged, err := loadConfig(path)
+			if os.IsNotExist(err) {
+				merged, err = new(config.Config), nil
+			}
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("%v\n", err)
+				return err
+			}
+
+			// the profile is created if it doesn't exist, in the file which would be read if it did
+			profile, _ := cmd.Flags().GetString("profile")
+			name := merged.ProfileName(profile)
+
+			if path == "" {
+				if path, err = config.UserPath(); err != nil {
+					sl.GetPrinter().ErrPrintf("unable to find the user config file: %v\n", err)
+					return err
+				}
+			}
+
+			// the value is checked against the flags of a new command, so checking it has no effect on this one
+			root, err := newRootCommand()
+			if err != nil {
+				return err
+			}
+
+			f := root.PersistentFlags().Lookup(key)
+			if f == nil || settingsFlags[key] {
+				err := fmt.Errorf("%s is not a global flag which can be set by a profile", key)
+				sl.GetPrinter().ErrPrintf("%v\n", err)
+				return err
+			}
+
+			v, err := profileValue(f, text)
+			if err != nil {
+				err = fmt.Errorf("invalid value for %s: %v", key, err)
+			} else {
+				err = applyProfile(root.PersistentFlags(), config.Profile{key: v})
+			}
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("%v\n", err)
+				return err
+			}
+
+			c, err := config.LoadFile(path)
+			if os.IsNotExist(err) {
+				c, err = new(config.Config), nil
+			}
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("%v\n", err)
+				return err
+			}
+
+			c.Merge(&config.Config{Profiles: map[string]config.Profile{name: {key: v}}})
+			if err := c.Save(path); err != nil {
+				sl.GetPrinter().ErrPrintf("unable to save config file %s: %v\n", path, err)
+				return err
+			}
+			return nil
+		}),
+	}
+
+	return cmd, nil
+}
+
+// selectProfile returns the name and values of the profile chosen by the --config and --profile flags
+func selectProfile(cmd *cobra.Command) (string, config.Profile, error) {
+	path, _ := cmd.Flags().GetString("config")
+	c, err := loadConfig(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name, _ := cmd.Flags().GetString("profile")
+	p, err := c.Select(name)
+	return c.ProfileName(name), p, err
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/config"
+)
+
+func TestLoadSettingsPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(`profile: ci
+profiles:
+  ci:
+    api-keys: ["InstrumentationKey=key;IngestionEndpoint=https://example.com/", key2]
+    global-tags: {env: ci, team: ops}
+    operation-name: from-profile
+    cloud-role: from-profile
+    sample-rate: 50
+    flush-timeout: 5s
+`), 0600))
+
+	envPath := filepath.Join(dir, "apmz.env")
+	require.NoError(t, ioutil.WriteFile(envPath, []byte("APMZ_OPERATION_NAME=from-env-file\nAPMZ_CLOUD_ROLE_INSTANCE=from-env-file\n"), 0600))
+	require.NoError(t, os.Setenv("APMZ_CLOUD_ROLE_INSTANCE", "from-env"))
+	defer os.Unsetenv("APMZ_CLOUD_ROLE_INSTANCE")
+	defer os.Unsetenv("APMZ_OPERATION_NAME")
+
+	root, err := newRootCommand()
+	require.NoError(t, err)
+	require.NoError(t, root.ParseFlags([]string{"--config", configPath, "--env-file", envPath, "--cloud-role", "from-flag"}))
+	require.NoError(t, loadSettings(root))
+
+	flags := root.PersistentFlags()
+	keys, err := flags.GetStringSlice("api-keys")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"InstrumentationKey=key;IngestionEndpoint=https://example.com/", "key2"}, keys)
+
+	tags, err := flags.GetStringToString("global-tags")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "ci", "team": "ops"}, tags)
+
+	expected := map[string]string{
+		"cloud-role":          "from-flag",
+		"operation-name":      "from-env-file",
+		"cloud-role-instance": "from-env",
+		"sample-rate":         "50",
+		"flush-timeout":       "5s",
+	}
+	for name, value := range expected {
+		assert.Equal(t, value, flags.Lookup(name).Value.String(), name)
+	}
+}
+
+func TestLoadSettingsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(`profiles:
+  unknown:
+    nope: true
+  settings:
+    config: other.yaml
+  invalid:
+    sample-rate: lots
+  single:
+    operation-name: [a, b]
+`), 0600))
+
+	cases := map[string][]string{
+		`profile "missing" not found`: {"--profile", "missing"},
+		`profile "unknown": nope is not a global flag which can be set by a profile`:    {"--profile", "unknown"},
+		`profile "settings": config is not a global flag which can be set by a profile`: {"--profile", "settings"},
+		`profile "single": operation-name takes a single value`:                         {"--profile", "single"},
+		"unable to load env file": {"--env-file", filepath.Join(dir, "missing.env")},
+	}
+
+	for msg, args := range cases {
+		root, err := newRootCommand()
+		require.NoError(t, err)
+		require.NoError(t, root.ParseFlags(append([]string{"--config", configPath}, args...)))
+		err = loadSettings(root)
+		if assert.Error(t, err, msg) {
+			assert.Contains(t, err.Error(), msg)
+		}
+	}
+
+	root, err := newRootCommand()
+	require.NoError(t, err)
+	require.NoError(t, root.ParseFlags([]string{"--config", configPath, "--profile", "invalid"}))
+	assert.Error(t, loadSettings(root))
+}
+
+func TestConfigSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "apmz", "config.yaml")
+	sets := [][]string{
+		{"api-keys", `key1,"InstrumentationKey=key2;IngestionEndpoint=https://example.com/"`},
+		{"global-tags", "env=prod,team=ops"},
+		{"sample-rate", "12.5"},
+		{"statsd-events", "true"},
+		{"spool-max-bytes", "1024"},
+		{"flush-timeout", "10s"},
+	}
+	for _, set := range sets {
+		root, err := newRootCommand()
+		require.NoError(t, err)
+		root.SetArgs(append([]string{"config", "set", "--config", configPath}, set...))
+		require.NoError(t, root.Execute())
+	}
+
+	root, err := newRootCommand()
+	require.NoError(t, err)
+	root.SetArgs([]string{"config", "set", "--config", configPath, "--profile", "ci", "operation-name", "nightly"})
+	require.NoError(t, root.Execute())
+
+	c, err := config.LoadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]config.Profile{
+		config.DefaultProfile: {
+			"api-keys":        []interface{}{"key1", "InstrumentationKey=key2;IngestionEndpoint=https://example.com/"},
+			"global-tags":     map[string]interface{}{"env": "prod", "team": "ops"},
+			"sample-rate":     12.5,
+			"statsd-events":   true,
+			"spool-max-bytes": 1024,
+			"flush-timeout":   "10s",
+		},
+		"ci": {"operation-name": "nightly"},
+	}, c.Profiles)
+}
+
+func TestProfileValueErrors(t *testing.T) {
+	root, err := newRootCommand()
+	require.NoError(t, err)
+
+	cases := map[string]string{
+		"statsd-events": "yes please",
+		"global-tags":   "env",
+		"sample-rate":   "lots",
+		"api-keys":      `"unterminated`,
+	}
+	for name, text := range cases {
+		_, err := profileValue(root.PersistentFlags().Lookup(name), text)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,73 @@
+package dependency
+
+import (
+	"context"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/spf13/cobra"
+
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/xcobra"
+)
+
+type (
+	dependencyArgs struct {
+		Name       string
+		Type       string
+		Target     string
+		Data       string
+		ID         string
+		ResultCode string
+		Success    bool
+		Duration   time.Duration
+		Start      int64
+		Tags       map[string]string
+	}
+)
+
+// NewDependencyCommand creates a new `apmz dependency` command
+func NewDependencyCommand(sl service.CommandServicer) (*cobra.Command, error) {
+	var oArgs dependencyArgs
+	cmd := &cobra.Command{
+		Use:   "dependency",
+		Short: "send a remote dependency call (dependencies) to Application Insights",
+		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			dep := apmz.NewRemoteDependencyTelemetry(oArgs.Name, oArgs.Type, oArgs.Target, oArgs.Success)
+			dep.Data = oArgs.Data
+			dep.ID = oArgs.ID
+			dep.ResultCode = oArgs.ResultCode
+			dep.Duration = oArgs.Duration
+			if oArgs.Start != 0 {
+				dep.Timestamp = time.Unix(0, oArgs.Start)
+			}
+
+			for k, v := range oArgs.Tags {
+				dep.Properties[k] = v
+			}
+
+			apmer, err := sl.GetAPMer()
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("unable to create App Insight client: %v\n", err)
+				return err
+			}
+
+			apmer.Track(dep)
+			return nil
+		}),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&oArgs.Name, "name", "n", "", "name of the command that initiated the dependency call; eg 'az vm list'")
+	f.StringVar(&oArgs.Type, "type", "", "dependency type name; eg 'HTTP', 'SQL', 'Azure CLI'")
+	f.StringVar(&oArgs.Target, "target", "", "target site of the dependency call; eg server name or host address")
+	f.StringVar(&oArgs.Data, "data", "", "command initiated by the dependency call; eg the full command line or URL")
+	f.StringVar(&oArgs.ID, "id", "", "identifier of the dependency call instance used for correlation")
+	f.StringVarP(&oArgs.ResultCode, "result-code", "r", "", "result code of the dependency call; eg the exit or HTTP status code")
+	f.BoolVarP(&oArgs.Success, "success", "s", true, "indication of a successful or unsuccessful call")
+	f.DurationVarP(&oArgs.Duration, "duration", "d", 0, "duration of the dependency call; eg '1.5s' or '300ms'")
+	f.Int64Var(&oArgs.Start, "start", 0, "start time of the dependency call in unixnano format; defaults to now")
+	f.StringToStringVarP(&oArgs.Tags, "tags", "t", map[string]string{}, "custom tags to be applied to the dependency formatted as key=value")
+	err := cmd.MarkFlagRequired("name")
+	return cmd, err
+}
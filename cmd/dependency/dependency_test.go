@@ -0,0 +1,82 @@
+package dependency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	mocks "github.com/devigned/apmz/internal/test"
+)
+
+func TestNewDependencyCommand(t *testing.T) {
+	cases := []struct {
+		name       string
+		setup      func(t *testing.T) *mocks.ServiceMock
+		assertions func(t *testing.T, cmd *cobra.Command)
+	}{
+		{
+			name: "CommandConstruction",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				return nil
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				assert.Equal(t, "dependency", cmd.Name())
+				name := cmd.Flags().Lookup("name")
+				if assert.NotNil(t, name) {
+					assert.Equal(t, name.Shorthand, "n")
+				}
+				tags := cmd.Flags().Lookup("tags")
+				if assert.NotNil(t, tags) {
+					assert.Equal(t, tags.Shorthand, "t")
+				}
+				duration := cmd.Flags().Lookup("duration")
+				if assert.NotNil(t, duration) {
+					assert.Equal(t, duration.Shorthand, "d")
+				}
+				assert.NotNil(t, cmd.Flags().Lookup("type"))
+				assert.NotNil(t, cmd.Flags().Lookup("target"))
+				assert.NotNil(t, cmd.Flags().Lookup("result-code"))
+				assert.NotNil(t, cmd.Flags().Lookup("success"))
+			},
+		},
+		{
+			name: "WithFailedCall",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				sl := new(mocks.ServiceMock)
+				apm := new(mocks.APMMock)
+				apm.On("Track", mock.MatchedBy(func(item apmz.Telemetry) bool {
+					dep, ok := item.(*apmz.RemoteDependencyTelemetry)
+					return ok && dep.Name == "psql" &&
+						dep.Type == "SQL" &&
+						dep.Target == "db.example.com" &&
+						dep.ResultCode == "2" &&
+						!dep.Success &&
+						dep.Duration == 1500*time.Millisecond &&
+						dep.Timestamp.Equal(time.Unix(0, 1579309455000000000))
+				}))
+				sl.On("GetAPMer").Return(apm, nil)
+				return sl
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				cmd.SetArgs([]string{"-n", "psql", "--type", "SQL", "--target", "db.example.com", "-r", "2", "-s=false", "-d", "1.5s", "--start", "1579309455000000000"})
+				assert.NoError(t, cmd.Execute())
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s := c.setup(t)
+			cmd, err := NewDependencyCommand(s)
+			assert.NoError(t, err)
+			assert.NotNil(t, cmd)
+			c.assertions(t, cmd)
+		})
+	}
+}
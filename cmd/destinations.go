@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/devigned/apmz-sdk/apmz"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/eventfile"
+	"github.com/devigned/apmz/pkg/otlp"
+	"github.com/devigned/apmz/pkg/prometheus"
+	"github.com/devigned/apmz/pkg/route"
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/statsd"
+	"github.com/devigned/apmz/pkg/webhook"
+)
+
+type (
+	// destinationSettings are the settings from other flags applied to the destinations of each kind
+	destinationSettings struct {
+		ChannelOptions   []channel.Option
+		Tags             map[string]string
+		OTLPHeaders      map[string]string
+		StatsdMetricType string
+		StatsdEvents     bool
+	}
+)
+
+// loadDestinations reads the destinations from the routes file, if any, and applies the destination URIs given as
+// flags, which replace the file's destinations with the same URI
+func loadDestinations(routesFile string, uris []string) ([]route.Destination, error) {
+	var destinations []route.Destination
+	if routesFile != "" {
+		var err error
+		if destinations, err = route.Load(routesFile); err != nil {
+			return nil, err
+		}
+	}
+
+	overrides := make([]route.Destination, len(uris))
+	for i, uri := range uris {
+		d, err := route.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		overrides[i] = d
+	}
+	return route.Merge(destinations, overrides), nil
+}
+
+// newDestination creates the Application Insights client or sink for a destination, which is only sent the
+// telemetry matching the destination's rule
+func newDestination(d route.Destination, settings destinationSettings) (apmz.TelemetryClient, service.APMer, error) {
+	filter, err := route.NewFilter(d.Match)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u, err := url.Parse(d.URI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := u.Query()
+	var sink service.APMer
+	switch u.Scheme {
+	case route.AppInsights:
+		key := u.Host
+		if endpoint := query.Get("endpoint"); endpoint != "" {
+			key = fmt.Sprintf("InstrumentationKey=%s;IngestionEndpoint=%s", u.Host, endpoint)
+		}
+
+		client, err := newTelemetryClient(key, settings.ChannelOptions...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// items replayed by batch may have no tags of their own, so the client applies them too
+		for k, v := range settings.Tags {
+			client.Context().Tags[k] = v
+		}
+		if d.Match.IsZero() {
+			return client, nil, nil
+		}
+		return filter.Client(client), nil, nil
+	case route.OTLP, route.OTLPHTTPS:
+		sink, err = otlp.New(httpURL(u, route.OTLPHTTPS), otlp.WithHeaders(settings.OTLPHeaders), otlp.WithTags(settings.Tags))
+	case route.Pushgateway, route.PushgatewayHTTPS:
+		sink, err = prometheus.NewPushgateway(httpURL(u, route.PushgatewayHTTPS), prometheus.WithTags(settings.Tags))
+	case route.Textfile:
+		var opts []prometheus.Option
+		if name := query.Get("file"); name != "" {
+			opts = append(opts, prometheus.WithFileName(name))
+		}
+		sink, err = prometheus.NewTextfile(u.Path, opts...)
+	case route.StatsD:
+		sink, err = newStatsdSink(u, settings)
+	case route.File:
+		sink = eventfile.New(u.Path)
+	case route.HTTP, route.HTTPS:
+		sink, err = webhook.New(d.URI)
+	default:
+		err = fmt.Errorf("destination %q has an unknown scheme", d.URI)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+	if d.Match.IsZero() {
+		return nil, sink, nil
+	}
+	return nil, filter.Sink(sink), nil
+}
+
+// newStatsdSink creates a StatsD sink for a statsd://host:port or statsd:///path/to/socket destination, where the
+// type and events query parameters override the StatsD flags
+func newStatsdSink(u *url.URL, settings destinationSettings) (service.APMer, error) {
+	address := u.Host
+	if address == "" {
+		address = "unix://" + u.Path
+	}
+
+	metricType := settings.StatsdMetricType
+	if t := u.Query().Get("type"); t != "" {
+		metricType = t
+	}
+
+	events := settings.StatsdEvents
+	if e := u.Query().Get("events"); e != "" {
+		var err error
+		if events, err = strconv.ParseBool(e); err != nil {
+			return nil, fmt.Errorf("StatsD events %q must be true or false", e)
+		}
+	}
+
+	opts := []statsd.Option{statsd.WithMetricType(statsd.MetricType(metricType)), statsd.WithTags(settings.Tags)}
+	if events {
+		opts = append(opts, statsd.WithEvents())
+	}
+	return statsd.New(address, opts...)
+}
+
+// httpURL turns a destination URI into the http url of the endpoint, using https for the secure scheme
+func httpURL(u *url.URL, secureScheme string) string {
+	endpoint := *u
+	endpoint.Scheme = "http"
+	if u.Scheme == secureScheme {
+		endpoint.Scheme = "https"
+	}
+	return endpoint.String()
+}
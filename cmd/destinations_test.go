@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/route"
+)
+
+func TestLoadDestinationsAppliesFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-routes")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "routes.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`destinations:
+  - uri: appinsights://key
+  - uri: https://hooks.example.com/alerts
+    match:
+      severity: error
+`), 0644))
+
+	destinations, err := loadDestinations(path, []string{"https://hooks.example.com/alerts#severity=critical", "otlp://localhost:4318"})
+	require.NoError(t, err)
+	assert.Equal(t, []route.Destination{
+		{URI: "appinsights://key"},
+		{URI: "https://hooks.example.com/alerts", Match: route.Rule{Severity: "critical"}},
+		{URI: "otlp://localhost:4318"},
+	}, destinations)
+}
+
+func TestNewDestination(t *testing.T) {
+	client, sink, err := newDestination(route.Destination{URI: "appinsights://key?endpoint=https://westus2-0.in.applicationinsights.azure.com/"}, destinationSettings{})
+	require.NoError(t, err)
+	assert.Nil(t, sink)
+	assert.Equal(t, "key", client.InstrumentationKey())
+	assert.Equal(t, "https://westus2-0.in.applicationinsights.azure.com/v2/track", client.Channel().EndpointAddress())
+
+	for _, uri := range []string{"otlp+https://collector:4318", "pushgateway://localhost:9091", "textfile:///var/lib/node_exporter", "statsd:///var/run/dsd.socket", "file:///var/log/apmz.jsonl", "https://hooks.example.com/alerts"} {
+		client, sink, err := newDestination(route.Destination{URI: uri, Match: route.Rule{Severity: "error"}}, destinationSettings{StatsdMetricType: "gauge"})
+		require.NoError(t, err, uri)
+		assert.Nil(t, client, uri)
+		assert.NotNil(t, sink, uri)
+	}
+
+	_, _, err = newDestination(route.Destination{URI: "statsd://localhost:8125?type=counter"}, destinationSettings{StatsdMetricType: "gauge"})
+	assert.Error(t, err)
+}
+
+func TestHTTPURL(t *testing.T) {
+	u, err := url.Parse("otlp+https://collector:4318/prefix")
+	require.NoError(t, err)
+	assert.Equal(t, "https://collector:4318/prefix", httpURL(u, route.OTLPHTTPS))
+
+	u, err = url.Parse("otlp://localhost:4318")
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:4318", httpURL(u, route.OTLPHTTPS))
+}
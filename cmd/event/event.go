@@ -0,0 +1,62 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/spf13/cobra"
+
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/xcobra"
+)
+
+type (
+	eventArgs struct {
+		Name         string
+		Tags         map[string]string
+		Measurements map[string]string
+	}
+)
+
+// NewEventCommand creates a new `apmz event` command
+func NewEventCommand(sl service.CommandServicer) (*cobra.Command, error) {
+	var oArgs eventArgs
+	cmd := &cobra.Command{
+		Use:   "event",
+		Short: "send a custom event (customEvents) to Application Insights",
+		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			event := apmz.NewEventTelemetry(oArgs.Name)
+			for k, v := range oArgs.Tags {
+				event.Properties[k] = v
+			}
+
+			for k, v := range oArgs.Measurements {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					err = fmt.Errorf("measurement %q must be a float64: %w", k, err)
+					sl.GetPrinter().ErrPrintf("%v\n", err)
+					return err
+				}
+				event.Measurements[k] = f
+			}
+
+			apmer, err := sl.GetAPMer()
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("unable to create App Insight client: %v\n", err)
+				return err
+			}
+
+			apmer.Track(event)
+			return nil
+		}),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&oArgs.Name, "name", "n", "", "custom event name")
+	f.StringToStringVarP(&oArgs.Tags, "tags", "t", map[string]string{}, "custom tags to be applied to the event formatted as key=value")
+	f.StringToStringVarP(&oArgs.Measurements, "measurements", "m", map[string]string{}, "numeric measurements to be applied to the event formatted as key=float64")
+	err := cmd.MarkFlagRequired("name")
+	return cmd, err
+}
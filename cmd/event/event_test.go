@@ -0,0 +1,73 @@
+package event
+
+import (
+	"testing"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	mocks "github.com/devigned/apmz/internal/test"
+)
+
+func TestNewEventCommand(t *testing.T) {
+	cases := []struct {
+		name       string
+		setup      func(t *testing.T) *mocks.ServiceMock
+		assertions func(t *testing.T, cmd *cobra.Command)
+	}{
+		{
+			name: "CommandConstruction",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				return nil
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				assert.Equal(t, "event", cmd.Name())
+				name := cmd.Flags().Lookup("name")
+				if assert.NotNil(t, name) {
+					assert.Equal(t, name.Shorthand, "n")
+				}
+				tags := cmd.Flags().Lookup("tags")
+				if assert.NotNil(t, tags) {
+					assert.Equal(t, tags.Shorthand, "t")
+				}
+				measurements := cmd.Flags().Lookup("measurements")
+				if assert.NotNil(t, measurements) {
+					assert.Equal(t, measurements.Shorthand, "m")
+				}
+			},
+		},
+		{
+			name: "WithMeasurements",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				sl := new(mocks.ServiceMock)
+				apm := new(mocks.APMMock)
+				apm.On("Track", mock.MatchedBy(func(item apmz.Telemetry) bool {
+					evt, ok := item.(*apmz.EventTelemetry)
+					return ok && evt.Name == "deploy-started" &&
+						evt.Properties["env"] == "prod" &&
+						evt.Measurements["nodes"] == 3
+				}))
+				sl.On("GetAPMer").Return(apm, nil)
+				return sl
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				cmd.SetArgs([]string{"-n", "deploy-started", "-t", "env=prod", "-m", "nodes=3"})
+				assert.NoError(t, cmd.Execute())
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s := c.setup(t)
+			cmd, err := NewEventCommand(s)
+			assert.NoError(t, err)
+			assert.NotNil(t, cmd)
+			c.assertions(t, cmd)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package exception
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/spf13/cobra"
+
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/telemetry"
+	"github.com/devigned/apmz/pkg/xcobra"
+)
+
+type (
+	exceptionArgs struct {
+		Message    string
+		TypeName   string
+		ProblemID  string
+		Level      int
+		FuncNames  []string
+		Sources    []string
+		Lines      []string
+		StackStdin bool
+		Tags       map[string]string
+	}
+)
+
+// NewExceptionCommand creates a new `apmz exception` command
+func NewExceptionCommand(sl service.CommandServicer) (*cobra.Command, error) {
+	var oArgs exceptionArgs
+	cmd := &cobra.Command{
+		Use:   "exception",
+		Short: "send an exception (exceptions) with a call stack to Application Insights",
+		Long: "Send an exception with a call stack to Application Insights. The stack can be provided as bash frames " +
+			"via --funcnames, --sources and --lines or as a Go-style stack trace on stdin via --stack-stdin.\n\n" +
+			"From within a bash function, the frames of the caller can be passed as:\n" +
+			`  --funcnames "$(IFS=,; echo "${FUNCNAME[*]:1}")" --sources "$(IFS=,; echo "${BASH_SOURCE[*]:1}")" --lines "$(IFS=,; echo "${BASH_LINENO[*]}")"`,
+		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			exception := telemetry.NewExceptionTelemetry(oArgs.TypeName, oArgs.Message)
+			exception.ProblemID = oArgs.ProblemID
+			exception.SeverityLevel = contracts.SeverityLevel(oArgs.Level)
+
+			if oArgs.StackStdin {
+				// the whole of stdin is the raw stack, though only the frames of the first goroutine are parsed
+				stack, err := ioutil.ReadAll(cmd.InOrStdin())
+				if err != nil {
+					sl.GetPrinter().ErrPrintf("unable to read stack from stdin: %v\n", err)
+					return err
+				}
+
+				msg, frames, err := telemetry.ParseGoStack(bytes.NewReader(stack))
+				if err != nil {
+					sl.GetPrinter().ErrPrintf("unable to parse stack from stdin: %v\n", err)
+					return err
+				}
+				exception.Stack = string(stack)
+				exception.Frames = frames
+				if exception.Message == "" {
+					exception.Message = msg
+				}
+			} else {
+				frames, err := telemetry.ParseBashFrames(oArgs.FuncNames, oArgs.Sources, oArgs.Lines)
+				if err != nil {
+					sl.GetPrinter().ErrPrintf("unable to parse bash frames: %v\n", err)
+					return err
+				}
+				exception.Frames = frames
+			}
+
+			for k, v := range oArgs.Tags {
+				exception.Properties[k] = v
+			}
+
+			apmer, err := sl.GetAPMer()
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("unable to create App Insight client: %v\n", err)
+				return err
+			}
+
+			apmer.Track(exception)
+			return nil
+		}),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&oArgs.Message, "message", "m", "", "exception message; if reading the stack from stdin, defaults to the panic message")
+	f.StringVar(&oArgs.TypeName, "type", "ScriptError", "exception type name")
+	f.StringVarP(&oArgs.ProblemID, "problem-id", "p", "", "identifier used to group exceptions; defaults to '<type> at <innermost function>'")
+	f.IntVarP(&oArgs.Level, "level", "l", int(contracts.Error), "severity level for the exception")
+	f.StringSliceVar(&oArgs.FuncNames, "funcnames", nil, "comma separated bash FUNCNAME frames; innermost first")
+	f.StringSliceVar(&oArgs.Sources, "sources", nil, "comma separated bash BASH_SOURCE frames; innermost first")
+	f.StringSliceVar(&oArgs.Lines, "lines", nil, "comma separated line numbers currently executing in each frame; eg BASH_LINENO")
+	f.BoolVar(&oArgs.StackStdin, "stack-stdin", false, "read a Go-style stack trace from stdin rather than using bash frames")
+	f.StringToStringVarP(&oArgs.Tags, "tags", "t", map[string]string{}, "custom tags to be applied to the exception formatted as key=value")
+	return cmd, nil
+}
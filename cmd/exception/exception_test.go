@@ -0,0 +1,140 @@
+package exception
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	mocks "github.com/devigned/apmz/internal/test"
+	"github.com/devigned/apmz/pkg/telemetry"
+)
+
+const goStack = `panic: boom
+
+goroutine 1 [running]:
+main.explode(...)
+	/src/main.go:12
+main.main()
+	/src/main.go:7 +0x39
+`
+
+const multiGoroutineStack = goStack + `
+goroutine 6 [chan receive]:
+main.worker(0xc000010000)
+	/src/worker.go:20 +0x5d
+created by main.main
+	/src/main.go:5 +0x1d
+`
+
+func TestNewExceptionCommand(t *testing.T) {
+	cases := []struct {
+		name       string
+		setup      func(t *testing.T) *mocks.ServiceMock
+		stdin      string
+		assertions func(t *testing.T, cmd *cobra.Command)
+	}{
+		{
+			name: "CommandConstruction",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				return nil
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				assert.Equal(t, "exception", cmd.Name())
+				msg := cmd.Flags().Lookup("message")
+				if assert.NotNil(t, msg) {
+					assert.Equal(t, msg.Shorthand, "m")
+				}
+				tags := cmd.Flags().Lookup("tags")
+				if assert.NotNil(t, tags) {
+					assert.Equal(t, tags.Shorthand, "t")
+				}
+				assert.NotNil(t, cmd.Flags().Lookup("funcnames"))
+				assert.NotNil(t, cmd.Flags().Lookup("sources"))
+				assert.NotNil(t, cmd.Flags().Lookup("lines"))
+				assert.NotNil(t, cmd.Flags().Lookup("stack-stdin"))
+			},
+		},
+		{
+			name: "WithBashFrames",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				sl := new(mocks.ServiceMock)
+				apm := new(mocks.APMMock)
+				apm.On("Track", mock.MatchedBy(func(item apmz.Telemetry) bool {
+					ex, ok := item.(*telemetry.ExceptionTelemetry)
+					return ok && ex.Message == "migrate failed" &&
+						len(ex.Frames) == 2 &&
+						ex.Frames[0].Method == "migrate" &&
+						ex.Frames[0].FileName == "./lib.sh" &&
+						ex.Frames[0].Line == 42 &&
+						ex.Frames[1].Method == "main" &&
+						ex.DefaultProblemID() == "ScriptError at migrate"
+				}))
+				sl.On("GetAPMer").Return(apm, nil)
+				return sl
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				cmd.SetArgs([]string{"-m", "migrate failed", "--funcnames", "migrate,main", "--sources", "./lib.sh,./deploy.sh", "--lines", "42,10"})
+				assert.NoError(t, cmd.Execute())
+			},
+		},
+		{
+			name:  "WithGoStackFromStdin",
+			stdin: goStack,
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				sl := new(mocks.ServiceMock)
+				apm := new(mocks.APMMock)
+				apm.On("Track", mock.MatchedBy(func(item apmz.Telemetry) bool {
+					ex, ok := item.(*telemetry.ExceptionTelemetry)
+					return ok && ex.Message == "boom" &&
+						ex.Stack == goStack &&
+						len(ex.Frames) == 2 &&
+						ex.Frames[0].Assembly == "main" &&
+						ex.Frames[0].Method == "explode" &&
+						ex.Frames[1].FileName == "/src/main.go" &&
+						ex.Frames[1].Line == 7
+				}))
+				sl.On("GetAPMer").Return(apm, nil)
+				return sl
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				cmd.SetArgs([]string{"--stack-stdin"})
+				assert.NoError(t, cmd.Execute())
+			},
+		},
+		{
+			name:  "WithWholeStdinAsStack",
+			stdin: multiGoroutineStack,
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				sl := new(mocks.ServiceMock)
+				apm := new(mocks.APMMock)
+				apm.On("Track", mock.MatchedBy(func(item apmz.Telemetry) bool {
+					ex, ok := item.(*telemetry.ExceptionTelemetry)
+					return ok && ex.Stack == multiGoroutineStack && len(ex.Frames) == 2
+				}))
+				sl.On("GetAPMer").Return(apm, nil)
+				return sl
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				cmd.SetArgs([]string{"--stack-stdin"})
+				assert.NoError(t, cmd.Execute())
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s := c.setup(t)
+			cmd, err := NewExceptionCommand(s)
+			assert.NoError(t, err)
+			assert.NotNil(t, cmd)
+			cmd.SetIn(strings.NewReader(c.stdin))
+			c.assertions(t, cmd)
+		})
+	}
+}
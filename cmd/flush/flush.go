@@ -0,0 +1,199 @@
+package flush
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/spool"
+	"github.com/devigned/apmz/pkg/xcobra"
+)
+
+type (
+	flushArgs struct {
+		Attempts int
+		Backoff  time.Duration
+	}
+
+	// flushStats counts the spooled items; the report counts their envelopes, with those left in the spool as Spooled
+	flushStats struct {
+		channel.Report
+		Items     int
+		Expired   int
+		Remaining int
+	}
+
+	// flusher retries the items in a spool
+	flusher struct {
+		Spool    *spool.Spool
+		Client   *http.Client
+		Attempts int
+		// Timeout bounds the retries of each item; without one, each item is sent once
+		Timeout time.Duration
+	}
+)
+
+// NewFlushCommand creates a new `apmz flush` command
+func NewFlushCommand(sl service.CommandServicer) (*cobra.Command, error) {
+	var oArgs flushArgs
+	cmd := &cobra.Command{
+		Use:   "flush",
+		Short: "retry sending telemetry from the spool",
+		Long: "Retry sending telemetry which could not be delivered and was written to the spool directory. " +
+			"Expired items and items over the spool size cap are removed first, and each item is retried with " +
+			"exponential backoff, honoring Retry-After, for up to --attempts tries within --flush-timeout. Items " +
+			"which still can't be delivered stay in the spool for the next flush.",
+		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			s, err := sl.GetSpool()
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("unable to open spool: %v\n", err)
+				return err
+			}
+
+			timeout, err := cmd.Flags().GetDuration("flush-timeout")
+			if err != nil {
+				return err
+			}
+
+			f := flusher{
+				Spool:    s,
+				Client:   &http.Client{Timeout: 30 * time.Second},
+				Attempts: oArgs.Attempts,
+				Timeout:  timeout,
+			}
+
+			stats, err := f.Flush(ctx)
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("unable to flush spool: %v\n", err)
+				return err
+			}
+
+			sl.GetPrinter().ErrPrintf("flushed %d events from %d spooled items\n", stats.Accepted, stats.Items-stats.Remaining)
+			if stats.Rejected > 0 {
+				sl.GetPrinter().ErrPrintf("dropped %d events rejected by the endpoint\n", stats.Rejected)
+			}
+			if stats.Retried > 0 {
+				sl.GetPrinter().ErrPrintf("retried %d events\n", stats.Retried)
+			}
+			for _, reason := range stats.Reasons {
+				sl.GetPrinter().ErrPrintf("%s\n", reason)
+			}
+			if stats.Expired > 0 {
+				sl.GetPrinter().ErrPrintf("expired %d spooled items\n", stats.Expired)
+			}
+			if stats.Remaining > 0 {
+				err := fmt.Errorf("%d spooled items could not be delivered", stats.Remaining)
+				sl.GetPrinter().ErrPrintf("%v\n", err)
+				return err
+			}
+			return nil
+		}),
+	}
+
+	f := cmd.Flags()
+	f.IntVar(&oArgs.Attempts, "attempts", 3, "number of times to try sending each spooled item")
+	f.DurationVar(&oArgs.Backoff, "backoff", channel.MinBackoff, "time to wait before the first retry of an item; doubles with each retry")
+	if err := f.MarkDeprecated("backoff", fmt.Sprintf("retries back off from %s up to %s, like every other send", channel.MinBackoff, channel.MaxBackoff)); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// Flush trims the spool and then tries to deliver each item in it, oldest first. Once an item exhausts its attempts,
+// the remaining items for the same endpoint are left for the next flush rather than waiting out their backoff.
+func (f flusher) Flush(ctx context.Context) (flushStats, error) {
+	var stats flushStats
+	expired, err := f.Spool.Trim()
+	if err != nil {
+		return stats, err
+	}
+	stats.Expired = expired
+
+	entries, err := f.Spool.Entries()
+	if err != nil {
+		return stats, err
+	}
+
+	unreachable := make(map[string]bool)
+	for _, entry := range entries {
+		stats.Items++
+		item, err := f.Spool.Read(entry)
+		if err != nil {
+			// an unreadable item will never be delivered
+			if err := f.Spool.Remove(entry); err != nil {
+				return stats, err
+			}
+			stats.Expired++
+			continue
+		}
+
+		if unreachable[item.Endpoint] {
+			stats.Remaining++
+			continue
+		}
+
+		retry, err := f.deliver(ctx, item, &stats.Report)
+		if err != nil {
+			return stats, err
+		}
+
+		if len(retry) == 0 {
+			if err := f.Spool.Remove(entry); err != nil {
+				return stats, err
+			}
+			continue
+		}
+
+		item.Attempts++
+		item.Envelopes = retry
+		stats.Spooled += len(retry)
+		if err := f.Spool.Update(entry, *item); err != nil {
+			return stats, err
+		}
+		unreachable[item.Endpoint] = true
+		stats.Remaining++
+	}
+
+	return stats, nil
+}
+
+// deliver sends an item, retrying like the channel does, and counts the outcome in the report. It returns the
+// envelopes which are still worth retrying.
+func (f flusher) deliver(ctx context.Context, item *spool.Item, report *channel.Report) ([]json.RawMessage, error) {
+	retryCtx := ctx
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		retryCtx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+
+	envelopes := item.Envelopes
+	report.Sent += len(envelopes)
+	var retryAt time.Time
+	var reason string
+	attempts := 0
+	channel.Retry(retryCtx, func() time.Time { return retryAt }, func(retrying bool) bool {
+		if retrying {
+			report.Retried += len(envelopes)
+		}
+
+		attempts++
+		res, err := channel.Transmit(retryCtx, f.Client, item.Endpoint, envelopes)
+		envelopes, reason = report.Record(envelopes, res, err)
+		if res != nil && res.RetryAfter != nil {
+			retryAt = *res.RetryAfter
+		}
+		return len(envelopes) > 0 && attempts < f.Attempts
+	})
+
+	if len(envelopes) > 0 {
+		report.AddReason(reason)
+	}
+	return envelopes, ctx.Err()
+}
@@ -0,0 +1,124 @@
+package flush
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mocks "github.com/devigned/apmz/internal/test"
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/spool"
+)
+
+func TestNewFlushCommand(t *testing.T) {
+	cases := []struct {
+		name       string
+		setup      func(t *testing.T) *mocks.ServiceMock
+		assertions func(t *testing.T, cmd *cobra.Command)
+	}{
+		{
+			name: "CommandConstruction",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				return nil
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				assert.Equal(t, "flush", cmd.Name())
+				assert.NotNil(t, cmd.Flags().Lookup("attempts"))
+				assert.NotNil(t, cmd.Flags().Lookup("backoff"))
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s := c.setup(t)
+			cmd, err := NewFlushCommand(s)
+			assert.NoError(t, err)
+			assert.NotNil(t, cmd)
+			c.assertions(t, cmd)
+		})
+	}
+}
+
+func TestFlusherFlush(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"itemsReceived":2,"itemsAccepted":2,"errors":[]}`))
+	}))
+	defer ok.Close()
+
+	unavailableCalls := 0
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unavailableCalls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	dir, err := ioutil.TempDir("", "apmz-flush")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := spool.New(dir)
+	require.NoError(t, err)
+
+	envelopes := []json.RawMessage{json.RawMessage(`{"name":"one"}`), json.RawMessage(`{"name":"two"}`)}
+	require.NoError(t, s.Write(spool.Item{Endpoint: unavailable.URL, Envelopes: envelopes}))
+	require.NoError(t, s.Write(spool.Item{Endpoint: ok.URL, Envelopes: envelopes}))
+	require.NoError(t, s.Write(spool.Item{Endpoint: unavailable.URL, Envelopes: envelopes}))
+
+	f := flusher{Spool: s, Client: http.DefaultClient, Attempts: 2, Timeout: 5 * time.Second}
+	stats, err := f.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, flushStats{
+		Report: channel.Report{
+			Sent:     4,
+			Accepted: 2,
+			Retried:  2,
+			Spooled:  2,
+			Reasons:  []string{"transmission failed: 503 Service Unavailable"},
+		},
+		Items:     3,
+		Remaining: 2,
+	}, stats)
+	assert.Equal(t, 2, unavailableCalls, "second item for an unreachable endpoint should not be attempted")
+
+	entries, err := s.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	item, err := s.Read(entries[0])
+	require.NoError(t, err)
+	assert.Equal(t, 1, item.Attempts)
+}
+
+func TestFlusherFlushWithoutTimeout(t *testing.T) {
+	calls := 0
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	dir, err := ioutil.TempDir("", "apmz-flush")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := spool.New(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Write(spool.Item{Endpoint: unavailable.URL, Envelopes: []json.RawMessage{json.RawMessage(`{}`)}}))
+
+	f := flusher{Spool: s, Client: http.DefaultClient, Attempts: 3}
+	stats, err := f.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls, "each item should be sent once without a timeout")
+	assert.Equal(t, 1, stats.Remaining)
+}
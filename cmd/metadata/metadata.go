@@ -69,7 +69,11 @@ func NewInstanceCommand(sl service.CommandServicer) (*cobra.Command, error) {
 				return err
 			}
 
-			return sl.GetPrinter().Print(instance)
+			if err := sl.GetPrinter().Print(instance); err != nil {
+				sl.GetPrinter().ErrPrintf("unable to print: %v\n", err)
+				return err
+			}
+			return nil
 		}),
 	}
 
@@ -95,7 +99,11 @@ func NewAttestationCommand(sl service.CommandServicer) (*cobra.Command, error) {
 				return err
 			}
 
-			return sl.GetPrinter().Print(attest)
+			if err := sl.GetPrinter().Print(attest); err != nil {
+				sl.GetPrinter().ErrPrintf("unable to print: %v\n", err)
+				return err
+			}
+			return nil
 		}),
 	}
 
@@ -148,7 +156,11 @@ func NewTokenCommand(sl service.CommandServicer) (*cobra.Command, error) {
 				return err
 			}
 
-			return sl.GetPrinter().Print(token)
+			if err := sl.GetPrinter().Print(token); err != nil {
+				sl.GetPrinter().ErrPrintf("unable to print: %v\n", err)
+				return err
+			}
+			return nil
 		}),
 	}
 
@@ -1,6 +1,7 @@
 package metadata
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"testing"
@@ -13,6 +14,8 @@ import (
 
 	mocks "github.com/devigned/apmz/internal/test"
 	"github.com/devigned/apmz/pkg/azmeta"
+	"github.com/devigned/apmz/pkg/format"
+	"github.com/devigned/apmz/pkg/query"
 )
 
 func TestNewMetadataCommandGroup(t *testing.T) {
@@ -251,3 +254,68 @@ func TestNewInstanceCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestInstanceAndTokenFormats(t *testing.T) {
+	var instance azmeta.Instance
+	js, err := ioutil.ReadFile("./testdata/instance.json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(js, &instance))
+	token := &azmeta.IdentityToken{AccessToken: "token", ExpiresIn: "3599", TokenType: "Bearer"}
+
+	cases := []struct {
+		format   format.OutputType
+		instance string
+		token    string
+	}{
+		{format: format.PrettyJSONFormat, instance: "\n  \"compute\": {\n    \"azEnvironment\"", token: `"access_token": "token"`},
+		{format: format.YAMLFormat, instance: "compute:\n  azEnvironment: AzurePublicCloud\n", token: "access_token: token\n"},
+		{format: format.TableFormat, instance: "compute.location ", token: "access_token "},
+		{format: format.TSVFormat, instance: "compute.location\twestus2\n", token: "access_token\ttoken\n"},
+		{format: format.EnvFormat, instance: "export COMPUTE_LOCATION='westus2'\n", token: "export ACCESS_TOKEN='token'\n"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(string(c.format), func(t *testing.T) {
+			t.Parallel()
+			var b bytes.Buffer
+			require.NoError(t, format.Fprint(&b, c.format, &instance))
+			assert.Contains(t, b.String(), c.instance)
+
+			b.Reset()
+			require.NoError(t, format.Fprint(&b, c.format, token))
+			assert.Contains(t, b.String(), c.token)
+		})
+	}
+}
+
+func TestInstanceAndTokenQueries(t *testing.T) {
+	var instance azmeta.Instance
+	js, err := ioutil.ReadFile("./testdata/instance.json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(js, &instance))
+	token := &azmeta.IdentityToken{AccessToken: "token", ExpiresIn: "3599", TokenType: "Bearer"}
+
+	cases := []struct {
+		query  string
+		obj    interface{}
+		expect string
+	}{
+		{query: "compute.location", obj: &instance, expect: "westus2\n"},
+		{query: "network.interface[].ipv4.ipAddress[].privateIpAddress", obj: &instance, expect: "10.0.0.4\n"},
+		{query: "access_token", obj: token, expect: "token\n"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.query, func(t *testing.T) {
+			t.Parallel()
+			q, err := query.Compile(c.query)
+			require.NoError(t, err)
+
+			var b bytes.Buffer
+			require.NoError(t, format.FprintQuery(&b, format.TSVFormat, q, c.obj))
+			assert.Equal(t, c.expect, b.String())
+		})
+	}
+}
@@ -58,7 +58,11 @@ func NewScheduledEventsCommand(sl service.CommandServicer) (*cobra.Command, erro
 				return err
 			}
 
-			return sl.GetPrinter().Print(se)
+			if err := sl.GetPrinter().Print(se); err != nil {
+				sl.GetPrinter().ErrPrintf("unable to print: %v\n", err)
+				return err
+			}
+			return nil
 		}),
 	}
 
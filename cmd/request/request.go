@@ -0,0 +1,108 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/spf13/cobra"
+
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/xcobra"
+)
+
+type (
+	requestArgs struct {
+		Name         string
+		ID           string
+		URL          string
+		Source       string
+		ResponseCode string
+		Success      bool
+		Duration     time.Duration
+		Start        int64
+		End          int64
+		Tags         map[string]string
+	}
+)
+
+// NewRequestCommand creates a new `apmz request` command
+func NewRequestCommand(sl service.CommandServicer) (*cobra.Command, error) {
+	var oArgs requestArgs
+	cmd := &cobra.Command{
+		Use:   "request",
+		Short: "send a request (requests), such as a whole script run, to Application Insights",
+		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			start, duration, err := oArgs.timing(time.Now())
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("%v\n", err)
+				return err
+			}
+
+			req := &apmz.RequestTelemetry{
+				ID:           oArgs.ID,
+				Name:         oArgs.Name,
+				URL:          oArgs.URL,
+				Source:       oArgs.Source,
+				Duration:     duration,
+				ResponseCode: oArgs.ResponseCode,
+				Success:      oArgs.Success,
+				BaseTelemetry: apmz.BaseTelemetry{
+					Timestamp:  start,
+					Tags:       make(contracts.ContextTags),
+					Properties: make(map[string]string),
+				},
+				BaseTelemetryMeasurements: apmz.BaseTelemetryMeasurements{
+					Measurements: make(map[string]float64),
+				},
+			}
+
+			for k, v := range oArgs.Tags {
+				req.Properties[k] = v
+			}
+
+			apmer, err := sl.GetAPMer()
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("unable to create App Insight client: %v\n", err)
+				return err
+			}
+
+			apmer.Track(req)
+			return nil
+		}),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&oArgs.Name, "name", "n", "", "request name; eg the name of the script")
+	f.StringVar(&oArgs.ID, "id", "", "identifier of the request instance used for correlation; generated if not specified")
+	f.StringVar(&oArgs.URL, "url", "", "URL of the request")
+	f.StringVar(&oArgs.Source, "source", "", "source of the request; eg the caller host")
+	f.StringVarP(&oArgs.ResponseCode, "result-code", "r", "", "result of the request; eg the script exit code")
+	f.BoolVarP(&oArgs.Success, "success", "s", true, "indication of a successful or unsuccessful request")
+	f.DurationVarP(&oArgs.Duration, "duration", "d", 0, "duration of the request; eg '1.5s' -- ignored if both start and end are specified")
+	f.Int64Var(&oArgs.Start, "start", 0, "start time of the request in unixnano format")
+	f.Int64Var(&oArgs.End, "end", 0, "end time of the request in unixnano format -- requires start")
+	f.StringToStringVarP(&oArgs.Tags, "tags", "t", map[string]string{}, "custom tags to be applied to the request formatted as key=value")
+	err := cmd.MarkFlagRequired("name")
+	return cmd, err
+}
+
+// timing resolves the start time and duration of the request from the start, end and duration args
+func (ra requestArgs) timing(now time.Time) (time.Time, time.Duration, error) {
+	switch {
+	case ra.End != 0 && ra.Start == 0:
+		return time.Time{}, 0, errors.New("end requires start to be specified")
+	case ra.End != 0:
+		start, end := time.Unix(0, ra.Start), time.Unix(0, ra.End)
+		if end.Before(start) {
+			return time.Time{}, 0, errors.New("end must not be before start")
+		}
+		return start, end.Sub(start), nil
+	case ra.Start != 0:
+		return time.Unix(0, ra.Start), ra.Duration, nil
+	default:
+		return now.Add(-ra.Duration), ra.Duration, nil
+	}
+}
@@ -0,0 +1,122 @@
+package request
+
+import (
+	"testing"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	mocks "github.com/devigned/apmz/internal/test"
+)
+
+func TestNewRequestCommand(t *testing.T) {
+	cases := []struct {
+		name       string
+		setup      func(t *testing.T) *mocks.ServiceMock
+		assertions func(t *testing.T, cmd *cobra.Command)
+	}{
+		{
+			name: "CommandConstruction",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				return nil
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				assert.Equal(t, "request", cmd.Name())
+				name := cmd.Flags().Lookup("name")
+				if assert.NotNil(t, name) {
+					assert.Equal(t, name.Shorthand, "n")
+				}
+				tags := cmd.Flags().Lookup("tags")
+				if assert.NotNil(t, tags) {
+					assert.Equal(t, tags.Shorthand, "t")
+				}
+				assert.NotNil(t, cmd.Flags().Lookup("start"))
+				assert.NotNil(t, cmd.Flags().Lookup("end"))
+			},
+		},
+		{
+			name: "WithStartAndEnd",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				sl := new(mocks.ServiceMock)
+				apm := new(mocks.APMMock)
+				apm.On("Track", mock.MatchedBy(func(item apmz.Telemetry) bool {
+					req, ok := item.(*apmz.RequestTelemetry)
+					return ok && req.Name == "myscript" &&
+						req.ResponseCode == "1" &&
+						!req.Success &&
+						req.Duration == 2*time.Second &&
+						req.Timestamp.Equal(time.Unix(10, 0))
+				}))
+				sl.On("GetAPMer").Return(apm, nil)
+				return sl
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				cmd.SetArgs([]string{"-n", "myscript", "-r", "1", "-s=false", "--start", "10000000000", "--end", "12000000000"})
+				assert.NoError(t, cmd.Execute())
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s := c.setup(t)
+			cmd, err := NewRequestCommand(s)
+			assert.NoError(t, err)
+			assert.NotNil(t, cmd)
+			c.assertions(t, cmd)
+		})
+	}
+}
+
+func TestRequestArgsTiming(t *testing.T) {
+	now := time.Unix(100, 0)
+	cases := []struct {
+		name     string
+		args     requestArgs
+		start    time.Time
+		duration time.Duration
+		err      bool
+	}{
+		{
+			name:     "DurationOnly",
+			args:     requestArgs{Duration: 5 * time.Second},
+			start:    time.Unix(95, 0),
+			duration: 5 * time.Second,
+		},
+		{
+			name:     "StartAndDuration",
+			args:     requestArgs{Start: int64(10 * time.Second), Duration: time.Second},
+			start:    time.Unix(10, 0),
+			duration: time.Second,
+		},
+		{
+			name: "EndWithoutStart",
+			args: requestArgs{End: int64(10 * time.Second)},
+			err:  true,
+		},
+		{
+			name: "EndBeforeStart",
+			args: requestArgs{Start: int64(10 * time.Second), End: int64(5 * time.Second)},
+			err:  true,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			start, duration, err := c.args.timing(now)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, c.start.Equal(start))
+			assert.Equal(t, c.duration, duration)
+		})
+	}
+}
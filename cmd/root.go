@@ -5,28 +5,55 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/devigned/apmz-sdk/apmz"
-	"github.com/joho/godotenv"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
+	"github.com/devigned/apmz/cmd/availability"
 	"github.com/devigned/apmz/cmd/bash"
 	"github.com/devigned/apmz/cmd/batch"
+	"github.com/devigned/apmz/cmd/dependency"
+	"github.com/devigned/apmz/cmd/event"
+	"github.com/devigned/apmz/cmd/exception"
+	"github.com/devigned/apmz/cmd/flush"
 	"github.com/devigned/apmz/cmd/metadata"
 	"github.com/devigned/apmz/cmd/metric"
+	"github.com/devigned/apmz/cmd/request"
+	"github.com/devigned/apmz/cmd/serve"
 	timecmd "github.com/devigned/apmz/cmd/time"
 	"github.com/devigned/apmz/cmd/trace"
 	"github.com/devigned/apmz/cmd/uuid"
 	"github.com/devigned/apmz/pkg/azmeta"
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/connstr"
+	"github.com/devigned/apmz/pkg/enrich"
 	"github.com/devigned/apmz/pkg/format"
+	"github.com/devigned/apmz/pkg/otlp"
+	"github.com/devigned/apmz/pkg/prometheus"
+	"github.com/devigned/apmz/pkg/route"
+	"github.com/devigned/apmz/pkg/sampling"
 	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/spool"
+	"github.com/devigned/apmz/pkg/statsd"
 	"github.com/devigned/apmz/pkg/xcobra"
 )
 
+type (
+	// sharedValue is the value of a global flag taken by a flag of a command
+	sharedValue struct {
+		pflag.Value
+		flag *pflag.Flag
+	}
+)
+
 func init() {
-	_ = godotenv.Load() // load if possible
 	log.SetFormatter(&log.TextFormatter{TimestampFormat: "2006-01-02 15:04:05", FullTimestamp: true})
 }
 
@@ -50,35 +77,207 @@ func newRootCommand() (*cobra.Command, error) {
 		TraverseChildren: true,
 	}
 
+	printer := &format.StdPrinter{
+		Format: format.JSONFormat,
+	}
+	rootCmd.PersistentFlags().VarP(&printer.Format, "format", "f", "format of command output; 'json', 'json-pretty', 'yaml', 'table', 'tsv' or 'env', which prints shell exports such as COMPUTE_LOCATION='westus2'. Events printed by --output are always json, so they can be sent with 'apmz batch'. -f is short for --file-path instead on 'apmz batch', 'apmz batch validate' and 'apmz serve'")
+	rootCmd.PersistentFlags().Var(&printer.Query, "query", "query applied to command output before it is formatted, in a subset of JMESPath; eg 'compute.location'. Fields, indexes, slices, projections, filters, multi-selects and pipes are supported, with the length, keys, values, join, contains, starts_with, ends_with, to_string, to_number, type and sort functions. Scalar results print raw, without quotes, so they can be assigned directly to shell variables")
+
 	var apiKeys []string
 	var toOutput bool
-	rootCmd.PersistentFlags().StringSliceVar(&apiKeys, "api-keys", nil, "comma separated keys for the Application Insights accounts to send to; eg 'key1,key2,key3'")
+	var spoolDir string
+	var spoolMaxBytes int64
+	var spoolMaxAge time.Duration
+	var flushTimeout time.Duration
+	var sampleRate float64
+	var sampleTypeRates map[string]string
+	var sampleKeepErrors bool
+	var operationID, operationParentID, operationName, cloudRole, cloudRoleInstance string
+	var enrichments []string
+	var otlpEndpoints []string
+	var otlpHeaders map[string]string
+	var textfileDir, pushgatewayURL string
+	var statsdAddress, statsdMetricType string
+	var statsdEvents bool
+	var destinationURIs []string
+	var routesFile string
+	var globalTags map[string]string
+	rootCmd.PersistentFlags().String("config", "", "config file to read profiles from, instead of /etc/apmz/config.yaml and ~/.config/apmz/config.yaml")
+	rootCmd.PersistentFlags().String("profile", "", "config file profile to take the values of global flags which aren't given from; defaults to the profile named by the config file, or 'default'")
+	rootCmd.PersistentFlags().String("env-file", ".env", "file of environment variables to load, which don't replace those already set; a missing .env is ignored")
+	rootCmd.PersistentFlags().StringToStringVar(&globalTags, "global-tags", nil, "custom tags applied to all telemetry which doesn't set them itself; eg 'env=prod,team=ops'")
+	rootCmd.PersistentFlags().StringSliceVar(&destinationURIs, "destinations", nil, "comma separated destination URIs, each sent the telemetry matching the rule in its fragment, if any; eg 'appinsights://key,https://hooks.example.com/alerts#severity=error'. These replace the destinations with the same URI in --routes-file")
+	rootCmd.PersistentFlags().StringVar(&routesFile, "routes-file", "", "YAML file listing destination URIs and the rules selecting the telemetry sent to each")
+	rootCmd.PersistentFlags().StringSliceVar(&apiKeys, "api-keys", nil, "comma separated instrumentation keys or connection strings for the Application Insights accounts to send to; eg 'key1,key2' or 'InstrumentationKey=key1;IngestionEndpoint=https://...'")
+	rootCmd.PersistentFlags().StringSliceVar(&otlpEndpoints, "otlp-endpoints", nil, "comma separated OTLP/HTTP endpoints, such as an OpenTelemetry collector, to send telemetry to as logs, gauges and spans; eg 'http://localhost:4318'")
+	rootCmd.PersistentFlags().StringToStringVar(&otlpHeaders, "otlp-headers", nil, "headers sent to the OTLP endpoints, such as for authentication; eg 'api-key=secret'")
+	rootCmd.PersistentFlags().StringVar(&textfileDir, "prometheus-textfile-dir", "", "node_exporter textfile collector directory to write metrics to as gauges, labeled by their tags")
+	rootCmd.PersistentFlags().StringVar(&pushgatewayURL, "pushgateway-url", "", "Prometheus Pushgateway to push metrics to as gauges, grouped by cloud role; eg 'http://localhost:9091'")
+	rootCmd.PersistentFlags().StringVar(&statsdAddress, "statsd-address", "", "StatsD or DogStatsD agent to send metrics to, with tags in the DogStatsD format; eg 'localhost:8125' or 'unix:///var/run/datadog/dsd.socket'")
+	rootCmd.PersistentFlags().StringVar(&statsdMetricType, "statsd-metric-type", string(statsd.Gauge), "StatsD type to send metrics as; 'gauge' or 'timer', which expects values in milliseconds")
+	rootCmd.PersistentFlags().BoolVar(&statsdEvents, "statsd-events", false, "also send traces to the StatsD agent as DogStatsD events")
 	rootCmd.PersistentFlags().BoolVarP(&toOutput, "output", "o", false, "instead of sending directly to Application Insights, output event to stdout as json")
+	rootCmd.PersistentFlags().StringVar(&operationID, "operation-id", "", "operation id tag applied to all telemetry, correlating it in the transaction view")
+	rootCmd.PersistentFlags().StringVar(&operationParentID, "operation-parent-id", "", "operation parent id tag applied to all telemetry")
+	rootCmd.PersistentFlags().StringVar(&operationName, "operation-name", "", "operation name tag applied to all telemetry")
+	rootCmd.PersistentFlags().StringVar(&cloudRole, "cloud-role", "", "cloud role tag applied to all telemetry, naming the node in the application map")
+	rootCmd.PersistentFlags().StringVar(&cloudRoleInstance, "cloud-role-instance", "", "cloud role instance tag applied to all telemetry")
+	rootCmd.PersistentFlags().StringSliceVar(&enrichments, "enrich", nil, "add details about the machine to all telemetry; 'azure' adds the VM's instance metadata as properties and cloud role instance, cached for an hour")
+	rootCmd.PersistentFlags().DurationVar(&flushTimeout, "flush-timeout", 30*time.Second, "how long to keep retrying failed sends before exiting, including after SIGINT or SIGTERM; 0 tries each send once")
+	rootCmd.PersistentFlags().Float64Var(&sampleRate, "sample-rate", 100, "percentage of telemetry to send to every destination; decisions are consistent per correlation id, so a sampled-in script run keeps all of its events. Metrics are only sampled by --sample-type-rates")
+	rootCmd.PersistentFlags().StringToStringVar(&sampleTypeRates, "sample-type-rates", nil, "percentage of telemetry to send by type, overriding --sample-rate; eg 'trace=10,dependency=50'")
+	rootCmd.PersistentFlags().BoolVar(&sampleKeepErrors, "sample-keep-errors", true, "always send error traces, exceptions and failed requests, dependencies and availability results when sampling")
+	rootCmd.PersistentFlags().StringVar(&spoolDir, "spool-dir", "", "directory where telemetry which could not be delivered to Application Insights is saved to be sent later by 'apmz flush'; other destinations don't spool")
+	rootCmd.PersistentFlags().Int64Var(&spoolMaxBytes, "spool-max-bytes", spool.DefaultMaxBytes, "cap on the total size of the spool; the oldest items are removed first")
+	rootCmd.PersistentFlags().DurationVar(&spoolMaxAge, "spool-max-age", spool.DefaultMaxAge, "age after which spooled items expire")
 
 	var once sync.Once
 	var apmer service.APMer
-	printer := &format.StdPrinter{
-		Format: format.JSONFormat,
+	newSpool := func() (*spool.Spool, error) {
+		if spoolDir == "" {
+			return nil, errors.New("must provide spool-dir")
+		}
+		return spool.New(spoolDir, spool.WithMaxBytes(spoolMaxBytes), spool.WithMaxAge(spoolMaxAge))
 	}
+
 	registry := &service.Registry{
 		APMerFactory: func() (service.APMer, error) {
 			var err error
 			once.Do(func() {
-				if apiKeys == nil && !toOutput {
-					err = errors.New("must provide api-keys")
+				if apiKeys == nil && otlpEndpoints == nil && textfileDir == "" && pushgatewayURL == "" && statsdAddress == "" &&
+					destinationURIs == nil && routesFile == "" && !toOutput {
+					err = errors.New("must provide api-keys, otlp-endpoints, prometheus-textfile-dir, pushgateway-url, statsd-address, destinations or routes-file")
+					return
+				}
+
+				var opts []channel.Option
+				if spoolDir != "" {
+					var s *spool.Spool
+					if s, err = newSpool(); err != nil {
+						return
+					}
+					opts = append(opts, channel.WithSpool(s))
+				}
+
+				var sampler *sampling.Sampler
+				if sampleRate < 100 || len(sampleTypeRates) > 0 {
+					if sampler, err = newSampler(sampleRate, sampleTypeRates, sampleKeepErrors); err != nil {
+						return
+					}
+					opts = append(opts, channel.WithSampleRates(sampler))
+				}
+
+				tags := make(contracts.ContextTags)
+				setTag(tags.Operation().SetId, operationID)
+				setTag(tags.Operation().SetParentId, operationParentID)
+				setTag(tags.Operation().SetName, operationName)
+				setTag(tags.Cloud().SetRole, cloudRole)
+				setTag(tags.Cloud().SetRoleInstance, cloudRoleInstance)
+
+				var enrichment *enrich.Enrichment
+				if enrichment, err = loadEnrichment(enrichments, printer); err != nil {
 					return
 				}
+				if enrichment != nil && cloudRoleInstance == "" {
+					setTag(tags.Cloud().SetRoleInstance, enrichment.RoleInstance)
+				}
 
 				clients := make([]apmz.TelemetryClient, len(apiKeys))
 				for i, key := range apiKeys {
-					clients[i] = apmz.NewTelemetryClient(key)
+					if clients[i], err = newTelemetryClient(key, opts...); err != nil {
+						return
+					}
+
+					// items replayed by batch may have no tags of their own, so the client applies them too
+					for k, v := range tags {
+						clients[i].Context().Tags[k] = v
+					}
+				}
+
+				sinks := make([]service.APMer, len(otlpEndpoints))
+				for i, endpoint := range otlpEndpoints {
+					if sinks[i], err = otlp.New(endpoint, otlp.WithHeaders(otlpHeaders), otlp.WithTags(tags)); err != nil {
+						return
+					}
+				}
+
+				if textfileDir != "" {
+					var sink service.APMer
+					if sink, err = prometheus.NewTextfile(textfileDir); err != nil {
+						return
+					}
+					sinks = append(sinks, sink)
+				}
+
+				if pushgatewayURL != "" {
+					var sink service.APMer
+					if sink, err = prometheus.NewPushgateway(pushgatewayURL, prometheus.WithTags(tags)); err != nil {
+						return
+					}
+					sinks = append(sinks, sink)
+				}
+
+				if statsdAddress != "" {
+					statsdOpts := []statsd.Option{statsd.WithMetricType(statsd.MetricType(statsdMetricType)), statsd.WithTags(tags)}
+					if statsdEvents {
+						statsdOpts = append(statsdOpts, statsd.WithEvents())
+					}
+
+					var sink service.APMer
+					if sink, err = statsd.New(statsdAddress, statsdOpts...); err != nil {
+						return
+					}
+					sinks = append(sinks, sink)
+				}
+
+				var destinations []route.Destination
+				if destinations, err = loadDestinations(routesFile, destinationURIs); err != nil {
+					return
+				}
+
+				settings := destinationSettings{
+					ChannelOptions:   opts,
+					Tags:             tags,
+					OTLPHeaders:      otlpHeaders,
+					StatsdMetricType: statsdMetricType,
+					StatsdEvents:     statsdEvents,
 				}
+				for _, d := range destinations {
+					var client apmz.TelemetryClient
+					var sink service.APMer
+					if client, sink, err = newDestination(d, settings); err != nil {
+						return
+					}
 
-				clientProxy := service.APMZProxy{
-					Clients: clients,
+					if client != nil {
+						clients = append(clients, client)
+					}
+					if sink != nil {
+						sinks = append(sinks, sink)
+					}
+				}
+
+				clientProxy := &service.APMZProxy{
+					Clients:      clients,
+					Sinks:        sinks,
+					FlushTimeout: flushTimeout,
+					Tags:         tags,
+					Sampler:      sampler,
+				}
+				if enrichment != nil || len(globalTags) > 0 {
+					clientProxy.Properties = make(map[string]string)
+				}
+				if enrichment != nil {
+					for k, v := range enrichment.Properties {
+						clientProxy.Properties[k] = v
+					}
+				}
+				for k, v := range globalTags {
+					clientProxy.Properties[k] = v
 				}
 				if toOutput {
-					clientProxy.Printer = printer
+					// events are printed a line each, as batch reads them, whatever the format of other output
+					clientProxy.Printer = &format.StdPrinter{Format: format.JSONFormat}
 				}
 
 				apmer = clientProxy
@@ -94,16 +293,25 @@ func newRootCommand() (*cobra.Command, error) {
 		MetadataFactory: func() (service.Metadater, error) {
 			return azmeta.New()
 		},
+		SpoolFactory: newSpool,
 	}
 
 	cmdFuncs := []func(locator service.CommandServicer) (*cobra.Command, error){
 		trace.NewTraceCommand,
 		metric.NewMetricCommand,
+		event.NewEventCommand,
+		dependency.NewDependencyCommand,
+		request.NewRequestCommand,
+		exception.NewExceptionCommand,
+		availability.NewAvailabilityCommand,
 		batch.NewBatchCommand,
+		flush.NewFlushCommand,
+		serve.NewServeCommand,
 		bash.NewBashCommand,
 		timecmd.NewTimeCommandGroup,
 		uuid.NewUUIDCommand,
 		metadata.NewMetadataCommandGroup,
+		newConfigCommandGroup,
 		func(locator service.CommandServicer) (*cobra.Command, error) {
 			return newVersionCommand(), nil
 		},
@@ -117,14 +325,200 @@ func newRootCommand() (*cobra.Command, error) {
 		rootCmd.AddCommand(cmd)
 	}
 
+	// -f is short for --format, except on the commands where it was already short for --file-path
+	shareFormatFlag(rootCmd, rootCmd.PersistentFlags().Lookup("format"))
+
+	// every flag of every command is bound to an environment variable, such as $APMZ_API_KEYS for --api-keys and
+	// $APMZ_EVENT_NAME for 'apmz event --name'
+	addEnvUsage(rootCmd)
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := loadSettings(cmd); err != nil {
+			cmd.SilenceUsage = true
+			return err
+		}
+		return nil
+	}
+
 	rootCmd.PersistentPostRunE = xcobra.PostRunWithCtxE(func(ctx context.Context, cmd *cobra.Command, args []string) error {
 		if apmer == nil {
 			return nil
 		}
 
-		apmer.Close(ctx)
-		return nil
+		// ctx is canceled by SIGINT or SIGTERM, but what has been queued should still be sent. The final flush is
+		// bounded by the flush timeout, and a second signal exits immediately.
+		return service.ReportDelivery(printer, apmer.Close(context.Background()))
 	})
 
 	return rootCmd, nil
 }
+
+// envName returns the environment variable bound to a flag of the command. Global flags are named by the flag alone;
+// eg APMZ_API_KEYS for --api-keys. The flags of a command are also named by the command, so a variable set for one
+// command isn't read by every command with a flag of the same name; eg APMZ_EVENT_NAME for 'apmz event --name'.
+func envName(cmd *cobra.Command, flag string) string {
+	return envNames(cmd, flag)[0]
+}
+
+// envNames returns the environment variables a flag of the command is read from, in order. The flags of a command fall
+// back to the variable named by the flag alone when theirs isn't set; eg APMZ_DEFAULT_TAGS for
+// 'apmz bash --default-tags'.
+func envNames(cmd *cobra.Command, flag string) []string {
+	bare := "APMZ_" + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+	if cmd.Root().PersistentFlags().Lookup(flag) != nil {
+		return []string{bare}
+	}
+
+	name := strings.Join(append(strings.Fields(cmd.CommandPath())[1:], flag), "_")
+	return []string{"APMZ_" + strings.ToUpper(strings.Replace(name, "-", "_", -1)), bare}
+}
+
+// bindEnv sets each of the command's flags which weren't given on the command line from its environment variable, if
+// it's set
+func bindEnv(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err == nil && f.Name != "help" {
+			err = bindEnvFlag(cmd, f)
+		}
+	})
+	return err
+}
+
+// bindEnvFlag sets the flag from its environment variable, if it's set and the flag wasn't given on the command line
+func bindEnvFlag(cmd *cobra.Command, f *pflag.Flag) error {
+	if f.Changed {
+		return nil
+	}
+
+	for _, env := range envNames(cmd, f.Name) {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+
+		if err := cmd.Flags().Set(f.Name, value); err != nil {
+			return fmt.Errorf("invalid value for --%s from $%s: %v", f.Name, env, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// shareFormatFlag gives the command, and its children, which use the shorthand of --format for a flag of their own, a
+// --format flag without it. It shares the global flag's value, so it's set from the environment and the profile like
+// the global flag is.
+func shareFormatFlag(cmd *cobra.Command, format *pflag.Flag) {
+	if f := cmd.Flags().ShorthandLookup(format.Shorthand); f != nil && f != format {
+		local := *format
+		local.Shorthand = ""
+		local.Value = sharedValue{Value: format.Value, flag: format}
+		cmd.Flags().AddFlag(&local)
+	}
+
+	for _, child := range cmd.Commands() {
+		shareFormatFlag(child, format)
+	}
+}
+
+// Set sets the shared flag's value and marks it as changed, so the profile doesn't replace it
+func (sv sharedValue) Set(value string) error {
+	sv.flag.Changed = true
+	return sv.Value.Set(value)
+}
+
+// addEnvUsage adds the environment variable bound to each flag of the command, and of its children, to the flag's
+// usage
+func addEnvUsage(cmd *cobra.Command) {
+	for _, flags := range []*pflag.FlagSet{cmd.PersistentFlags(), cmd.Flags()} {
+		flags.VisitAll(func(f *pflag.Flag) {
+			if suffix := " [$" + envName(cmd, f.Name) + "]"; !strings.HasSuffix(f.Usage, suffix) {
+				f.Usage += suffix
+			}
+		})
+	}
+
+	for _, child := range cmd.Commands() {
+		addEnvUsage(child)
+	}
+}
+
+// newTelemetryClient creates an Application Insights client for an instrumentation key or connection string, which
+// sends to the resource's ingestion endpoint through a channel that reports what was delivered
+func newTelemetryClient(key string, opts ...channel.Option) (apmz.TelemetryClient, error) {
+	cs, err := connstr.Parse(key)
+	if err != nil {
+		return nil, err
+	}
+
+	config := apmz.NewTelemetryConfiguration(cs.InstrumentationKey)
+	config.EndpointURL = cs.TrackEndpoint()
+	opts = append([]channel.Option{channel.WithBatchSize(config.MaxBatchSize)}, opts...)
+	ch, err := channel.New(config.EndpointURL, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := apmz.NewTelemetryClientFromConfig(config)
+	client.Channel().Stop()
+	client.SetChannel(ch)
+	return client, nil
+}
+
+// setTag sets a context tag if a value was given
+func setTag(set func(string), value string) {
+	if value != "" {
+		set(value)
+	}
+}
+
+// loadEnrichment loads the enrichments named by the enrich flag. The machine may not be able to provide them, such as
+// when it isn't an Azure VM, so failing to load only warns rather than stopping telemetry from being sent.
+func loadEnrichment(kinds []string, printer format.Printer) (*enrich.Enrichment, error) {
+	var enrichment *enrich.Enrichment
+	for _, kind := range kinds {
+		if kind != enrich.Azure {
+			return nil, fmt.Errorf("unknown enrichment %q; expected %q", kind, enrich.Azure)
+		}
+
+		cachePath, err := enrich.DefaultAzureCachePath()
+		if err != nil {
+			return nil, err
+		}
+
+		md, err := azmeta.New()
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		enrichment, err = enrich.LoadAzure(ctx, md, cachePath)
+		cancel()
+		if err != nil {
+			printer.ErrPrintf("unable to enrich telemetry with Azure instance metadata: %v\n", err)
+		}
+	}
+	return enrichment, nil
+}
+
+// newSampler creates a sampler from the sample flags, whose type rates are keyed by telemetry type, such as "trace"
+func newSampler(rate float64, typeRates map[string]string, keepErrors bool) (*sampling.Sampler, error) {
+	rates := make(map[string]float64, len(typeRates))
+	for t, r := range typeRates {
+		kind, ok := service.DefaultTelemetryKinds.KindOfType(service.EventType(t))
+		if !ok {
+			return nil, fmt.Errorf("unknown telemetry type %q; expected one of %s", t, strings.Join(service.DefaultTelemetryKinds.TypeNames(), ", "))
+		}
+
+		parsed, err := strconv.ParseFloat(r, 64)
+		if err != nil {
+			return nil, fmt.Errorf("sample rate for %s must be a number: %w", t, err)
+		}
+
+		if err := sampling.ValidPercentage(parsed); err != nil {
+			return nil, fmt.Errorf("%s: %w", t, err)
+		}
+		rates[kind.BaseType()] = parsed
+	}
+
+	return sampling.New(rate, rates, keepErrors)
+}
@@ -1,8 +1,17 @@
 package cmd
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -11,7 +20,7 @@ func TestNewRootCmdNames(t *testing.T) {
 	root, err := newRootCommand()
 	require.NoError(t, err)
 
-	expected := []string{"trace", "metric", "batch", "version", "bash", "time", "uuid", "metadata"}
+	expected := []string{"trace", "metric", "event", "dependency", "request", "exception", "availability", "batch", "flush", "serve", "version", "bash", "time", "uuid", "metadata", "config"}
 	actual := make([]string, len(root.Commands()))
 	for i, c := range root.Commands() {
 		actual[i] = c.Name()
@@ -24,3 +33,161 @@ func TestExecute(t *testing.T) {
 	require.NoError(t, err)
 	assert.NoError(t, root.Execute())
 }
+
+func TestNewTelemetryClientWithConnectionString(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer srv.Close()
+
+	client, err := newTelemetryClient("InstrumentationKey=key;IngestionEndpoint=" + srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "key", client.InstrumentationKey())
+	assert.Equal(t, srv.URL+"/v2/track", client.Channel().EndpointAddress())
+
+	client.TrackTrace("foo", contracts.Information)
+	<-client.Channel().Close()
+	assert.Equal(t, "/v2/track", path)
+}
+
+func TestEnvBinding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-env")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte("profiles:\n  default:\n    api-keys: [from-profile]\n    flush-timeout: 5s\n"), 0600))
+
+	env := map[string]string{
+		"APMZ_CONFIG":        configPath,
+		"APMZ_API_KEYS":      "key1,key2",
+		"APMZ_OUTPUT":        "true",
+		"APMZ_TRACE_NAME":    "from-env",
+		"APMZ_NAME":          "unprefixed",
+		"APMZ_TRACE_LEVEL":   "3",
+		"APMZ_EVENT_TAGS":    "not=trace",
+		"APMZ_GLOBAL_TAGS":   "env=prod",
+		"APMZ_SAMPLE_RATE":   "",
+		"APMZ_DEFAULT_TAGS":  "team=ops",
+		"APMZ_FLUSH_TIMEOUT": "",
+	}
+	for k, v := range env {
+		require.NoError(t, os.Setenv(k, v))
+		defer os.Unsetenv(k)
+	}
+
+	root, err := newRootCommand()
+	require.NoError(t, err)
+	trace, _, err := root.Find([]string{"trace"})
+	require.NoError(t, err)
+	require.NoError(t, trace.ParseFlags([]string{"-l", "1"}))
+	require.NoError(t, loadSettings(trace))
+
+	expected := map[string]string{
+		"api-keys":      "[key1,key2]",
+		"output":        "true",
+		"name":          "from-env",
+		"level":         "1",
+		"global-tags":   "[env=prod]",
+		"sample-rate":   "100",
+		"flush-timeout": "5s",
+		"tags":          "[]",
+	}
+	for name, value := range expected {
+		assert.Equal(t, value, trace.Flags().Lookup(name).Value.String(), name)
+	}
+
+	// a command flag falls back to the variable named by the flag alone
+	bash, _, err := root.Find([]string{"bash"})
+	require.NoError(t, err)
+	require.NoError(t, bash.ParseFlags(nil))
+	require.NoError(t, loadSettings(bash))
+	assert.Equal(t, "[team=ops]", bash.Flags().Lookup("default-tags").Value.String())
+}
+
+func TestEnvBindingInvalidValue(t *testing.T) {
+	require.NoError(t, os.Setenv("APMZ_SAMPLE_RATE", "lots"))
+	defer os.Unsetenv("APMZ_SAMPLE_RATE")
+
+	root, err := newRootCommand()
+	require.NoError(t, err)
+	require.NoError(t, root.ParseFlags(nil))
+	err = loadSettings(root)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid value for --sample-rate from $APMZ_SAMPLE_RATE")
+	}
+}
+
+func TestEveryFlagShowsItsEnvVar(t *testing.T) {
+	root, err := newRootCommand()
+	require.NoError(t, err)
+
+	var visit func(cmd *cobra.Command)
+	visit = func(cmd *cobra.Command) {
+		for _, flags := range []*pflag.FlagSet{cmd.PersistentFlags(), cmd.Flags()} {
+			flags.VisitAll(func(f *pflag.Flag) {
+				assert.True(t, strings.HasSuffix(f.Usage, " [$"+envName(cmd, f.Name)+"]"), "%s --%s: %s", cmd.CommandPath(), f.Name, f.Usage)
+			})
+		}
+		for _, child := range cmd.Commands() {
+			visit(child)
+		}
+	}
+	visit(root)
+
+	events, _, err := root.Find([]string{"metadata", "events", "ack"})
+	require.NoError(t, err)
+	assert.Equal(t, "APMZ_PROMETHEUS_TEXTFILE_DIR", envName(events, "prometheus-textfile-dir"))
+	assert.Equal(t, "APMZ_METADATA_EVENTS_ACK_EVENT_IDS", envName(events, "event-ids"))
+}
+
+func TestNewSampler(t *testing.T) {
+	cases := []struct {
+		name      string
+		typeRates map[string]string
+		rates     map[string]float64
+		err       string
+	}{
+		{name: "Valid", typeRates: map[string]string{"trace": "1", "dependency": "50"}, rates: map[string]float64{"MessageData": 1, "RemoteDependencyData": 50}},
+		{name: "UnknownType", typeRates: map[string]string{"traces": "1"}, err: `unknown telemetry type "traces"; expected one of availability, dependency, event, exception, metric, request, trace`},
+		{name: "NotANumber", typeRates: map[string]string{"trace": "lots"}, err: "sample rate for trace must be a number"},
+		{name: "OutOfRange", typeRates: map[string]string{"trace": "-1"}, err: "trace: sample rate -1 must be between 0 and 100"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := newSampler(10, c.typeRates, true)
+			if c.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), c.err)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.rates, s.TypeRates)
+		})
+	}
+}
+
+func TestFormatShorthand(t *testing.T) {
+	root, err := newRootCommand()
+	require.NoError(t, err)
+	format := root.PersistentFlags().Lookup("format")
+
+	instance, _, err := root.Find([]string{"metadata", "instance"})
+	require.NoError(t, err)
+	require.NoError(t, instance.ParseFlags([]string{"-f", "tsv"}))
+	assert.Equal(t, "tsv", format.Value.String())
+
+	for _, path := range [][]string{{"batch"}, {"batch", "validate"}, {"serve"}} {
+		cmd, _, err := root.Find(path)
+		require.NoError(t, err)
+		require.NoError(t, cmd.ParseFlags([]string{"-f", "events.jsonl", "--format", "yaml"}))
+		assert.Equal(t, "events.jsonl", cmd.Flags().Lookup("file-path").Value.String(), cmd.CommandPath())
+		assert.Equal(t, "yaml", format.Value.String(), cmd.CommandPath())
+		assert.True(t, format.Changed, cmd.CommandPath())
+	}
+}
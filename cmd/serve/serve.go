@@ -0,0 +1,97 @@
+package serve
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/devigned/apmz/pkg/ingest"
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/xcobra"
+)
+
+type (
+	serveArgs struct {
+		Addr       string
+		FilePath   string
+		MaxRecords int
+	}
+)
+
+const (
+	shutdownTimeout = 5 * time.Second
+)
+
+// NewServeCommand creates a new `apmz serve` command
+func NewServeCommand(sl service.CommandServicer) (*cobra.Command, error) {
+	var oArgs serveArgs
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "run a local stand-in for the Application Insights ingestion endpoint",
+		Long: "Run a local HTTP server which accepts telemetry posted to " + ingest.TrackPath + " as Application " +
+			"Insights does, and writes each envelope as a line of json. Point apmz, or any Application Insights SDK, " +
+			"at it with a connection string such as 'InstrumentationKey=<key>;IngestionEndpoint=http://127.0.0.1:8080'. " +
+			"Received telemetry can be queried with GET " + ingest.QueryPath + "?type=trace&name=...&ikey=...&operationId=...&limit=n " +
+			"and cleared with DELETE " + ingest.QueryPath + ".",
+		Run: xcobra.RunWithCtx(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			out := io.Writer(os.Stdout)
+			if oArgs.FilePath != "" {
+				file, err := os.OpenFile(oArgs.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+				if err != nil {
+					sl.GetPrinter().ErrPrintf("unable to open file: %v\n", err)
+					return err
+				}
+				defer file.Close()
+				out = file
+			}
+
+			listener, err := net.Listen("tcp", oArgs.Addr)
+			if err != nil {
+				sl.GetPrinter().ErrPrintf("unable to listen: %v\n", err)
+				return err
+			}
+
+			srv := &http.Server{
+				Handler: &ingest.Server{
+					Out:        out,
+					MaxRecords: oArgs.MaxRecords,
+				},
+			}
+
+			addr := listener.Addr().String()
+			sl.GetPrinter().ErrPrintf("listening on http://%s\n", addr)
+			sl.GetPrinter().ErrPrintf("send telemetry with --api-keys 'InstrumentationKey=<key>;IngestionEndpoint=http://%s'\n", addr)
+
+			errs := make(chan error, 1)
+			go func() {
+				errs <- srv.Serve(listener)
+			}()
+
+			select {
+			case err := <-errs:
+				sl.GetPrinter().ErrPrintf("server stopped: %v\n", err)
+				return err
+			case <-ctx.Done():
+			}
+
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				sl.GetPrinter().ErrPrintf("unable to shut down: %v\n", err)
+				return err
+			}
+			return nil
+		}),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&oArgs.Addr, "addr", "a", "127.0.0.1:8080", "address to listen on; use port 0 to pick a free port")
+	f.StringVarP(&oArgs.FilePath, "file-path", "f", "", "file path to append received envelopes to as json lines -- if not specified, then stdout will be assumed")
+	f.IntVar(&oArgs.MaxRecords, "max-records", ingest.DefaultMaxRecords, "number of received envelopes kept for queries; the oldest are discarded first")
+	return cmd, nil
+}
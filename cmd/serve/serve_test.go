@@ -0,0 +1,43 @@
+package serve
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+
+	mocks "github.com/devigned/apmz/internal/test"
+)
+
+func TestNewServeCommand(t *testing.T) {
+	cases := []struct {
+		name       string
+		setup      func(t *testing.T) *mocks.ServiceMock
+		assertions func(t *testing.T, cmd *cobra.Command)
+	}{
+		{
+			name: "CommandConstruction",
+			setup: func(t *testing.T) *mocks.ServiceMock {
+				return nil
+			},
+			assertions: func(t *testing.T, cmd *cobra.Command) {
+				assert.Equal(t, "serve", cmd.Name())
+				assert.Equal(t, "127.0.0.1:8080", cmd.Flags().Lookup("addr").DefValue)
+				assert.NotNil(t, cmd.Flags().Lookup("file-path"))
+				assert.NotNil(t, cmd.Flags().Lookup("max-records"))
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s := c.setup(t)
+			cmd, err := NewServeCommand(s)
+			assert.NoError(t, err)
+			assert.NotNil(t, cmd)
+			c.assertions(t, cmd)
+		})
+	}
+}
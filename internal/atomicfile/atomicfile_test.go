@@ -0,0 +1,37 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-atomicfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "metrics.prom")
+	require.NoError(t, Write(path, []byte("old"), 0644))
+	require.NoError(t, Write(path, []byte("new"), 0600))
+
+	bits, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(bits))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, infos, 1, "the temporary file should be renamed")
+}
+
+func TestWriteMissingDir(t *testing.T) {
+	assert.Error(t, Write(filepath.Join(os.TempDir(), "apmz-does-not-exist", "file"), []byte("foo"), 0600))
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/devigned/apmz/pkg/azmeta"
 	"github.com/devigned/apmz/pkg/format"
 	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/spool"
 )
 
 type (
@@ -51,6 +52,11 @@ func (sm *ServiceMock) GetMetadater() (service.Metadater, error) {
 	return args.Get(0).(service.Metadater), args.Error(1)
 }
 
+func (sm *ServiceMock) GetSpool() (*spool.Spool, error) {
+	args := sm.Called()
+	return args.Get(0).(*spool.Spool), args.Error(1)
+}
+
 func (pm *PrinterMock) Print(obj interface{}) error {
 	args := pm.Called(obj)
 	return args.Error(0)
@@ -68,6 +74,12 @@ func (am *APMMock) Track(telemetry apmz.Telemetry) {
 	am.Called(telemetry)
 }
 
+func (am *APMMock) Close(ctx context.Context) []service.DeliveryResult {
+	args := am.Called(ctx)
+	results, _ := args.Get(0).([]service.DeliveryResult)
+	return results
+}
+
 func (am *APMMock) Channel() apmz.TelemetryChannel {
 	args := am.Called()
 	return args.Get(0).(apmz.TelemetryChannel)
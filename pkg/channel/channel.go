@@ -0,0 +1,404 @@
+// Package channel provides an Application Insights telemetry channel which transmits synchronously, so the outcome
+// of every transmission is known, and which spools undelivered telemetry to disk.
+package channel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+
+	"github.com/devigned/apmz/pkg/sampling"
+	"github.com/devigned/apmz/pkg/spool"
+)
+
+type (
+	// Channel is an apmz.TelemetryChannel which buffers envelopes and transmits them in batches
+	Channel struct {
+		endpoint  string
+		batchSize int
+		client    *http.Client
+		spool     *spool.Spool
+		sampler   *sampling.Sampler
+
+		mu          sync.Mutex
+		buffer      []json.RawMessage
+		pending     []json.RawMessage
+		backoff     time.Duration
+		retryAt     time.Time
+		closed      bool
+		report      Report
+		lastFailure string
+	}
+
+	// Report counts what happened to the envelopes sent through a channel
+	Report struct {
+		// Sent is the number of envelopes sent to the channel, not counting those sampled out
+		Sent int
+		// SampledOut is the number of envelopes which were not sent because they were sampled out
+		SampledOut int
+		// Accepted is the number of envelopes the endpoint accepted
+		Accepted int
+		// Rejected is the number of envelopes the endpoint refused and which would not succeed if sent again
+		Rejected int
+		// Retried is the number of times envelopes were sent again after a failed transmission
+		Retried int
+		// Dropped is the number of envelopes which could not be delivered or spooled
+		Dropped int
+		// Spooled is the number of envelopes written to the spool to be sent later
+		Spooled int
+		// Reasons are the distinct reasons given for rejected and dropped envelopes
+		Reasons []string
+	}
+
+	// Option is a variadic optional configuration func
+	Option func(c *Channel) error
+)
+
+const (
+	// DefaultBatchSize is the number of envelopes sent in a single transmission, unless WithBatchSize is given
+	DefaultBatchSize = 1024
+
+	maxReasons = 10
+)
+
+var _ apmz.TelemetryChannel = (*Channel)(nil)
+
+// New creates a new channel which transmits to the endpoint
+func New(endpoint string, opts ...Option) (*Channel, error) {
+	c := &Channel{
+		endpoint:  endpoint,
+		batchSize: DefaultBatchSize,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// WithSpool persists telemetry which could not be delivered to the spool
+func WithSpool(s *spool.Spool) Option {
+	return func(c *Channel) error {
+		c.spool = s
+		return nil
+	}
+}
+
+// WithSampleRates records the rate at which the sampler samples each envelope's item, so Application Insights can
+// extrapolate counts. The items are sampled before they're sent to the channel.
+func WithSampleRates(sampler *sampling.Sampler) Option {
+	return func(c *Channel) error {
+		c.sampler = sampler
+		return nil
+	}
+}
+
+// WithBatchSize sets the number of envelopes sent in a single transmission
+func WithBatchSize(size int) Option {
+	return func(c *Channel) error {
+		if size < 1 {
+			return fmt.Errorf("batch size must be greater than 0")
+		}
+		c.batchSize = size
+		return nil
+	}
+}
+
+// WithHTTPClient sets the http client used to transmit
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Channel) error {
+		c.client = client
+		return nil
+	}
+}
+
+// EndpointAddress is the address of the endpoint to which telemetry is sent
+func (c *Channel) EndpointAddress() string {
+	return c.endpoint
+}
+
+// Send queues a single envelope and transmits the buffer once it reaches the batch size. Sending blocks while the
+// batch is transmitted, which bounds the memory used by large batches.
+func (c *Channel) Send(envelope *contracts.Envelope) {
+	if envelope == nil {
+		return
+	}
+
+	if c.sampler != nil {
+		envelope.SampleRate = c.sampler.Rate(envelope)
+	}
+
+	bits, err := json.Marshal(envelope)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+
+	c.report.Sent++
+	c.buffer = append(c.buffer, bits)
+	if len(c.buffer) < c.batchSize {
+		c.mu.Unlock()
+		return
+	}
+
+	batch := c.buffer
+	c.buffer = nil
+	c.mu.Unlock()
+	c.transmit(batch)
+}
+
+// Flush transmits the buffered envelopes without retrying
+func (c *Channel) Flush() {
+	c.mu.Lock()
+	batch := c.buffer
+	c.buffer = nil
+	c.mu.Unlock()
+	c.transmit(batch)
+}
+
+// Stop discards all buffered envelopes; further calls to Send are ignored
+func (c *Channel) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.report.Dropped += len(c.buffer) + len(c.pending)
+	c.buffer = nil
+	c.pending = nil
+}
+
+// Report returns what has happened to the envelopes sent so far
+func (c *Channel) Report() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := c.report
+	report.Reasons = append([]string(nil), c.report.Reasons...)
+	return report
+}
+
+// AddReason records a distinct reason for rejected or dropped items, keeping the first few
+func (r *Report) AddReason(reason string) {
+	if reason == "" || len(r.Reasons) >= maxReasons {
+		return
+	}
+
+	for _, existing := range r.Reasons {
+		if existing == reason {
+			return
+		}
+	}
+	r.Reasons = append(r.Reasons, reason)
+}
+
+// Record counts the outcome of transmitting a batch, given the result and error returned by Transmit. It returns the
+// envelopes which should be retried and, if there are any, the reason the transmission failed.
+func (r *Report) Record(batch []json.RawMessage, res *Result, err error) ([]json.RawMessage, string) {
+	if err != nil {
+		return batch, fmt.Sprintf("transmission failed: %v", err)
+	}
+
+	retry := res.Retryable(batch)
+	accepted := res.Accepted(len(batch))
+	r.Accepted += accepted
+	if rejected := len(batch) - accepted - len(retry); rejected > 0 {
+		r.Rejected += rejected
+		for _, reason := range res.rejectReasons() {
+			r.AddReason(reason)
+		}
+	}
+
+	if len(retry) == 0 {
+		return nil, ""
+	}
+	return retry, fmt.Sprintf("transmission failed: %d %s", res.StatusCode, http.StatusText(res.StatusCode))
+}
+
+// Unconfirmed is the number of envelopes whose outcome is not yet known
+func (r Report) Unconfirmed() int {
+	if n := r.Sent - r.Accepted - r.Rejected - r.Dropped - r.Spooled; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// IsThrottled returns true if the endpoint has asked the channel to back off
+func (c *Channel) IsThrottled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Before(c.retryAt)
+}
+
+// Close transmits the buffered envelopes and any which previously failed. If retryTimeout is specified, failed
+// transmissions are retried with backoff until the timeout expires. Whatever could not be delivered is written to the
+// spool. The returned channel is closed when done.
+func (c *Channel) Close(retryTimeout ...time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+
+	c.mu.Lock()
+	c.closed = true
+	c.report.Retried += len(c.pending)
+	remaining := append(c.pending, c.buffer...)
+	c.pending = nil
+	c.buffer = nil
+	c.mu.Unlock()
+
+	var deadline time.Time
+	if len(retryTimeout) > 0 {
+		deadline = time.Now().Add(retryTimeout[0])
+	}
+
+	go func() {
+		defer close(done)
+
+		// the deadline bounds each transmission as well as the retries, so a hung endpoint can't hold up spooling
+		// what's left
+		ctx := context.Background()
+		if !deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+
+		Retry(ctx, c.notBefore, func(retrying bool) bool {
+			if retrying {
+				c.retried(len(remaining))
+			}
+
+			var retry []json.RawMessage
+			for start := 0; start < len(remaining); start += c.batchSize {
+				end := start + c.batchSize
+				if end > len(remaining) {
+					end = len(remaining)
+				}
+				retry = append(retry, c.send(ctx, remaining[start:end])...)
+			}
+
+			remaining = retry
+			return len(remaining) > 0
+		})
+
+		c.retain(remaining)
+	}()
+
+	return done
+}
+
+// transmit sends a batch unless the channel is backing off; envelopes which may succeed later are retained
+func (c *Channel) transmit(batch []json.RawMessage) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if c.IsThrottled() {
+		c.retain(batch)
+		return
+	}
+
+	c.retain(c.send(context.Background(), batch))
+}
+
+// send transmits a batch and returns the envelopes which should be retried
+func (c *Channel) send(ctx context.Context, batch []json.RawMessage) []json.RawMessage {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	res, err := Transmit(ctx, c.client, c.endpoint, batch)
+
+	c.mu.Lock()
+	retry, reason := c.report.Record(batch, res, err)
+	c.mu.Unlock()
+
+	if len(retry) > 0 {
+		c.failed(res, reason)
+	} else {
+		c.succeeded()
+	}
+	return retry
+}
+
+// retain keeps envelopes for another attempt. Once the channel is closed, or if a spool is configured, they are
+// written to the spool instead, so a long outage doesn't grow memory without bound. Without a spool, at most
+// MaxPendingBatches batches are kept, and the rest are dropped.
+func (c *Channel) retain(envelopes []json.RawMessage) {
+	if len(envelopes) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed && c.spool == nil {
+		keep := Retainable(len(c.pending), len(envelopes), c.batchSize)
+		c.pending = append(c.pending, envelopes[:keep]...)
+		if dropped := len(envelopes) - keep; dropped > 0 {
+			c.report.Dropped += dropped
+			c.report.AddReason("retry buffer full: " + c.lastFailure)
+		}
+		return
+	}
+
+	if c.spool == nil {
+		c.report.Dropped += len(envelopes)
+		c.report.AddReason(c.lastFailure)
+		return
+	}
+
+	err := c.spool.Write(spool.Item{
+		Endpoint:  c.endpoint,
+		Envelopes: envelopes,
+	})
+	if err != nil {
+		c.report.Dropped += len(envelopes)
+		c.report.AddReason(fmt.Sprintf("unable to spool: %v", err))
+		return
+	}
+	c.report.Spooled += len(envelopes)
+}
+
+func (c *Channel) retried(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.report.Retried += n
+}
+
+func (c *Channel) failed(res *Result, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastFailure = reason
+
+	c.backoff = NextBackoff(c.backoff)
+	c.retryAt = time.Now().Add(c.backoff)
+	if res != nil && res.RetryAfter != nil && res.RetryAfter.After(c.retryAt) {
+		c.retryAt = *res.RetryAfter
+	}
+}
+
+func (c *Channel) succeeded() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.backoff = 0
+	c.retryAt = time.Time{}
+}
+
+// notBefore returns when the endpoint asked for the next attempt to be made, if it did
+func (c *Channel) notBefore() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.retryAt
+}
@@ -0,0 +1,212 @@
+package channel
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/sampling"
+	"github.com/devigned/apmz/pkg/spool"
+)
+
+func TestChannelClose(t *testing.T) {
+	cases := []struct {
+		name    string
+		status  int
+		body    string
+		spooled int
+		report  Report
+	}{
+		{
+			name:   "Accepted",
+			status: http.StatusOK,
+			body:   `{"itemsReceived":2,"itemsAccepted":2,"errors":[]}`,
+			report: Report{Sent: 2, Accepted: 2},
+		},
+		{
+			name:    "Unavailable",
+			status:  http.StatusServiceUnavailable,
+			spooled: 2,
+			report:  Report{Sent: 2, Spooled: 2},
+		},
+		{
+			name:   "BadRequest",
+			status: http.StatusBadRequest,
+			report: Report{Sent: 2, Rejected: 2, Reasons: []string{"400 Bad Request"}},
+		},
+		{
+			name:    "PartialSuccess",
+			status:  http.StatusPartialContent,
+			body:    `{"itemsReceived":2,"itemsAccepted":0,"errors":[{"index":0,"statusCode":400,"message":"bad"},{"index":1,"statusCode":500,"message":"retry"}]}`,
+			spooled: 1,
+			report:  Report{Sent: 2, Rejected: 1, Spooled: 1, Reasons: []string{"400 bad"}},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			var received []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gz, err := gzip.NewReader(r.Body)
+				require.NoError(t, err)
+				bits, err := ioutil.ReadAll(gz)
+				require.NoError(t, err)
+				received = strings.Split(strings.TrimSpace(string(bits)), "\n")
+				w.WriteHeader(c.status)
+				_, _ = w.Write([]byte(c.body))
+			}))
+			defer srv.Close()
+
+			s := newTestSpool(t)
+			ch, err := New(srv.URL, WithSpool(s))
+			require.NoError(t, err)
+
+			client := apmz.NewTelemetryClient("key")
+			client.Channel().Stop()
+			client.SetChannel(ch)
+			client.TrackTrace("one", contracts.Information)
+			client.TrackTrace("two", contracts.Information)
+			<-ch.Close()
+
+			assert.Len(t, received, 2)
+			entries, err := s.Entries()
+			require.NoError(t, err)
+			spooled := 0
+			for _, entry := range entries {
+				item, err := s.Read(entry)
+				require.NoError(t, err)
+				assert.Equal(t, srv.URL, item.Endpoint)
+				spooled += len(item.Envelopes)
+			}
+			assert.Equal(t, c.spooled, spooled)
+			assert.Equal(t, c.report, ch.Report())
+		})
+	}
+}
+
+func TestChannelSendTransmitsFullBatches(t *testing.T) {
+	transmissions := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		transmissions++
+	}))
+	defer srv.Close()
+
+	ch, err := New(srv.URL, WithBatchSize(2))
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		ch.Send(contracts.NewEnvelope())
+	}
+	assert.Equal(t, 2, transmissions)
+
+	<-ch.Close()
+	assert.Equal(t, 3, transmissions)
+}
+
+func TestChannelCloseWithoutSpoolDrops(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ch, err := New(srv.URL)
+	require.NoError(t, err)
+	ch.Send(contracts.NewEnvelope())
+	<-ch.Close()
+
+	report := ch.Report()
+	assert.Equal(t, 1, report.Dropped)
+	assert.Equal(t, 0, report.Unconfirmed())
+	assert.Equal(t, []string{"transmission failed: 503 Service Unavailable"}, report.Reasons)
+}
+
+func TestChannelCapsPendingWithoutSpool(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ch, err := New(srv.URL, WithBatchSize(1))
+	require.NoError(t, err)
+	for i := 0; i < MaxPendingBatches+2; i++ {
+		ch.Send(contracts.NewEnvelope())
+	}
+
+	report := ch.Report()
+	assert.Equal(t, 2, report.Dropped)
+	assert.Equal(t, []string{"retry buffer full: transmission failed: 503 Service Unavailable"}, report.Reasons)
+	assert.Len(t, ch.pending, MaxPendingBatches)
+}
+
+func TestChannelCloseIsBoundedByRetryTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s := newTestSpool(t)
+	ch, err := New(srv.URL, WithSpool(s))
+	require.NoError(t, err)
+	ch.Send(contracts.NewEnvelope())
+
+	start := time.Now()
+	<-ch.Close(200 * time.Millisecond)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, 1, ch.Report().Spooled)
+}
+
+func TestChannelSendRecordsSampleRates(t *testing.T) {
+	var received string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		bits, err := ioutil.ReadAll(gz)
+		require.NoError(t, err)
+		received = string(bits)
+	}))
+	defer srv.Close()
+
+	sampler, err := sampling.New(25, nil, true)
+	require.NoError(t, err)
+	ch, err := New(srv.URL, WithSampleRates(sampler), WithBatchSize(1))
+	require.NoError(t, err)
+
+	client := apmz.NewTelemetryClient("key")
+	client.Channel().Stop()
+	client.SetChannel(ch)
+
+	client.TrackTrace("sampled", contracts.Information)
+	assert.Contains(t, received, `"sampleRate":25`)
+
+	client.TrackTrace("error", contracts.Error)
+	assert.Contains(t, received, `"sampleRate":100`)
+	<-ch.Close()
+
+	assert.Equal(t, Report{Sent: 2, Accepted: 2}, ch.Report())
+}
+
+func newTestSpool(t *testing.T) *spool.Spool {
+	dir, err := ioutil.TempDir("", "apmz-channel")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	s, err := spool.New(dir)
+	require.NoError(t, err)
+	return s
+}
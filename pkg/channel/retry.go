@@ -0,0 +1,73 @@
+package channel
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// MinBackoff is the wait before the first retry of a failed delivery
+	MinBackoff = 1 * time.Second
+	// MaxBackoff caps the wait between retries of a failed delivery
+	MaxBackoff = 1 * time.Minute
+
+	// MaxPendingBatches is the number of failed batches kept in memory for another attempt, when they can't be spooled
+	MaxPendingBatches = 10
+)
+
+// NextBackoff returns the wait after the backoff; double it, up to MaxBackoff, or MinBackoff if there was none
+func NextBackoff(backoff time.Duration) time.Duration {
+	if backoff <= 0 {
+		return MinBackoff
+	}
+
+	backoff *= 2
+	if backoff > MaxBackoff {
+		return MaxBackoff
+	}
+	return backoff
+}
+
+// Retainable returns how many of n failed items can be kept in memory for another attempt when pending items already
+// are, so that at most MaxPendingBatches batches of batchSize are kept
+func Retainable(pending, n, batchSize int) int {
+	keep := MaxPendingBatches*batchSize - pending
+	if keep > n {
+		return n
+	}
+	if keep < 0 {
+		return 0
+	}
+	return keep
+}
+
+// Retry calls attempt, and calls it again with backoff while it returns true because something is left to retry,
+// until the next call would begin after the context's deadline. Without a deadline, attempt is called once. retrying is
+// false on the first call. notBefore, which may be nil, returns the earliest time of the next call, such as one asked
+// for by a Retry-After header. Retry returns true if something was left to retry when it gave up.
+func Retry(ctx context.Context, notBefore func() time.Time, attempt func(retrying bool) bool) bool {
+	deadline, hasDeadline := ctx.Deadline()
+	backoff := MinBackoff
+	for retrying := false; ; retrying = true {
+		if !attempt(retrying) {
+			return false
+		}
+
+		wait := backoff
+		if notBefore != nil {
+			if until := time.Until(notBefore()); until > wait {
+				wait = until
+			}
+		}
+
+		if !hasDeadline || !time.Now().Add(wait).Before(deadline) {
+			return true
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+		backoff = NextBackoff(backoff)
+	}
+}
@@ -0,0 +1,90 @@
+package channel
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextBackoff(t *testing.T) {
+	assert.Equal(t, MinBackoff, NextBackoff(0))
+	assert.Equal(t, 2*MinBackoff, NextBackoff(MinBackoff))
+	assert.Equal(t, MaxBackoff, NextBackoff(MaxBackoff-time.Second))
+	assert.Equal(t, MaxBackoff, NextBackoff(MaxBackoff))
+}
+
+func TestRetainable(t *testing.T) {
+	assert.Equal(t, 3, Retainable(0, 3, 2))
+	assert.Equal(t, 2, Retainable(MaxPendingBatches*2-2, 3, 2))
+	assert.Equal(t, 0, Retainable(MaxPendingBatches*2, 3, 2))
+	assert.Equal(t, 0, Retainable(MaxPendingBatches*2+1, 3, 2))
+}
+
+func TestRetry(t *testing.T) {
+	t.Run("WithoutDeadlineAttemptsOnce", func(t *testing.T) {
+		calls := 0
+		left := Retry(context.Background(), nil, func(retrying bool) bool {
+			assert.False(t, retrying)
+			calls++
+			return true
+		})
+		assert.True(t, left)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("RetriesUntilDone", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var retrying []bool
+		left := Retry(ctx, nil, func(r bool) bool {
+			retrying = append(retrying, r)
+			return len(retrying) < 2
+		})
+		assert.False(t, left)
+		assert.Equal(t, []bool{false, true}, retrying)
+	})
+
+	t.Run("GivesUpAtDeadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+		defer cancel()
+
+		calls := 0
+		left := Retry(ctx, nil, func(bool) bool {
+			calls++
+			return true
+		})
+		assert.True(t, left)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("HonorsNotBefore", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		calls := 0
+		left := Retry(ctx, func() time.Time { return time.Now().Add(time.Minute) }, func(bool) bool {
+			calls++
+			return true
+		})
+		assert.True(t, left)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestReportAddReason(t *testing.T) {
+	var r Report
+	r.AddReason("")
+	r.AddReason("400 Bad Request")
+	r.AddReason("400 Bad Request")
+	for i := 0; i < 2*maxReasons; i++ {
+		r.AddReason(fmt.Sprintf("reason %d", i))
+	}
+
+	assert.Len(t, r.Reasons, maxReasons)
+	assert.Equal(t, "400 Bad Request", r.Reasons[0])
+	assert.Equal(t, "reason 0", r.Reasons[1])
+}
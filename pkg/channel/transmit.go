@@ -0,0 +1,160 @@
+package channel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+type (
+	// Result is the outcome of a single transmission to the track endpoint
+	Result struct {
+		StatusCode int
+		RetryAfter *time.Time
+		Response   *Response
+	}
+
+	// Response is the body returned by the track endpoint
+	Response struct {
+		ItemsReceived int         `json:"itemsReceived"`
+		ItemsAccepted int         `json:"itemsAccepted"`
+		Errors        []ItemError `json:"errors"`
+	}
+
+	// ItemError describes why an individual envelope in a transmission was not accepted
+	ItemError struct {
+		Index      int    `json:"index"`
+		StatusCode int    `json:"statusCode"`
+		Message    string `json:"message"`
+	}
+)
+
+// Transmit posts envelopes to the track endpoint as gzipped, newline delimited json
+func Transmit(ctx context.Context, client *http.Client, endpoint string, envelopes []json.RawMessage) (*Result, error) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	for _, envelope := range envelopes {
+		if _, err := gz.Write(envelope); err != nil {
+			return nil, err
+		}
+		if _, err := gz.Write([]byte{'\n'}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Type", "application/x-json-stream")
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	bits, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{StatusCode: res.StatusCode}
+	if retryAfter := res.Header.Get("Retry-After"); retryAfter != "" {
+		if at, err := http.ParseTime(retryAfter); err == nil {
+			result.RetryAfter = &at
+		}
+	}
+
+	var response Response
+	if err := json.Unmarshal(bits, &response); err == nil {
+		result.Response = &response
+	}
+
+	return result, nil
+}
+
+// IsSuccess is true if every envelope in the transmission was accepted
+func (r *Result) IsSuccess() bool {
+	return r.StatusCode == http.StatusOK ||
+		(r.StatusCode == http.StatusPartialContent && r.Response != nil && r.Response.ItemsReceived == r.Response.ItemsAccepted)
+}
+
+// Accepted returns how many of the n envelopes in the transmission were accepted
+func (r *Result) Accepted(n int) int {
+	switch {
+	case r.StatusCode == http.StatusOK:
+		return n
+	case r.StatusCode == http.StatusPartialContent && r.Response != nil:
+		return r.Response.ItemsAccepted
+	default:
+		return 0
+	}
+}
+
+// IsThrottled is true if the endpoint asked the sender to back off
+func (r *Result) IsThrottled() bool {
+	return r.StatusCode == http.StatusTooManyRequests || r.StatusCode == 439 || r.RetryAfter != nil
+}
+
+// Retryable returns the envelopes of the transmission which may succeed if sent again
+func (r *Result) Retryable(envelopes []json.RawMessage) []json.RawMessage {
+	if r.IsSuccess() {
+		return nil
+	}
+
+	if r.StatusCode == http.StatusPartialContent && r.Response != nil {
+		var retry []json.RawMessage
+		for _, itemErr := range r.Response.Errors {
+			if itemErr.Index >= 0 && itemErr.Index < len(envelopes) && canRetry(itemErr.StatusCode) {
+				retry = append(retry, envelopes[itemErr.Index])
+			}
+		}
+		return retry
+	}
+
+	if r.RetryAfter != nil || canRetry(r.StatusCode) {
+		return envelopes
+	}
+	return nil
+}
+
+// rejectReasons describes why envelopes in the transmission were refused and won't be retried
+func (r *Result) rejectReasons() []string {
+	if r.StatusCode == http.StatusPartialContent && r.Response != nil {
+		var reasons []string
+		for _, itemErr := range r.Response.Errors {
+			if !canRetry(itemErr.StatusCode) {
+				reasons = append(reasons, fmt.Sprintf("%d %s", itemErr.StatusCode, itemErr.Message))
+			}
+		}
+		return reasons
+	}
+
+	if r.Response != nil && len(r.Response.Errors) > 0 {
+		return []string{fmt.Sprintf("%d %s", r.Response.Errors[0].StatusCode, r.Response.Errors[0].Message)}
+	}
+	return []string{fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode))}
+}
+
+func canRetry(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, 439, http.StatusInternalServerError, http.StatusServiceUnavailable:
+		return true
+	default:
+		return false
+	}
+}
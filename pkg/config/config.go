@@ -0,0 +1,232 @@
+// Package config reads and writes apmz config files, which hold named profiles of global flag values, such as the
+// api-keys, global-tags, destinations and enrich flags, so they don't need to be given to every command. The config
+// shared by every user of the machine is read from /etc/apmz/config.yaml, and the user's own, which overrides it,
+// from ~/.config/apmz/config.yaml.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// Config is the contents of a config file
+	Config struct {
+		// Profile is the profile used when none is chosen with --profile; "default" if empty
+		Profile string `yaml:"profile,omitempty" json:"profile,omitempty"`
+		// Profiles are sets of global flag values, keyed by name
+		Profiles map[string]Profile `yaml:"profiles,omitempty" json:"profiles,omitempty"`
+	}
+
+	// Profile maps the names of global flags to their values, with lists and maps for flags which take several; eg
+	// api-keys: [key1, key2] or global-tags: {env: prod}
+	Profile map[string]interface{}
+)
+
+const (
+	// DefaultProfile is the profile used when none is chosen
+	DefaultProfile = "default"
+
+	// SystemPath is the config file shared by every user of the machine
+	SystemPath = "/etc/apmz/config.yaml"
+)
+
+// UserPath returns the path of the current user's config file; eg ~/.config/apmz/config.yaml
+func UserPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "apmz", "config.yaml"), nil
+}
+
+// Load reads the config files which exist, with the profiles of each file overriding the values of the ones before
+func Load(paths ...string) (*Config, error) {
+	c := new(Config)
+	for _, path := range paths {
+		file, err := LoadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.Merge(file)
+	}
+	return c, nil
+}
+
+// LoadFile reads a config file
+func LoadFile(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(Config)
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("unable to read config file %s: %v", path, err)
+	}
+
+	for name, p := range c.Profiles {
+		for k, v := range p {
+			if p[k], err = normalize(v); err != nil {
+				return nil, fmt.Errorf("config file %s: profile %q: %s: %v", path, name, k, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// Save writes the config to a file readable only by its owner, as profiles hold instrumentation keys
+func (c *Config) Save(path string) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// Merge overrides the values of the config with those of other, profile by profile
+func (c *Config) Merge(other *Config) {
+	if other.Profile != "" {
+		c.Profile = other.Profile
+	}
+
+	for name, p := range other.Profiles {
+		if c.Profiles == nil {
+			c.Profiles = make(map[string]Profile)
+		}
+		if c.Profiles[name] == nil {
+			c.Profiles[name] = make(Profile)
+		}
+		for k, v := range p {
+			c.Profiles[name][k] = v
+		}
+	}
+}
+
+// ProfileName returns the name of the profile chosen by name, or the config's profile, or the default profile
+func (c *Config) ProfileName(name string) string {
+	switch {
+	case name != "":
+		return name
+	case c.Profile != "":
+		return c.Profile
+	default:
+		return DefaultProfile
+	}
+}
+
+// Select returns the profile chosen by name, or the config's profile, or the default profile. A profile which was
+// chosen must exist, but there needn't be a default profile.
+func (c *Config) Select(name string) (Profile, error) {
+	name = c.ProfileName(name)
+	p, ok := c.Profiles[name]
+	if !ok && name != DefaultProfile {
+		return nil, fmt.Errorf("profile %q not found", name)
+	}
+	return p, nil
+}
+
+// Keys returns the names of the flags set by the profile, sorted
+func (p Profile) Keys() []string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Values returns the flag values of a profile value; a value for each item of a list, a key=value for each entry of
+// a map, sorted by key, or the value of a scalar
+func Values(v interface{}) ([]string, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		values := make([]string, len(t))
+		for i, item := range t {
+			s, ok := scalar(item)
+			if !ok {
+				return nil, fmt.Errorf("expected a list of values, but it contains %v", item)
+			}
+			values[i] = s
+		}
+		return values, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		values := make([]string, len(keys))
+		for i, k := range keys {
+			s, ok := scalar(t[k])
+			if !ok {
+				return nil, fmt.Errorf("expected a map of values, but %s is %v", k, t[k])
+			}
+			values[i] = k + "=" + s
+		}
+		return values, nil
+	default:
+		s, ok := scalar(v)
+		if !ok {
+			return nil, fmt.Errorf("unexpected value %v", v)
+		}
+		return []string{s}, nil
+	}
+}
+
+// normalize turns the maps of decoded yaml into maps keyed by strings, so profiles can be printed as json
+func normalize(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			key, ok := scalar(k)
+			if !ok {
+				return nil, fmt.Errorf("unexpected key %v", k)
+			}
+
+			var err error
+			if m[key], err = normalize(item); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, len(t))
+		for i, item := range t {
+			var err error
+			if list[i], err = normalize(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	default:
+		return v, nil
+	}
+}
+
+func scalar(v interface{}) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(t), true
+	default:
+		return "", false
+	}
+}
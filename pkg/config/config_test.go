@@ -0,0 +1,125 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMergesProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	system := filepath.Join(dir, "system.yaml")
+	require.NoError(t, ioutil.WriteFile(system, []byte(`profile: prod
+profiles:
+  prod:
+    api-keys: [system-key]
+    global-tags: {env: prod}
+    enrich: [azure]
+  ci:
+    sample-rate: 10
+`), 0644))
+
+	user := filepath.Join(dir, "user.yaml")
+	require.NoError(t, ioutil.WriteFile(user, []byte(`profiles:
+  prod:
+    api-keys: [user-key, other-key]
+`), 0644))
+
+	c, err := Load(system, filepath.Join(dir, "missing.yaml"), user)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", c.Profile)
+
+	p, err := c.Select("")
+	require.NoError(t, err)
+	assert.Equal(t, Profile{
+		"api-keys":    []interface{}{"user-key", "other-key"},
+		"global-tags": map[string]interface{}{"env": "prod"},
+		"enrich":      []interface{}{"azure"},
+	}, p)
+	assert.Equal(t, []string{"api-keys", "enrich", "global-tags"}, p.Keys())
+
+	p, err = c.Select("ci")
+	require.NoError(t, err)
+	assert.Equal(t, Profile{"sample-rate": 10}, p)
+
+	_, err = c.Select("missing")
+	assert.EqualError(t, err, `profile "missing" not found`)
+}
+
+func TestSelectWithoutDefaultProfile(t *testing.T) {
+	c := new(Config)
+	assert.Equal(t, DefaultProfile, c.ProfileName(""))
+	p, err := c.Select("")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = LoadFile(filepath.Join(dir, "missing.yaml"))
+	assert.True(t, os.IsNotExist(err))
+
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("profiels: {}\n"), 0644))
+	_, err = LoadFile(path)
+	assert.Error(t, err)
+}
+
+func TestSaveRoundTrips(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &Config{Profiles: map[string]Profile{
+		DefaultProfile: {"api-keys": []interface{}{"key"}, "global-tags": map[string]interface{}{"team": "ops"}, "statsd-events": true},
+	}}
+	path := filepath.Join(dir, "apmz", "config.yaml")
+	require.NoError(t, c.Save(path))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := LoadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, c, loaded)
+}
+
+func TestValues(t *testing.T) {
+	cases := []struct {
+		name   string
+		value  interface{}
+		expect []string
+	}{
+		{name: "String", value: "10s", expect: []string{"10s"}},
+		{name: "Number", value: 50, expect: []string{"50"}},
+		{name: "Float", value: 12.5, expect: []string{"12.5"}},
+		{name: "Bool", value: true, expect: []string{"true"}},
+		{name: "List", value: []interface{}{"a,b", "c"}, expect: []string{"a,b", "c"}},
+		{name: "Map", value: map[string]interface{}{"team": "ops", "env": "prod"}, expect: []string{"env=prod", "team=ops"}},
+		{name: "Null", value: nil, expect: nil},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			values, err := Values(c.value)
+			require.NoError(t, err)
+			assert.Equal(t, c.expect, values)
+		})
+	}
+
+	_, err := Values([]interface{}{map[string]interface{}{}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,85 @@
+// Package connstr parses Application Insights connection strings, such as
+// "InstrumentationKey=00000000-0000-0000-0000-000000000000;IngestionEndpoint=https://westus2-0.in.applicationinsights.azure.com/",
+// so telemetry can be sent to regional, sovereign cloud and private link ingestion endpoints.
+package connstr
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type (
+	// ConnectionString identifies an Application Insights resource and where to send its telemetry
+	ConnectionString struct {
+		InstrumentationKey string
+		IngestionEndpoint  string
+	}
+)
+
+const (
+	// DefaultIngestionEndpoint is used when the connection string is a bare instrumentation key or doesn't specify
+	// an endpoint
+	DefaultIngestionEndpoint = "https://dc.services.visualstudio.com"
+
+	trackPath = "/v2/track"
+)
+
+// Parse parses a connection string. A value without any "=" is taken to be a bare instrumentation key, so anything
+// which accepts a key also accepts a connection string.
+func Parse(s string) (*ConnectionString, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "=") {
+		if s == "" {
+			return nil, fmt.Errorf("instrumentation key must not be empty")
+		}
+		return &ConnectionString{InstrumentationKey: s, IngestionEndpoint: DefaultIngestionEndpoint}, nil
+	}
+
+	cs := &ConnectionString{}
+	var suffix string
+	for _, pair := range strings.Split(s, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		idx := strings.Index(pair, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("connection string segment %q must be formatted as key=value", pair)
+		}
+
+		key, value := strings.TrimSpace(pair[:idx]), strings.TrimSpace(pair[idx+1:])
+		switch strings.ToLower(key) {
+		case "instrumentationkey":
+			cs.InstrumentationKey = value
+		case "ingestionendpoint":
+			cs.IngestionEndpoint = value
+		case "endpointsuffix":
+			suffix = value
+		}
+	}
+
+	if cs.InstrumentationKey == "" {
+		return nil, fmt.Errorf("connection string must contain an InstrumentationKey")
+	}
+
+	if cs.IngestionEndpoint == "" && suffix != "" {
+		cs.IngestionEndpoint = "https://dc." + strings.Trim(suffix, ".")
+	}
+
+	if cs.IngestionEndpoint == "" {
+		cs.IngestionEndpoint = DefaultIngestionEndpoint
+	}
+
+	u, err := url.Parse(cs.IngestionEndpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("ingestion endpoint %q must be an absolute url", cs.IngestionEndpoint)
+	}
+
+	return cs, nil
+}
+
+// TrackEndpoint is the url telemetry is posted to
+func (cs *ConnectionString) TrackEndpoint() string {
+	return strings.TrimSuffix(cs.IngestionEndpoint, "/") + trackPath
+}
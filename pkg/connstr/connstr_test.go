@@ -0,0 +1,79 @@
+package connstr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name          string
+		input         string
+		key           string
+		trackEndpoint string
+		err           string
+	}{
+		{
+			name:          "BareKey",
+			input:         "00000000-0000-0000-0000-000000000000",
+			key:           "00000000-0000-0000-0000-000000000000",
+			trackEndpoint: "https://dc.services.visualstudio.com/v2/track",
+		},
+		{
+			name:          "IngestionEndpoint",
+			input:         "InstrumentationKey=key;IngestionEndpoint=https://westus2-0.in.applicationinsights.azure.com/",
+			key:           "key",
+			trackEndpoint: "https://westus2-0.in.applicationinsights.azure.com/v2/track",
+		},
+		{
+			name:          "CaseInsensitiveWithTrailingSemicolon",
+			input:         "instrumentationkey=key;ingestionendpoint=http://localhost:8080;",
+			key:           "key",
+			trackEndpoint: "http://localhost:8080/v2/track",
+		},
+		{
+			name:          "EndpointSuffix",
+			input:         "InstrumentationKey=key;EndpointSuffix=applicationinsights.us",
+			key:           "key",
+			trackEndpoint: "https://dc.applicationinsights.us/v2/track",
+		},
+		{
+			name:          "KeyOnly",
+			input:         "InstrumentationKey=key",
+			key:           "key",
+			trackEndpoint: "https://dc.services.visualstudio.com/v2/track",
+		},
+		{
+			name:  "MissingKey",
+			input: "IngestionEndpoint=https://localhost",
+			err:   "connection string must contain an InstrumentationKey",
+		},
+		{
+			name:  "RelativeEndpoint",
+			input: "InstrumentationKey=key;IngestionEndpoint=localhost",
+			err:   `ingestion endpoint "localhost" must be an absolute url`,
+		},
+		{
+			name:  "Empty",
+			input: " ",
+			err:   "instrumentation key must not be empty",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			cs, err := Parse(c.input)
+			if c.err != "" {
+				assert.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.key, cs.InstrumentationKey)
+			assert.Equal(t, c.trackEndpoint, cs.TrackEndpoint())
+		})
+	}
+}
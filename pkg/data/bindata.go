@@ -64,6 +64,10 @@ trace_info() {
     return
 }
 
+trace_exception() {
+    return
+}
+
 time_metric() {
     shift
     "$@"
@@ -93,7 +97,7 @@ func dataDisabled_bashGosh() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "data/disabled_bash.gosh", size: 228, mode: os.FileMode(420), modTime: time.Unix(1578931050, 0)}
+	info := bindataFileInfo{name: "data/disabled_bash.gosh", size: 262, mode: os.FileMode(420), modTime: time.Unix(1792258865, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -105,8 +109,25 @@ __SCRIPT_SESSION_ID="${__SCRIPT_SESSION_ID:-$(apmz uuid)}"
 __SCRIPT_START_TIME=$(apmz time unixnano)
 __SCRIPT_NAME="${__SCRIPT_NAME:-{{.ScriptName}}}"
 __APP_INSIGHTS_KEYS="${__APP_INSIGHTS_KEYS:-{{.AppInsightsKeys}}}"
+__OTLP_ENDPOINTS="${__OTLP_ENDPOINTS:-{{.OTLPEndpoints}}}"
+__PROMETHEUS_TEXTFILE_DIR="${__PROMETHEUS_TEXTFILE_DIR:-{{.TextfileDir}}}"
+__PUSHGATEWAY_URL="${__PUSHGATEWAY_URL:-{{.PushgatewayURL}}}"
+__STATSD_ADDRESS="${__STATSD_ADDRESS:-{{.StatsdAddress}}}"
+__STATSD_METRIC_TYPE="${__STATSD_METRIC_TYPE:-{{.StatsdType}}}"
+__STATSD_EVENTS="${__STATSD_EVENTS:-{{.StatsdEvents}}}"
+__DESTINATIONS="${__DESTINATIONS:-{{.Destinations}}}"
+__ROUTES_FILE="${__ROUTES_FILE:-{{.RoutesFile}}}"
+__PROFILE="${__PROFILE:-{{.Profile}}}"
+__CONFIG="${__CONFIG:-{{.Config}}}"
 __DEFAULT_TAGS="${__DEFAULT_TAGS:-{{.DefaultTags}}}"
 __DEFAULT_TIME="${__DEFAULT_TIME:-sec}"
+__EXIT_AS_REQUEST="${__EXIT_AS_REQUEST:-{{.ExitAsRequest}}}"
+__CLOUD_ROLE="${__CLOUD_ROLE:-${__SCRIPT_NAME}}"
+
+# every apmz call tags its telemetry with the script run as the operation and the script as the cloud role
+export APMZ_OPERATION_ID="${__SCRIPT_SESSION_ID}"
+export APMZ_OPERATION_NAME="${__SCRIPT_NAME}"
+export APMZ_CLOUD_ROLE="${__CLOUD_ROLE}"
 
 # trace_err will log an error level trace event to the tmp batch file in $TMP_APMZ_BATCH_FILE
 #
@@ -134,6 +155,22 @@ trace_info() {
   fi
 }
 
+# trace_exception will log an exception with the current call stack to the tmp batch file in $TMP_APMZ_BATCH_FILE
+#
+# should be invoked in the following way: `+"`"+`trace_exception "message" "tag1=value,tag2=value"`+"`"+`
+trace_exception() {
+  local message=$1 tags=$2 funcnames sources lines
+  funcnames=$(IFS=,; echo "${FUNCNAME[*]:1}")
+  sources=$(IFS=,; echo "${BASH_SOURCE[*]:1}")
+  lines=$(IFS=,; echo "${BASH_LINENO[*]}")
+  tags=$(append_default_tags "${tags}")
+  if [[ -z "${tags}" ]]; then
+    apmz exception -m "${message}" --funcnames "${funcnames}" --sources "${sources}" --lines "${lines}" -o >>"${__TMP_APMZ_BATCH_FILE}"
+  else
+    apmz exception -m "${message}" --funcnames "${funcnames}" --sources "${sources}" --lines "${lines}" -t "${tags}" -o >>"${__TMP_APMZ_BATCH_FILE}"
+  fi
+}
+
 # time_metric will log a custom metric event to the tmp batch file in $TMP_APMZ_BATCH_FILE
 #
 # should be invoked in the following way: `+"`"+`time_metric "metric_name" fuction_to_time(...)`+"`"+`
@@ -196,25 +233,80 @@ join_tags() {
   fi
 }
 
-exitAndFlush() {
-  local tags script_end duration
-  tags=$(append_default_tags "code=$?")
-  if [[ "$?" == "0" ]]; then
-    trace_info "$__SCRIPT_NAME-exit" "${tags}"
+# exit_request will log the whole script run as a request event to the tmp batch file in $TMP_APMZ_BATCH_FILE
+#
+# should be invoked in the following way: `+"`"+`exit_request "${exit_code}" "${script_end}"`+"`"+`
+exit_request() {
+  local code=$1 script_end=$2 success=true
+  if [[ "${code}" != "0" ]]; then
+    success=false
+  fi
+
+  if [[ -z "${__DEFAULT_TAGS}" ]]; then
+    apmz request -n "$__SCRIPT_NAME" --id "${__SCRIPT_SESSION_ID}" \
+      --start "$__SCRIPT_START_TIME" --end "${script_end}" -r "${code}" -s="${success}" -o >>"${__TMP_APMZ_BATCH_FILE}"
   else
-    trace_err "$__SCRIPT_NAME-exit" "${tags}"
+    apmz request -n "$__SCRIPT_NAME" --id "${__SCRIPT_SESSION_ID}" \
+      --start "$__SCRIPT_START_TIME" --end "${script_end}" -r "${code}" -s="${success}" -t "${__DEFAULT_TAGS}" -o >>"${__TMP_APMZ_BATCH_FILE}"
   fi
+}
 
+exitAndFlush() {
+  local code=$? tags script_end duration destinations
   script_end=$(apmz time unixnano)
-  duration=$(apmz time diff -a "$__SCRIPT_START_TIME" -b "$script_end"  -r "${__DEFAULT_TIME}")
-  if [[ -z "${__DEFAULT_TAGS}" ]]; then
-    apmz metric -n "$__SCRIPT_NAME-duration" -v "${duration}" -o >>"${__TMP_APMZ_BATCH_FILE}"
+  if [[ "${__EXIT_AS_REQUEST}" == "true" ]]; then
+    exit_request "${code}" "${script_end}"
   else
-    apmz metric -n "$__SCRIPT_NAME-duration" -v "${duration}" -t "${__DEFAULT_TAGS}" -o >>"${__TMP_APMZ_BATCH_FILE}"
+    tags=$(append_default_tags "code=${code}")
+    if [[ "${code}" == "0" ]]; then
+      trace_info "$__SCRIPT_NAME-exit" "${tags}"
+    else
+      trace_err "$__SCRIPT_NAME-exit" "${tags}"
+    fi
+
+    duration=$(apmz time diff -a "$__SCRIPT_START_TIME" -b "$script_end"  -r "${__DEFAULT_TIME}")
+    if [[ -z "${__DEFAULT_TAGS}" ]]; then
+      apmz metric -n "$__SCRIPT_NAME-duration" -v "${duration}" -o >>"${__TMP_APMZ_BATCH_FILE}"
+    else
+      apmz metric -n "$__SCRIPT_NAME-duration" -v "${duration}" -t "${__DEFAULT_TAGS}" -o >>"${__TMP_APMZ_BATCH_FILE}"
+    fi
+  fi
+
+  destinations=()
+  if [[ -n "${__APP_INSIGHTS_KEYS}" ]]; then
+    destinations+=(--api-keys "${__APP_INSIGHTS_KEYS}")
+  fi
+  if [[ -n "${__OTLP_ENDPOINTS}" ]]; then
+    destinations+=(--otlp-endpoints "${__OTLP_ENDPOINTS}")
+  fi
+  if [[ -n "${__PROMETHEUS_TEXTFILE_DIR}" ]]; then
+    destinations+=(--prometheus-textfile-dir "${__PROMETHEUS_TEXTFILE_DIR}")
+  fi
+  if [[ -n "${__PUSHGATEWAY_URL}" ]]; then
+    destinations+=(--pushgateway-url "${__PUSHGATEWAY_URL}")
+  fi
+  if [[ -n "${__STATSD_ADDRESS}" ]]; then
+    destinations+=(--statsd-address "${__STATSD_ADDRESS}" --statsd-metric-type "${__STATSD_METRIC_TYPE:-gauge}")
+    if [[ "${__STATSD_EVENTS}" == "true" ]]; then
+      destinations+=(--statsd-events)
+    fi
+  fi
+  if [[ -n "${__DESTINATIONS}" ]]; then
+    destinations+=(--destinations "${__DESTINATIONS}")
+  fi
+  if [[ -n "${__ROUTES_FILE}" ]]; then
+    destinations+=(--routes-file "${__ROUTES_FILE}")
   fi
 
-  if [[ -n "${__APP_INSIGHTS_KEYS}" && -z "${__DRY_RUN}" ]]; then
-    apmz batch -f "${__TMP_APMZ_BATCH_FILE}" --api-keys "${__APP_INSIGHTS_KEYS}"
+  if [[ ${#destinations[@]} -gt 0 && -z "${__DRY_RUN}" ]]; then
+    # the profile fills in the settings which weren't baked in, such as sampling and enrichment
+    if [[ -n "${__PROFILE}" ]]; then
+      destinations+=(--profile "${__PROFILE}")
+    fi
+    if [[ -n "${__CONFIG}" ]]; then
+      destinations+=(--config "${__CONFIG}")
+    fi
+    apmz batch -f "${__TMP_APMZ_BATCH_FILE}" "${destinations[@]}"
   fi
 
   if [[ -z "${__PRESERVE_TMP_FILE}" ]]; then
@@ -236,7 +328,7 @@ func dataEnabled_bashGosh() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "data/enabled_bash.gosh", size: 4197, mode: os.FileMode(420), modTime: time.Unix(1579309455, 0)}
+	info := bindataFileInfo{name: "data/enabled_bash.gosh", size: 8010, mode: os.FileMode(420), modTime: time.Unix(1792262313, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
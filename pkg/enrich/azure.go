@@ -0,0 +1,148 @@
+// Package enrich adds details about the machine apmz is running on to telemetry.
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/devigned/apmz/internal/atomicfile"
+	"github.com/devigned/apmz/pkg/azmeta"
+)
+
+type (
+	// Enrichment is the custom properties and cloud role instance to attach to every telemetry item
+	Enrichment struct {
+		Fetched      time.Time         `json:"fetched"`
+		Properties   map[string]string `json:"properties"`
+		RoleInstance string            `json:"roleInstance,omitempty"`
+		// Error is why the instance metadata couldn't be fetched, if it couldn't
+		Error string `json:"error,omitempty"`
+	}
+
+	// InstanceGetter fetches the Azure instance metadata for the local machine
+	InstanceGetter interface {
+		GetInstance(ctx context.Context, middleware ...azmeta.MiddlewareFunc) (*azmeta.Instance, error)
+	}
+)
+
+const (
+	// Azure enriches telemetry with the Azure instance metadata of the VM
+	Azure = "azure"
+
+	// AzureCacheTTL is how long fetched instance metadata is reused before it is fetched again
+	AzureCacheTTL = 1 * time.Hour
+
+	// AzureFailureTTL is how long a failure to fetch instance metadata is remembered, so that a machine which isn't an
+	// Azure VM doesn't wait on the instance metadata service at every invocation
+	AzureFailureTTL = 5 * time.Minute
+)
+
+// DefaultAzureCachePath returns where instance metadata is cached between invocations of apmz
+func DefaultAzureCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "apmz", "azure-instance.json"), nil
+}
+
+// LoadAzure returns the enrichment for the Azure VM. Fetching from the instance metadata service takes a round
+// trip, so the enrichment is cached at cachePath and reused until it's older than AzureCacheTTL. A failure to fetch is
+// cached too; until it's older than AzureFailureTTL, no enrichment and no error are returned, as the failure has
+// already been reported.
+func LoadAzure(ctx context.Context, getter InstanceGetter, cachePath string) (*Enrichment, error) {
+	if e, err := readCache(cachePath); err == nil {
+		switch {
+		case e.Error == "" && time.Since(e.Fetched) < AzureCacheTTL:
+			return e, nil
+		case e.Error != "" && time.Since(e.Fetched) < AzureFailureTTL:
+			return nil, nil
+		}
+	}
+
+	e, err := fetchAzure(ctx, getter)
+	if err != nil {
+		// failing to cache the failure only costs the next invocation another attempt
+		_ = writeCache(cachePath, &Enrichment{Fetched: time.Now(), Error: err.Error()})
+		return nil, err
+	}
+
+	// failing to cache only costs the next invocation a round trip
+	_ = writeCache(cachePath, e)
+	return e, nil
+}
+
+func fetchAzure(ctx context.Context, getter InstanceGetter) (*Enrichment, error) {
+	instance, err := getter.GetInstance(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch instance metadata: %w", err)
+	}
+
+	if instance.Compute == nil {
+		return nil, fmt.Errorf("instance metadata has no compute details")
+	}
+
+	e := FromInstance(instance)
+	e.Fetched = time.Now()
+	return e, nil
+}
+
+// FromInstance builds an enrichment from the Azure instance metadata
+func FromInstance(instance *azmeta.Instance) *Enrichment {
+	e := &Enrichment{
+		Properties: make(map[string]string),
+	}
+
+	compute := instance.Compute
+	if compute == nil {
+		return e
+	}
+
+	for k, v := range map[string]string{
+		"azure.vmId":           compute.VMID,
+		"azure.vmSize":         compute.VMSize,
+		"azure.location":       compute.Location,
+		"azure.zone":           compute.Zone,
+		"azure.resourceId":     compute.ResourceID,
+		"azure.vmScaleSetName": compute.VMScaleSetName,
+		"azure.subscriptionId": compute.SubscriptionID,
+	} {
+		if v != "" {
+			e.Properties[k] = v
+		}
+	}
+
+	e.RoleInstance = compute.Name
+	return e
+}
+
+func readCache(path string) (*Enrichment, error) {
+	bits, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var e Enrichment
+	if err := json.Unmarshal(bits, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func writeCache(path string, e *Enrichment) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	bits, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.Write(path, bits, 0600)
+}
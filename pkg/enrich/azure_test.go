@@ -0,0 +1,145 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	mocks "github.com/devigned/apmz/internal/test"
+	"github.com/devigned/apmz/pkg/azmeta"
+)
+
+func TestLoadAzure(t *testing.T) {
+	instance := &azmeta.Instance{
+		Compute: &azmeta.Compute{
+			Name:           "vmss_0",
+			VMID:           "vm-id",
+			VMSize:         "Standard_D2s_v3",
+			Location:       "westus2",
+			Zone:           "1",
+			ResourceID:     "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/vmss/virtualMachines/0",
+			VMScaleSetName: "vmss",
+			SubscriptionID: "sub",
+		},
+	}
+
+	cases := []struct {
+		name       string
+		cache      *Enrichment
+		setup      func(md *mocks.MetadataMock)
+		err        string
+		assertions func(t *testing.T, md *mocks.MetadataMock, e *Enrichment)
+	}{
+		{
+			name: "FetchesAndCaches",
+			setup: func(md *mocks.MetadataMock) {
+				md.On("GetInstance", mock.Anything, mock.Anything).Return(instance, nil).Once()
+			},
+			assertions: func(t *testing.T, md *mocks.MetadataMock, e *Enrichment) {
+				assert.Equal(t, "vmss_0", e.RoleInstance)
+				assert.Equal(t, map[string]string{
+					"azure.vmId":           "vm-id",
+					"azure.vmSize":         "Standard_D2s_v3",
+					"azure.location":       "westus2",
+					"azure.zone":           "1",
+					"azure.resourceId":     instance.Compute.ResourceID,
+					"azure.vmScaleSetName": "vmss",
+					"azure.subscriptionId": "sub",
+				}, e.Properties)
+				md.AssertExpectations(t)
+			},
+		},
+		{
+			name:  "UsesFreshCache",
+			cache: &Enrichment{Fetched: time.Now(), Properties: map[string]string{"azure.vmId": "cached"}},
+			setup: func(md *mocks.MetadataMock) {},
+			assertions: func(t *testing.T, md *mocks.MetadataMock, e *Enrichment) {
+				assert.Equal(t, "cached", e.Properties["azure.vmId"])
+				md.AssertNotCalled(t, "GetInstance", mock.Anything, mock.Anything)
+			},
+		},
+		{
+			name:  "RefreshesStaleCache",
+			cache: &Enrichment{Fetched: time.Now().Add(-2 * AzureCacheTTL), Properties: map[string]string{"azure.vmId": "cached"}},
+			setup: func(md *mocks.MetadataMock) {
+				md.On("GetInstance", mock.Anything, mock.Anything).Return(instance, nil).Once()
+			},
+			assertions: func(t *testing.T, md *mocks.MetadataMock, e *Enrichment) {
+				assert.Equal(t, "vm-id", e.Properties["azure.vmId"])
+			},
+		},
+		{
+			name:  "RetriesStaleFailure",
+			cache: &Enrichment{Fetched: time.Now().Add(-2 * AzureFailureTTL), Error: "no route to host"},
+			setup: func(md *mocks.MetadataMock) {
+				md.On("GetInstance", mock.Anything, mock.Anything).Return(instance, nil).Once()
+			},
+			assertions: func(t *testing.T, md *mocks.MetadataMock, e *Enrichment) {
+				assert.Equal(t, "vm-id", e.Properties["azure.vmId"])
+				md.AssertExpectations(t)
+			},
+		},
+		{
+			name: "NotAnAzureVM",
+			setup: func(md *mocks.MetadataMock) {
+				md.On("GetInstance", mock.Anything, mock.Anything).Return((*azmeta.Instance)(nil), errors.New("no route to host"))
+			},
+			err: "unable to fetch instance metadata: no route to host",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			dir, err := ioutil.TempDir("", "apmz-enrich")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			cachePath := filepath.Join(dir, "azure-instance.json")
+			if c.cache != nil {
+				require.NoError(t, writeCache(cachePath, c.cache))
+			}
+
+			md := new(mocks.MetadataMock)
+			c.setup(md)
+			e, err := LoadAzure(context.Background(), md, cachePath)
+			if c.err != "" {
+				assert.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			c.assertions(t, md, e)
+
+			cached, err := readCache(cachePath)
+			require.NoError(t, err)
+			assert.Equal(t, e.Properties, cached.Properties)
+		})
+	}
+}
+
+func TestLoadAzureCachesFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-enrich")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cachePath := filepath.Join(dir, "azure-instance.json")
+	md := new(mocks.MetadataMock)
+	md.On("GetInstance", mock.Anything, mock.Anything).Return((*azmeta.Instance)(nil), errors.New("no route to host")).Once()
+
+	_, err = LoadAzure(context.Background(), md, cachePath)
+	assert.EqualError(t, err, "unable to fetch instance metadata: no route to host")
+
+	e, err := LoadAzure(context.Background(), md, cachePath)
+	assert.NoError(t, err)
+	assert.Nil(t, e)
+	md.AssertNumberOfCalls(t, "GetInstance", 1)
+}
@@ -0,0 +1,87 @@
+// Package eventfile provides an apmz destination which appends telemetry to a file as batch events, one per line, so
+// the file can be kept as a log or sent later with `apmz batch -f`.
+package eventfile
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/devigned/apmz-sdk/apmz"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+type (
+	// Sink is a service.APMer which appends telemetry to a file as batch events
+	Sink struct {
+		path string
+
+		mu      sync.Mutex
+		file    *os.File
+		openErr error
+		report  channel.Report
+	}
+)
+
+var _ service.APMer = (*Sink)(nil)
+
+// New creates a sink which appends to the file at the path, creating it if it doesn't exist
+func New(path string) *Sink {
+	return &Sink{path: path}
+}
+
+// Track appends the item to the file as a batch event, opening the file on the first event. Each event is appended in a
+// single write, so events from concurrent invocations of apmz don't interleave.
+func (s *Sink) Track(item apmz.Telemetry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.Sent++
+	b, err := json.Marshal(service.DefaultTelemetryKinds.NewEvent(item))
+	if err != nil {
+		s.report.Rejected++
+		s.report.AddReason(err.Error())
+		return
+	}
+
+	if s.file == nil && s.openErr == nil {
+		s.file, s.openErr = os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	}
+
+	if s.openErr != nil {
+		s.report.Dropped++
+		s.report.AddReason(s.openErr.Error())
+		return
+	}
+
+	if _, err := s.file.Write(append(b, '\n')); err != nil {
+		s.report.Dropped++
+		s.report.AddReason(err.Error())
+		return
+	}
+	s.report.Accepted++
+}
+
+// Close closes the file
+func (s *Sink) Close(ctx context.Context) []service.DeliveryResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.report.Sent == 0 {
+		return nil
+	}
+
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			s.report.AddReason(err.Error())
+		}
+		s.file = nil
+	}
+
+	report := s.report
+	report.Reasons = append([]string(nil), s.report.Reasons...)
+	return []service.DeliveryResult{{Key: s.path, Report: report}}
+}
@@ -0,0 +1,79 @@
+package eventfile
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+func TestSinkAppendsBatchEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-eventfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "apmz.jsonl")
+	first := New(path)
+	first.Track(apmz.NewTraceTelemetry("foo", contracts.Warning))
+	first.Track(apmz.NewMetricTelemetry("bar", 2))
+	assert.Equal(t, []service.DeliveryResult{{Key: path, Report: channel.Report{Sent: 2, Accepted: 2}}}, first.Close(context.Background()))
+
+	second := New(path)
+	second.Track(apmz.NewEventTelemetry("baz"))
+	second.Close(context.Background())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []service.Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var evt service.Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &evt))
+		events = append(events, evt)
+	}
+	require.Len(t, events, 3)
+	assert.Equal(t, "foo", events[0].Item.(*apmz.TraceTelemetry).Message)
+	assert.Equal(t, "bar", events[1].Item.(*apmz.MetricTelemetry).Name)
+	assert.Equal(t, "baz", events[2].Item.(*apmz.EventTelemetry).Name)
+}
+
+func TestSinkWritesAsEventsArrive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-eventfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "apmz.jsonl")
+	sink := New(path)
+	defer sink.Close(context.Background())
+	sink.Track(apmz.NewTraceTelemetry("foo", contracts.Warning))
+
+	bits, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(bits), `"foo"`)
+}
+
+func TestSinkUnwritable(t *testing.T) {
+	sink := New(filepath.Join(os.TempDir(), "apmz-does-not-exist", "apmz.jsonl"))
+	sink.Track(apmz.NewTraceTelemetry("foo", contracts.Information))
+	results := sink.Close(context.Background())
+	require.Len(t, results, 1)
+	assert.Equal(t, 1, results[0].Dropped)
+	assert.Len(t, results[0].Reasons, 1)
+}
+
+func TestSinkNothingTracked(t *testing.T) {
+	assert.Nil(t, New("apmz.jsonl").Close(context.Background()))
+}
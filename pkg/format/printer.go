@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
+
+	"github.com/devigned/apmz/pkg/query"
 )
 
 type (
@@ -23,29 +26,36 @@ type (
 	// StdPrinter is a printer that prints to os.Stdout
 	StdPrinter struct {
 		Format OutputType
+		// Query selects part of each object before it is printed; eg "compute.location"
+		Query query.Expression
 	}
 
-	// OutputType represents the type of output, JSON, XML, TSV, etc.
+	// OutputType represents the type of output, JSON, YAML, TSV, etc.
 	OutputType string
 )
 
 var (
 	// JSONFormat tell the printer to print json
 	JSONFormat OutputType = "json"
+	// PrettyJSONFormat tells the printer to print indented json
+	PrettyJSONFormat OutputType = "json-pretty"
+	// YAMLFormat tells the printer to print yaml
+	YAMLFormat OutputType = "yaml"
+	// TableFormat tells the printer to print an aligned table with a header. Nested fields are flattened into dotted
+	// names; eg "compute.location".
+	TableFormat OutputType = "table"
+	// TSVFormat tells the printer to print tab separated values without a header, for reading with cut or awk
+	TSVFormat OutputType = "tsv"
+	// EnvFormat tells the printer to print shell exports, such as COMPUTE_LOCATION='westus2', for use with eval
+	EnvFormat OutputType = "env"
+
+	// OutputTypes are the output types the StdPrinter can print
+	OutputTypes = []OutputType{JSONFormat, PrettyJSONFormat, YAMLFormat, TableFormat, TSVFormat, EnvFormat}
 )
 
 // Print prints an object to os.Stdout
 func (stdPrinter StdPrinter) Print(obj interface{}) error {
-	if printable, ok := obj.(Printable); ok {
-		return printable.Print(os.Stdout, stdPrinter.Format)
-	}
-
-	switch stdPrinter.Format {
-	case JSONFormat:
-		return printJSON(os.Stdout, obj)
-	default:
-		return fmt.Errorf("unable to print %v as type %s", obj, stdPrinter.Format)
-	}
+	return FprintQuery(os.Stdout, stdPrinter.Format, &stdPrinter.Query, obj)
 }
 
 // Printf prints a formatted string
@@ -58,6 +68,84 @@ func (StdPrinter) ErrPrintf(format string, args ...interface{}) {
 	_, _ = fmt.Fprintf(os.Stderr, format, args...)
 }
 
+// Fprint prints an object to the writer in the output type
+func Fprint(writer io.Writer, format OutputType, obj interface{}) error {
+	if printable, ok := obj.(Printable); ok {
+		return printable.Print(writer, format)
+	}
+
+	switch format {
+	case JSONFormat:
+		return printJSON(writer, obj)
+	case PrettyJSONFormat:
+		return printPrettyJSON(writer, obj)
+	case YAMLFormat:
+		return printYAML(writer, obj)
+	case TableFormat:
+		return printTable(writer, obj, true)
+	case TSVFormat:
+		return printTable(writer, obj, false)
+	case EnvFormat:
+		return printEnv(writer, obj)
+	default:
+		return fmt.Errorf("unable to print %v as type %s", obj, format)
+	}
+}
+
+// FprintQuery applies the query to the object and prints the result to the writer in the output type. Scalar results
+// print raw, without quotes, in every output type other than env, so they can be assigned directly to shell variables.
+// A null result prints nothing.
+func FprintQuery(writer io.Writer, format OutputType, q *query.Expression, obj interface{}) error {
+	if q.IsEmpty() {
+		return Fprint(writer, format, obj)
+	}
+
+	v, err := q.Search(obj)
+	if err != nil {
+		return fmt.Errorf("query %q failed: %v", q.String(), err)
+	}
+
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case string, json.Number, bool:
+		if format != EnvFormat {
+			_, err := fmt.Fprintln(writer, t)
+			return err
+		}
+	}
+	return Fprint(writer, format, v)
+}
+
+// String returns the name of the output type
+func (o *OutputType) String() string {
+	return string(*o)
+}
+
+// Set sets the output type from its name, so it can be used as a flag
+func (o *OutputType) Set(name string) error {
+	for _, t := range OutputTypes {
+		if string(t) == strings.ToLower(name) {
+			*o = t
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q; expected one of %s", name, outputTypeNames())
+}
+
+// Type describes the values of the flag
+func (o *OutputType) Type() string {
+	return "format"
+}
+
+func outputTypeNames() string {
+	names := make([]string, len(OutputTypes))
+	for i, t := range OutputTypes {
+		names[i] = string(t)
+	}
+	return strings.Join(names, ", ")
+}
+
 func printJSON(writer io.Writer, obj interface{}) error {
 	bits, err := json.Marshal(obj)
 	if err != nil {
@@ -66,3 +154,12 @@ func printJSON(writer io.Writer, obj interface{}) error {
 	_, err = fmt.Fprint(writer, string(bits)+"\n")
 	return err
 }
+
+func printPrettyJSON(writer io.Writer, obj interface{}) error {
+	bits, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(writer, string(bits)+"\n")
+	return err
+}
@@ -0,0 +1,200 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/query"
+)
+
+type (
+	testCompute struct {
+		Location         string            `json:"location"`
+		PlacementGroupID string            `json:"placementGroupId"`
+		Zones            []string          `json:"zones"`
+		Tags             map[string]string `json:"tags,omitempty"`
+	}
+
+	testInstance struct {
+		Compute testCompute `json:"compute"`
+		Size    int64       `json:"size"`
+	}
+
+	testEvent struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+)
+
+func TestFprint(t *testing.T) {
+	instance := testInstance{
+		Compute: testCompute{
+			Location:         "westus2",
+			PlacementGroupID: "it's",
+			Zones:            []string{"1", "2"},
+		},
+		Size: 1234567890,
+	}
+	events := []testEvent{{ID: "a", Status: "Scheduled"}, {ID: "bb", Status: "Started"}}
+
+	cases := []struct {
+		name   string
+		format OutputType
+		obj    interface{}
+		expect string
+	}{
+		{
+			name:   "JSON",
+			format: JSONFormat,
+			obj:    events[0],
+			expect: `{"id":"a","status":"Scheduled"}` + "\n",
+		},
+		{
+			name:   "PrettyJSON",
+			format: PrettyJSONFormat,
+			obj:    events[0],
+			expect: "{\n  \"id\": \"a\",\n  \"status\": \"Scheduled\"\n}\n",
+		},
+		{
+			name:   "YAML",
+			format: YAMLFormat,
+			obj:    instance,
+			expect: "compute:\n  location: westus2\n  placementGroupId: it's\n  zones:\n  - \"1\"\n  - \"2\"\nsize: 1234567890\n",
+		},
+		{
+			name:   "TableOfObject",
+			format: TableFormat,
+			obj:    instance,
+			expect: "NAME                      VALUE\n" +
+				"compute.location          westus2\n" +
+				"compute.placementGroupId  it's\n" +
+				"compute.zones             1,2\n" +
+				"size                      1234567890\n",
+		},
+		{
+			name:   "TableOfList",
+			format: TableFormat,
+			obj:    events,
+			expect: "ID  STATUS\na   Scheduled\nbb  Started\n",
+		},
+		{
+			name:   "TableOfScalar",
+			format: TableFormat,
+			obj:    "token",
+			expect: "VALUE\ntoken\n",
+		},
+		{
+			name:   "TSVOfObject",
+			format: TSVFormat,
+			obj:    instance,
+			expect: "compute.location\twestus2\ncompute.placementGroupId\tit's\ncompute.zones\t1,2\nsize\t1234567890\n",
+		},
+		{
+			name:   "TSVOfList",
+			format: TSVFormat,
+			obj:    []testEvent{{ID: "a", Status: "line\nbreak"}},
+			expect: "a\tline\\nbreak\n",
+		},
+		{
+			name:   "Env",
+			format: EnvFormat,
+			obj:    instance,
+			expect: "export COMPUTE_LOCATION='westus2'\n" +
+				"export COMPUTE_PLACEMENT_GROUP_ID='it'\\''s'\n" +
+				"export COMPUTE_ZONES='1,2'\n" +
+				"export SIZE='1234567890'\n",
+		},
+		{
+			name:   "EnvOfList",
+			format: EnvFormat,
+			obj:    events[:1],
+			expect: "export ITEM_0_ID='a'\nexport ITEM_0_STATUS='Scheduled'\n",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			var b bytes.Buffer
+			require.NoError(t, Fprint(&b, c.format, c.obj))
+			assert.Equal(t, c.expect, b.String())
+		})
+	}
+}
+
+func TestFprintQuery(t *testing.T) {
+	instance := testInstance{
+		Compute: testCompute{Location: "westus2", Zones: []string{"1", "2"}},
+		Size:    1234567890123,
+	}
+
+	cases := []struct {
+		name   string
+		format OutputType
+		query  string
+		expect string
+	}{
+		{name: "NoQuery", format: JSONFormat, expect: `{"compute":{"location":"westus2","placementGroupId":"","zones":["1","2"]},"size":1234567890123}` + "\n"},
+		{name: "RawString", format: JSONFormat, query: "compute.location", expect: "westus2\n"},
+		{name: "RawStringTSV", format: TSVFormat, query: "compute.location", expect: "westus2\n"},
+		{name: "RawNumber", format: YAMLFormat, query: "size", expect: "1234567890123\n"},
+		{name: "RawBool", format: TableFormat, query: "compute.location == 'westus2'", expect: "true\n"},
+		{name: "Null", format: JSONFormat, query: "compute.missing", expect: ""},
+		{name: "List", format: JSONFormat, query: "compute.zones", expect: `["1","2"]` + "\n"},
+		{name: "ListTSV", format: TSVFormat, query: "compute.zones", expect: "1\n2\n"},
+		{name: "ListTable", format: TableFormat, query: "compute.zones", expect: "VALUE\n1\n2\n"},
+		{name: "ListOfListsTSV", format: TSVFormat, query: "[[compute.location, size]]", expect: "westus2\t1234567890123\n"},
+		{name: "ListOfListsTable", format: TableFormat, query: "[[compute.location, size]]", expect: "VALUE    VALUE2\nwestus2  1234567890123\n"},
+		{name: "EnvScalar", format: EnvFormat, query: "compute.location", expect: "export VALUE='westus2'\n"},
+		{name: "MultiSelectEnv", format: EnvFormat, query: "{location: compute.location}", expect: "export LOCATION='westus2'\n"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			var q query.Expression
+			require.NoError(t, q.Set(c.query))
+
+			var b bytes.Buffer
+			require.NoError(t, FprintQuery(&b, c.format, &q, instance))
+			assert.Equal(t, c.expect, b.String())
+		})
+	}
+}
+
+func TestFprintQueryError(t *testing.T) {
+	q, err := query.Compile("length(size)")
+	require.NoError(t, err)
+	assert.Error(t, FprintQuery(new(bytes.Buffer), JSONFormat, q, testInstance{}))
+}
+
+func TestFprintUnknownFormat(t *testing.T) {
+	assert.Error(t, Fprint(new(bytes.Buffer), OutputType("xml"), "foo"))
+}
+
+func TestOutputTypeSet(t *testing.T) {
+	var o OutputType
+	require.NoError(t, o.Set("YAML"))
+	assert.Equal(t, YAMLFormat, o)
+	assert.Error(t, o.Set("xml"))
+	assert.Equal(t, YAMLFormat, o)
+}
+
+func TestEnvName(t *testing.T) {
+	cases := map[string]string{
+		"compute.placementGroupId": "COMPUTE_PLACEMENT_GROUP_ID",
+		"access_token":             "ACCESS_TOKEN",
+		"network.interface.0.ipv4": "NETWORK_INTERFACE_0_IPV4",
+		"vmId":                     "VM_ID",
+		"0":                        "_0",
+	}
+
+	for name, expect := range cases {
+		assert.Equal(t, expect, envName(name), name)
+	}
+}
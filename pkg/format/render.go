@@ -0,0 +1,296 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// field is a flattened value and its dotted name; eg "compute.location"
+	field struct {
+		Name  string
+		Value string
+	}
+)
+
+// generic turns an object into the maps, slices and scalars of its json form, so every format uses the json field
+// names. Numbers are kept as json.Number, so large integers aren't printed as floats.
+func generic(obj interface{}) (interface{}, error) {
+	bits, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(bits))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func printYAML(writer io.Writer, obj interface{}) error {
+	v, err := generic(obj)
+	if err != nil {
+		return err
+	}
+
+	bits, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(bits)
+	return err
+}
+
+// printTable prints a list of objects as a row for each, with a column for each field, a list of scalars or lists of
+// scalars as a row for each item, and anything else as a row for each field with its name and value
+func printTable(writer io.Writer, obj interface{}, header bool) error {
+	v, err := generic(obj)
+	if err != nil {
+		return err
+	}
+
+	var columns []string
+	var rows [][]string
+	if objects, ok := objectList(v); ok {
+		columns, rows = objectRows(objects)
+	} else if items, ok := scalarRows(v); ok {
+		columns, rows = []string{"value"}, items
+		for i := 1; i < len(rows[0]); i++ {
+			columns = append(columns, fmt.Sprintf("value%d", i+1))
+		}
+	} else {
+		columns = []string{"name", "value"}
+		for _, f := range flatten("", v, nil) {
+			rows = append(rows, []string{f.Name, f.Value})
+		}
+
+		if len(rows) == 1 && rows[0][0] == "" {
+			columns, rows = []string{"value"}, [][]string{rows[0][1:]}
+		}
+	}
+
+	if !header {
+		for _, row := range rows {
+			if _, err := fmt.Fprintln(writer, strings.Join(escapeCells(row), "\t")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	headings := make([]string, len(columns))
+	for i, c := range columns {
+		headings[i] = strings.ToUpper(c)
+	}
+	_, _ = fmt.Fprintln(tw, strings.Join(headings, "\t"))
+	for _, row := range rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(escapeCells(row), "\t"))
+	}
+	return tw.Flush()
+}
+
+// printEnv prints each field as a shell export, named by its upper snake case dotted name; eg
+// export COMPUTE_LOCATION='westus2'
+func printEnv(writer io.Writer, obj interface{}) error {
+	v, err := generic(obj)
+	if err != nil {
+		return err
+	}
+
+	prefix := ""
+	switch v.(type) {
+	case []interface{}:
+		prefix = "item"
+	case map[string]interface{}:
+	default:
+		prefix = "value"
+	}
+
+	for _, f := range flatten(prefix, v, nil) {
+		if _, err := fmt.Fprintf(writer, "export %s=%s\n", envName(f.Name), shellQuote(f.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectList returns the items of a non-empty list made up only of objects
+func objectList(v interface{}) ([]map[string]interface{}, bool) {
+	items, ok := v.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, false
+	}
+
+	objects := make([]map[string]interface{}, len(items))
+	for i, item := range items {
+		if objects[i], ok = item.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return objects, true
+}
+
+// scalarRows returns a row for each item of a non-empty list made up only of scalars, or only of lists of scalars of
+// the same length; eg the result of the query "events[*].[eventId, eventType]"
+func scalarRows(v interface{}) ([][]string, bool) {
+	items, ok := v.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, false
+	}
+
+	if values, ok := scalars(items); ok {
+		rows := make([][]string, len(values))
+		for i, value := range values {
+			rows[i] = []string{value}
+		}
+		return rows, true
+	}
+
+	rows := make([][]string, len(items))
+	for i, item := range items {
+		list, ok := item.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, false
+		}
+		if rows[i], ok = scalars(list); !ok || len(rows[i]) != len(rows[0]) {
+			return nil, false
+		}
+	}
+	return rows, true
+}
+
+// objectRows flattens each object into a row, with a column for every field name found in any of them
+func objectRows(objects []map[string]interface{}) ([]string, [][]string) {
+	flattened := make([]map[string]string, len(objects))
+	seen := make(map[string]bool)
+	var columns []string
+	for i, o := range objects {
+		flattened[i] = make(map[string]string)
+		for _, f := range flatten("", o, nil) {
+			flattened[i][f.Name] = f.Value
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				columns = append(columns, f.Name)
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	rows := make([][]string, len(flattened))
+	for i, values := range flattened {
+		rows[i] = make([]string, len(columns))
+		for j, c := range columns {
+			rows[i][j] = values[c]
+		}
+	}
+	return columns, rows
+}
+
+// flatten appends the scalar fields of the value, with nested objects and lists of objects named by dotted paths.
+// Lists of scalars are joined with commas.
+func flatten(name string, v interface{}, fields []field) []field {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) == 0 {
+			return append(fields, field{Name: name})
+		}
+
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fields = flatten(join(name, k), t[k], fields)
+		}
+		return fields
+	case []interface{}:
+		if values, ok := scalars(t); ok {
+			return append(fields, field{Name: name, Value: strings.Join(values, ",")})
+		}
+
+		for i, item := range t {
+			fields = flatten(join(name, fmt.Sprint(i)), item, fields)
+		}
+		return fields
+	default:
+		return append(fields, field{Name: name, Value: scalar(v)})
+	}
+}
+
+func scalars(items []interface{}) ([]string, bool) {
+	values := make([]string, len(items))
+	for i, item := range items {
+		switch item.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, false
+		default:
+			values[i] = scalar(item)
+		}
+	}
+	return values, true
+}
+
+func scalar(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func join(name, key string) string {
+	if name == "" {
+		return key
+	}
+	return name + "." + key
+}
+
+// escapeCells keeps each row on a single line with tabs only between cells
+func escapeCells(row []string) []string {
+	escaped := make([]string, len(row))
+	for i, cell := range row {
+		escaped[i] = strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`).Replace(cell)
+	}
+	return escaped
+}
+
+// envName turns a dotted, camel case field name into an upper snake case variable name; eg
+// "compute.placementGroupId" becomes "COMPUTE_PLACEMENT_GROUP_ID"
+func envName(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])):
+			b.WriteRune('_')
+			b.WriteRune(r)
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(unicode.ToUpper(r))
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	env := b.String()
+	if env == "" || unicode.IsDigit(rune(env[0])) {
+		env = "_" + env
+	}
+	return env
+}
+
+// shellQuote single quotes a value, so the shell doesn't expand anything in it
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,310 @@
+// Package ingest provides a local stand-in for the Application Insights track endpoint. It accepts telemetry from
+// apmz or any Application Insights SDK, writes each envelope as a line of json and answers queries about what it has
+// received, so instrumented scripts can be tested offline against real wire payloads.
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/devigned/apmz/pkg/service"
+)
+
+type (
+	// Server records the telemetry posted to its track endpoint
+	Server struct {
+		// Out receives each accepted envelope as a line of json
+		Out io.Writer
+		// MaxRecords is the number of envelopes kept for queries; the oldest are discarded first
+		MaxRecords int
+
+		mu      sync.Mutex
+		records []Envelope
+	}
+
+	// Envelope is an Application Insights envelope as sent over the wire
+	Envelope struct {
+		Ver        int               `json:"ver,omitempty"`
+		Name       string            `json:"name"`
+		Time       string            `json:"time"`
+		SampleRate float64           `json:"sampleRate,omitempty"`
+		Seq        string            `json:"seq,omitempty"`
+		IKey       string            `json:"iKey"`
+		Tags       map[string]string `json:"tags,omitempty"`
+		Data       Data              `json:"data"`
+	}
+
+	// Data holds the telemetry item of an envelope
+	Data struct {
+		BaseType string          `json:"baseType"`
+		BaseData json.RawMessage `json:"baseData"`
+	}
+
+	// Response is returned from the track endpoint, as Application Insights does
+	Response struct {
+		ItemsReceived int         `json:"itemsReceived"`
+		ItemsAccepted int         `json:"itemsAccepted"`
+		Errors        []ItemError `json:"errors"`
+	}
+
+	// ItemError describes why an envelope was not accepted
+	ItemError struct {
+		Index      int    `json:"index"`
+		StatusCode int    `json:"statusCode"`
+		Message    string `json:"message"`
+	}
+
+	// Query filters the recorded envelopes
+	Query struct {
+		// Type is an apmz telemetry type, such as "trace", or a base type, such as "MessageData"
+		Type string
+		// Name matches the name, or for traces the message, of the telemetry item
+		Name string
+		// IKey matches the instrumentation key
+		IKey string
+		// OperationID matches the ai.operation.id tag
+		OperationID string
+		// Limit caps the number of envelopes returned, keeping the most recent
+		Limit int
+	}
+)
+
+const (
+	// TrackPath is where telemetry is posted
+	TrackPath = "/v2/track"
+
+	// QueryPath is where recorded telemetry is queried with GET and cleared with DELETE
+	QueryPath = "/query"
+
+	// DefaultMaxRecords is the default number of envelopes kept for queries
+	DefaultMaxRecords = 10000
+)
+
+// ServeHTTP routes requests to the track and query endpoints
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == TrackPath && r.Method == http.MethodPost:
+		s.track(w, r)
+	case r.URL.Path == QueryPath && r.Method == http.MethodGet:
+		s.query(w, r)
+	case r.URL.Path == QueryPath && r.Method == http.MethodDelete:
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Records returns the recorded envelopes which match the query, oldest first
+func (s *Server) Records(q Query) []Envelope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := make([]Envelope, 0)
+	for _, envelope := range s.records {
+		if q.matches(envelope) {
+			matches = append(matches, envelope)
+		}
+	}
+
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[len(matches)-q.Limit:]
+	}
+	return matches
+}
+
+// Reset discards the recorded envelopes
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = nil
+}
+
+func (s *Server) track(w http.ResponseWriter, r *http.Request) {
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to decompress body: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	lines, err := readLines(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to read body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	res := Response{ItemsReceived: len(lines), Errors: make([]ItemError, 0)}
+	var accepted []Envelope
+	for i, line := range lines {
+		envelope, err := parseEnvelope(line)
+		if err != nil {
+			res.Errors = append(res.Errors, ItemError{Index: i, StatusCode: http.StatusBadRequest, Message: err.Error()})
+			continue
+		}
+		accepted = append(accepted, *envelope)
+	}
+	res.ItemsAccepted = len(accepted)
+
+	if err := s.record(accepted); err != nil {
+		http.Error(w, fmt.Sprintf("unable to write envelopes: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	switch {
+	case res.ItemsAccepted == 0 && res.ItemsReceived > 0:
+		status = http.StatusBadRequest
+	case res.ItemsAccepted < res.ItemsReceived:
+		status = http.StatusPartialContent
+	}
+	writeJSON(w, status, res)
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	q := Query{
+		Type:        values.Get("type"),
+		Name:        values.Get("name"),
+		IKey:        values.Get("ikey"),
+		OperationID: values.Get("operationId"),
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	writeJSON(w, http.StatusOK, s.Records(q))
+}
+
+func (s *Server) record(envelopes []Envelope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, envelope := range envelopes {
+		if s.Out != nil {
+			bits, err := json.Marshal(envelope)
+			if err != nil {
+				return err
+			}
+			if _, err := s.Out.Write(append(bits, '\n')); err != nil {
+				return err
+			}
+		}
+		s.records = append(s.records, envelope)
+	}
+
+	max := s.MaxRecords
+	if max <= 0 {
+		max = DefaultMaxRecords
+	}
+	if over := len(s.records) - max; over > 0 {
+		s.records = append([]Envelope(nil), s.records[over:]...)
+	}
+	return nil
+}
+
+func (q Query) matches(envelope Envelope) bool {
+	if q.Type != "" {
+		kind, _ := service.DefaultTelemetryKinds.KindOfData(envelope.Data.BaseType)
+		if q.Type != string(kind.Type) && q.Type != envelope.Data.BaseType {
+			return false
+		}
+	}
+
+	if q.Name != "" {
+		var item struct {
+			Name    string `json:"name"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(envelope.Data.BaseData, &item)
+		if q.Name != item.Name && q.Name != item.Message {
+			return false
+		}
+	}
+
+	if q.IKey != "" && q.IKey != envelope.IKey {
+		return false
+	}
+
+	if q.OperationID != "" && q.OperationID != envelope.Tags["ai.operation.id"] {
+		return false
+	}
+	return true
+}
+
+// parseEnvelope decodes an envelope and checks it has what Application Insights requires
+func parseEnvelope(line []byte) (*Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(line, &envelope); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal envelope: %v", err)
+	}
+
+	switch {
+	case envelope.IKey == "":
+		return nil, fmt.Errorf("envelope must have an iKey")
+	case envelope.Name == "":
+		return nil, fmt.Errorf("envelope must have a name")
+	case envelope.Time == "":
+		return nil, fmt.Errorf("envelope must have a time")
+	case envelope.Data.BaseType == "":
+		return nil, fmt.Errorf("envelope data must have a baseType")
+	case len(envelope.Data.BaseData) == 0:
+		return nil, fmt.Errorf("envelope data must have baseData")
+	}
+	return &envelope, nil
+}
+
+// readLines reads newline delimited envelopes, or a json array of them as some SDKs send
+func readLines(reader io.Reader) ([][]byte, error) {
+	bits, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(bits); len(trimmed) > 0 && trimmed[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, err
+		}
+
+		lines := make([][]byte, len(items))
+		for i, item := range items {
+			lines[i] = item
+		}
+		return lines, nil
+	}
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(bits))
+	scanner.Buffer(make([]byte, 64*1024), len(bits)+1)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append([]byte(nil), line...))
+		}
+	}
+	return lines, scanner.Err()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
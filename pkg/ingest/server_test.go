@@ -0,0 +1,162 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/channel"
+)
+
+const (
+	traceLine = `{"name":"Microsoft.ApplicationInsights.key.Message","time":"2020-01-01T00:00:00Z","iKey":"key","tags":{"ai.operation.id":"op1"},"data":{"baseType":"MessageData","baseData":{"ver":2,"message":"hello","severityLevel":1}}}`
+	eventLine = `{"name":"Microsoft.ApplicationInsights.other.Event","time":"2020-01-01T00:00:00Z","iKey":"other","tags":{"ai.operation.id":"op2"},"data":{"baseType":"EventData","baseData":{"ver":2,"name":"deployed"}}}`
+)
+
+func TestServerTrack(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     string
+		status   int
+		accepted int
+		errors   []int
+	}{
+		{
+			name:     "NewlineDelimited",
+			body:     traceLine + "\n" + eventLine + "\n",
+			status:   http.StatusOK,
+			accepted: 2,
+		},
+		{
+			name:     "JSONArray",
+			body:     "[" + traceLine + "," + eventLine + "]",
+			status:   http.StatusOK,
+			accepted: 2,
+		},
+		{
+			name:     "PartialSuccess",
+			body:     traceLine + "\n" + `{"name":"missing key","time":"2020-01-01T00:00:00Z","data":{"baseType":"MessageData","baseData":{}}}` + "\n",
+			status:   http.StatusPartialContent,
+			accepted: 1,
+			errors:   []int{1},
+		},
+		{
+			name:   "AllInvalid",
+			body:   "not json\n",
+			status: http.StatusBadRequest,
+			errors: []int{0},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			s := &Server{Out: &out}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, TrackPath, strings.NewReader(c.body)))
+			assert.Equal(t, c.status, rec.Code)
+
+			var res Response
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, c.accepted, res.ItemsAccepted)
+			assert.Equal(t, c.accepted+len(c.errors), res.ItemsReceived)
+			require.Len(t, res.Errors, len(c.errors))
+			for i, index := range c.errors {
+				assert.Equal(t, index, res.Errors[i].Index)
+				assert.Equal(t, http.StatusBadRequest, res.Errors[i].StatusCode)
+			}
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if c.accepted == 0 {
+				lines = nil
+			}
+			assert.Len(t, lines, c.accepted)
+			assert.Len(t, s.Records(Query{}), c.accepted)
+		})
+	}
+}
+
+func TestServerQuery(t *testing.T) {
+	s := &Server{}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, TrackPath, strings.NewReader(traceLine+"\n"+eventLine+"\n"+traceLine+"\n")))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	cases := []struct {
+		name  string
+		query string
+		count int
+	}{
+		{name: "All", query: "", count: 3},
+		{name: "ByTypeName", query: "type=trace", count: 2},
+		{name: "ByBaseType", query: "type=EventData", count: 1},
+		{name: "ByMessage", query: "name=hello", count: 2},
+		{name: "ByName", query: "name=deployed", count: 1},
+		{name: "ByIKey", query: "ikey=other", count: 1},
+		{name: "ByOperationID", query: "operationId=op1", count: 2},
+		{name: "Limit", query: "limit=1", count: 1},
+		{name: "NoMatch", query: "type=metric", count: 0},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, QueryPath+"?"+c.query, nil))
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			var envelopes []Envelope
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &envelopes))
+			assert.Len(t, envelopes, c.count)
+		})
+	}
+}
+
+func TestServerMaxRecordsAndReset(t *testing.T) {
+	s := &Server{MaxRecords: 2}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, TrackPath, strings.NewReader(traceLine+"\n"+traceLine+"\n"+eventLine+"\n")))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	records := s.Records(Query{})
+	require.Len(t, records, 2)
+	assert.Equal(t, "EventData", records[1].Data.BaseType)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, QueryPath, nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, s.Records(Query{}))
+}
+
+func TestServerReceivesFromChannel(t *testing.T) {
+	s := &Server{}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	ch, err := channel.New(srv.URL + TrackPath)
+	require.NoError(t, err)
+
+	client := apmz.NewTelemetryClient("key")
+	client.Channel().Stop()
+	client.SetChannel(ch)
+	client.Context().Tags.Operation().SetId("op1")
+	client.TrackTrace("hello", contracts.Warning)
+	client.TrackEvent("deployed")
+	<-ch.Close()
+
+	assert.Equal(t, 2, ch.Report().Accepted)
+	records := s.Records(Query{Type: "trace", OperationID: "op1"})
+	require.Len(t, records, 1)
+	assert.Equal(t, "key", records[0].IKey)
+}
@@ -0,0 +1,438 @@
+// Package otlp provides an apmz destination which exports telemetry to an OpenTelemetry collector, or any other
+// receiver of the OpenTelemetry protocol, over OTLP/HTTP with json encoding.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+type (
+	// Exporter is a service.APMer which buffers telemetry and exports it to an OTLP/HTTP endpoint
+	Exporter struct {
+		endpoint  string
+		headers   map[string]string
+		tags      map[string]string
+		batchSize int
+		client    *http.Client
+
+		mu          sync.Mutex
+		buffers     map[signal][]record
+		pending     map[signal][]record
+		retryAt     time.Time
+		closed      bool
+		report      channel.Report
+		lastFailure string
+	}
+
+	// Option is a variadic optional configuration func
+	Option func(e *Exporter) error
+
+	// signal is a kind of OpenTelemetry data, each of which is exported to its own path
+	signal string
+
+	// exportResult is the outcome of exporting a single batch
+	exportResult struct {
+		StatusCode int
+		RetryAfter *time.Time
+		Rejected   int
+		Message    string
+	}
+)
+
+const (
+	logs    signal = "logs"
+	metrics signal = "metrics"
+	traces  signal = "traces"
+
+	defaultBatchSize = 512
+)
+
+var (
+	_ service.APMer = (*Exporter)(nil)
+
+	signals = []signal{logs, metrics, traces}
+)
+
+// New creates a new exporter which sends to the OTLP/HTTP endpoint, such as http://localhost:4318. Each signal is
+// posted to its path under the endpoint, such as /v1/logs.
+func New(endpoint string, opts ...Option) (*Exporter, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("OTLP endpoint %q must be an http or https url", endpoint)
+	}
+
+	e := &Exporter{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		batchSize: defaultBatchSize,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		buffers:   make(map[signal][]record),
+		pending:   make(map[signal][]record),
+	}
+
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// WithHeaders sets headers sent with every export, such as those a collector requires for authentication
+func WithHeaders(headers map[string]string) Option {
+	return func(e *Exporter) error {
+		e.headers = headers
+		return nil
+	}
+}
+
+// WithTags sets context tags, such as the operation id, applied to every item which doesn't set them itself
+func WithTags(tags map[string]string) Option {
+	return func(e *Exporter) error {
+		e.tags = tags
+		return nil
+	}
+}
+
+// WithBatchSize sets the number of items of a signal sent in a single export
+func WithBatchSize(size int) Option {
+	return func(e *Exporter) error {
+		if size < 1 {
+			return fmt.Errorf("batch size must be greater than 0")
+		}
+		e.batchSize = size
+		return nil
+	}
+}
+
+// WithHTTPClient sets the http client used to export
+func WithHTTPClient(client *http.Client) Option {
+	return func(e *Exporter) error {
+		e.client = client
+		return nil
+	}
+}
+
+// Track converts the item to OTLP and buffers it, exporting the buffer once it reaches the batch size
+func (e *Exporter) Track(item apmz.Telemetry) {
+	r, err := convert(item, e.tags)
+
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return
+	}
+
+	e.report.Sent++
+	if err != nil {
+		e.report.Dropped++
+		e.report.AddReason(err.Error())
+		e.mu.Unlock()
+		return
+	}
+
+	sig := r.signal()
+	e.buffers[sig] = append(e.buffers[sig], *r)
+	if len(e.buffers[sig]) < e.batchSize {
+		e.mu.Unlock()
+		return
+	}
+
+	batch := e.buffers[sig]
+	e.buffers[sig] = nil
+	throttled := time.Now().Before(e.retryAt)
+	e.mu.Unlock()
+
+	if throttled {
+		e.retain(sig, batch)
+		return
+	}
+	e.retain(sig, e.export(context.Background(), sig, batch))
+}
+
+// Close exports the buffered telemetry and any which previously failed. Failed exports are retried with backoff until
+// the context's deadline; without a deadline, each export is tried once. Whatever could not be exported is dropped.
+func (e *Exporter) Close(ctx context.Context) []service.DeliveryResult {
+	e.mu.Lock()
+	e.closed = true
+	remaining := make(map[signal][]record)
+	for _, sig := range signals {
+		e.report.Retried += len(e.pending[sig])
+		remaining[sig] = append(e.pending[sig], e.buffers[sig]...)
+	}
+	e.pending = make(map[signal][]record)
+	e.buffers = make(map[signal][]record)
+	e.mu.Unlock()
+
+	channel.Retry(ctx, e.notBefore, func(retrying bool) bool {
+		if retrying {
+			e.mu.Lock()
+			for _, sig := range signals {
+				e.report.Retried += len(remaining[sig])
+			}
+			e.mu.Unlock()
+		}
+
+		left := 0
+		for _, sig := range signals {
+			var retry []record
+			for start := 0; start < len(remaining[sig]); start += e.batchSize {
+				end := start + e.batchSize
+				if end > len(remaining[sig]) {
+					end = len(remaining[sig])
+				}
+				retry = append(retry, e.export(ctx, sig, remaining[sig][start:end])...)
+			}
+			remaining[sig] = retry
+			left += len(retry)
+		}
+		return left > 0
+	})
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, sig := range signals {
+		if n := len(remaining[sig]); n > 0 {
+			e.report.Dropped += n
+			e.report.AddReason(e.lastFailure)
+		}
+	}
+
+	report := e.report
+	report.Reasons = append([]string(nil), e.report.Reasons...)
+	return []service.DeliveryResult{{Key: e.endpoint, Report: report}}
+}
+
+// export sends a batch of a single signal and returns the items which should be retried
+func (e *Exporter) export(ctx context.Context, sig signal, batch []record) []record {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	res, err := e.post(ctx, sig, batch)
+	if err != nil {
+		e.failed(nil, fmt.Sprintf("export failed: %v", err))
+		return batch
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		rejected := res.Rejected
+		if rejected > len(batch) {
+			rejected = len(batch)
+		}
+		e.report.Accepted += len(batch) - rejected
+		if rejected > 0 {
+			e.report.Rejected += rejected
+			e.report.AddReason(fmt.Sprintf("%s partially rejected: %s", sig, res.Message))
+		}
+		e.retryAt = time.Time{}
+		return nil
+	}
+
+	reason := fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	if res.Message != "" {
+		reason = fmt.Sprintf("%s: %s", reason, res.Message)
+	}
+
+	if res.RetryAfter != nil || canRetry(res.StatusCode) {
+		e.failed(res, fmt.Sprintf("export failed: %s", reason))
+		return batch
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.report.Rejected += len(batch)
+	e.report.AddReason(reason)
+	return nil
+}
+
+// post encodes the batch as an OTLP export request and posts it to the signal's path
+func (e *Exporter) post(ctx context.Context, sig signal, batch []record) (*exportResult, error) {
+	bits, err := json.Marshal(newExportRequest(sig, batch))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint+"/v1/"+string(sig), bytes.NewReader(bits))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &exportResult{StatusCode: res.StatusCode}
+	if retryAfter := res.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			at := time.Now().Add(time.Duration(seconds) * time.Second)
+			result.RetryAfter = &at
+		} else if at, err := http.ParseTime(retryAfter); err == nil {
+			result.RetryAfter = &at
+		}
+	}
+
+	var response struct {
+		// PartialSuccess is set on a successful export which rejected some items
+		PartialSuccess struct {
+			RejectedLogRecords json.RawMessage `json:"rejectedLogRecords"`
+			RejectedDataPoints json.RawMessage `json:"rejectedDataPoints"`
+			RejectedSpans      json.RawMessage `json:"rejectedSpans"`
+			ErrorMessage       string          `json:"errorMessage"`
+		} `json:"partialSuccess"`
+		// Message is set on a failed export
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil {
+		partial := response.PartialSuccess
+		for _, n := range []json.RawMessage{partial.RejectedLogRecords, partial.RejectedDataPoints, partial.RejectedSpans} {
+			if rejected, err := strconv.Atoi(strings.Trim(string(n), `"`)); err == nil {
+				result.Rejected += rejected
+			}
+		}
+		result.Message = partial.ErrorMessage
+		if response.Message != "" {
+			result.Message = response.Message
+		}
+	}
+
+	return result, nil
+}
+
+// retain keeps items for another attempt when the exporter is closed. At most channel.MaxPendingBatches batches of a
+// signal are kept, so a long outage doesn't grow memory without bound, and the rest are dropped.
+func (e *Exporter) retain(sig signal, batch []record) {
+	if len(batch) == 0 {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	keep := channel.Retainable(len(e.pending[sig]), len(batch), e.batchSize)
+	e.pending[sig] = append(e.pending[sig], batch[:keep]...)
+	if dropped := len(batch) - keep; dropped > 0 {
+		e.report.Dropped += dropped
+		e.report.AddReason("retry buffer full: " + e.lastFailure)
+	}
+}
+
+func (e *Exporter) failed(res *exportResult, reason string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastFailure = reason
+	e.retryAt = time.Now().Add(channel.MinBackoff)
+	if res != nil && res.RetryAfter != nil && res.RetryAfter.After(e.retryAt) {
+		e.retryAt = *res.RetryAfter
+	}
+}
+
+// notBefore returns when the endpoint asked for the next attempt to be made, if it did
+func (e *Exporter) notBefore() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.retryAt
+}
+
+func (r record) signal() signal {
+	switch {
+	case r.Metric != nil:
+		return metrics
+	case r.Span != nil:
+		return traces
+	default:
+		return logs
+	}
+}
+
+// newExportRequest groups the records of a signal by resource into an OTLP export request
+func newExportRequest(sig signal, batch []record) interface{} {
+	var order []resourceKey
+	groups := make(map[resourceKey][]record)
+	for _, r := range batch {
+		if _, ok := groups[r.Resource]; !ok {
+			order = append(order, r.Resource)
+		}
+		groups[r.Resource] = append(groups[r.Resource], r)
+	}
+
+	switch sig {
+	case metrics:
+		req := metricsRequest{}
+		for _, rk := range order {
+			var ms []metric
+			for _, r := range groups[rk] {
+				ms = append(ms, *r.Metric)
+			}
+			req.ResourceMetrics = append(req.ResourceMetrics, resourceMetrics{
+				Resource:     resource{Attributes: rk.attributes()},
+				ScopeMetrics: []scopeMetrics{{Scope: scope{Name: scopeName}, Metrics: ms}},
+			})
+		}
+		return req
+	case traces:
+		req := tracesRequest{}
+		for _, rk := range order {
+			var spans []span
+			for _, r := range groups[rk] {
+				spans = append(spans, *r.Span)
+			}
+			req.ResourceSpans = append(req.ResourceSpans, resourceSpans{
+				Resource:   resource{Attributes: rk.attributes()},
+				ScopeSpans: []scopeSpans{{Scope: scope{Name: scopeName}, Spans: spans}},
+			})
+		}
+		return req
+	default:
+		req := logsRequest{}
+		for _, rk := range order {
+			var records []logRecord
+			for _, r := range groups[rk] {
+				records = append(records, *r.Log)
+			}
+			req.ResourceLogs = append(req.ResourceLogs, resourceLogs{
+				Resource:  resource{Attributes: rk.attributes()},
+				ScopeLogs: []scopeLogs{{Scope: scope{Name: scopeName}, LogRecords: records}},
+			})
+		}
+		return req
+	}
+}
+
+// canRetry is true for the status codes the OTLP/HTTP specification says may succeed if sent again
+func canRetry(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
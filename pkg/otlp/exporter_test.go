@@ -0,0 +1,205 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+type collector struct {
+	mu       sync.Mutex
+	payloads map[string][]map[string]interface{}
+	headers  http.Header
+}
+
+func newCollector() *collector {
+	return &collector{payloads: make(map[string][]map[string]interface{})}
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bits, _ := ioutil.ReadAll(r.Body)
+	var payload map[string]interface{}
+	_ = json.Unmarshal(bits, &payload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payloads[r.URL.Path] = append(c.payloads[r.URL.Path], payload)
+	c.headers = r.Header
+	_, _ = w.Write([]byte(`{}`))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("localhost:4318")
+	assert.Error(t, err)
+
+	e, err := New("http://localhost:4318/")
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:4318", e.endpoint)
+}
+
+func TestExporterClose(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		report channel.Report
+	}{
+		{
+			name:   "Accepted",
+			status: http.StatusOK,
+			body:   `{}`,
+			report: channel.Report{Sent: 2, Accepted: 2},
+		},
+		{
+			name:   "PartialSuccess",
+			status: http.StatusOK,
+			body:   `{"partialSuccess":{"rejectedLogRecords":"1","errorMessage":"body too large"}}`,
+			report: channel.Report{Sent: 2, Accepted: 1, Rejected: 1, Reasons: []string{"logs partially rejected: body too large"}},
+		},
+		{
+			name:   "BadRequest",
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"message":"invalid payload"}`,
+			report: channel.Report{Sent: 2, Rejected: 2, Reasons: []string{"400 Bad Request: invalid payload"}},
+		},
+		{
+			name:   "Unavailable",
+			status: http.StatusServiceUnavailable,
+			report: channel.Report{Sent: 2, Dropped: 2, Reasons: []string{"export failed: 503 Service Unavailable"}},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(c.status)
+				_, _ = w.Write([]byte(c.body))
+			}))
+			defer srv.Close()
+
+			e, err := New(srv.URL)
+			require.NoError(t, err)
+			e.Track(apmz.NewTraceTelemetry("foo", contracts.Information))
+			e.Track(apmz.NewTraceTelemetry("bar", contracts.Error))
+			results := e.Close(context.Background())
+			assert.Equal(t, []service.DeliveryResult{{Key: srv.URL, Report: c.report}}, results)
+		})
+	}
+}
+
+func TestExporterCloseRetriesUntilDeadline(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	e, err := New(srv.URL)
+	require.NoError(t, err)
+	e.Track(apmz.NewTraceTelemetry("foo", contracts.Information))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	results := e.Close(ctx)
+	require.Len(t, results, 1)
+	assert.Equal(t, channel.Report{Sent: 1, Accepted: 1, Retried: 1}, results[0].Report)
+}
+
+func TestExporterCapsPending(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	e, err := New(srv.URL, WithBatchSize(1))
+	require.NoError(t, err)
+	for i := 0; i < channel.MaxPendingBatches+2; i++ {
+		e.Track(apmz.NewTraceTelemetry("foo", contracts.Information))
+	}
+
+	assert.Equal(t, 2, e.report.Dropped)
+	assert.Equal(t, []string{"retry buffer full: export failed: 503 Service Unavailable"}, e.report.Reasons)
+	assert.Len(t, e.pending[logs], channel.MaxPendingBatches)
+}
+
+func TestExporterSendsSignals(t *testing.T) {
+	c := newCollector()
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	e, err := New(srv.URL,
+		WithHeaders(map[string]string{"api-key": "secret"}),
+		WithTags(map[string]string{contracts.CloudRole: "myscript", contracts.OperationId: "140a0cea-bcd0-19d6-3cb2-3b37a58dcb8c"}))
+	require.NoError(t, err)
+
+	trace := apmz.NewTraceTelemetry("hello", contracts.Warning)
+	trace.Properties["foo"] = "bar"
+	e.Track(trace)
+	e.Track(apmz.NewMetricTelemetry("duration", 1.5))
+	req := apmz.NewRequestTelemetry("GET", "http://foo", time.Second, "500")
+	req.ID = "0123456789abcdef"
+	e.Track(req)
+	dep := apmz.NewRemoteDependencyTelemetry("db", "sql", "server", true)
+	dep.Tags.Operation().SetParentId("0123456789abcdef")
+	e.Track(dep)
+
+	results := e.Close(context.Background())
+	require.Len(t, results, 1)
+	assert.Equal(t, channel.Report{Sent: 4, Accepted: 4}, results[0].Report)
+	assert.Equal(t, "secret", c.headers.Get("api-key"))
+	assert.Equal(t, "application/json", c.headers.Get("Content-Type"))
+
+	require.Len(t, c.payloads["/v1/logs"], 1)
+	logs := c.payloads["/v1/logs"][0]["resourceLogs"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "myscript"}},
+	}, logs["resource"].(map[string]interface{})["attributes"])
+	log := logs["scopeLogs"].([]interface{})[0].(map[string]interface{})["logRecords"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "WARN", log["severityText"])
+	assert.Equal(t, map[string]interface{}{"stringValue": "hello"}, log["body"])
+	assert.Equal(t, "140a0ceabcd019d63cb23b37a58dcb8c", log["traceId"])
+
+	require.Len(t, c.payloads["/v1/metrics"], 1)
+	metric := c.payloads["/v1/metrics"][0]["resourceMetrics"].([]interface{})[0].(map[string]interface{})["scopeMetrics"].([]interface{})[0].(map[string]interface{})["metrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "duration", metric["name"])
+	assert.Equal(t, 1.5, metric["gauge"].(map[string]interface{})["dataPoints"].([]interface{})[0].(map[string]interface{})["asDouble"])
+
+	require.Len(t, c.payloads["/v1/traces"], 1)
+	spans := c.payloads["/v1/traces"][0]["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	require.Len(t, spans, 2)
+	server, client := spans[0].(map[string]interface{}), spans[1].(map[string]interface{})
+	assert.Equal(t, float64(spanKindServer), server["kind"])
+	assert.Equal(t, "0123456789abcdef", server["spanId"])
+	assert.Equal(t, map[string]interface{}{"code": float64(statusCodeError)}, server["status"])
+	assert.Equal(t, float64(spanKindClient), client["kind"])
+	assert.Equal(t, "0123456789abcdef", client["parentSpanId"])
+	assert.Equal(t, server["traceId"], client["traceId"])
+}
+
+func TestIDs(t *testing.T) {
+	assert.Equal(t, "140a0ceabcd019d63cb23b37a58dcb8c", traceID("140A0CEA-BCD0-19D6-3CB2-3B37A58DCB8C"))
+	assert.Len(t, traceID("not a uuid"), 32)
+	assert.Equal(t, traceID("not a uuid"), traceID("not a uuid"))
+	assert.Equal(t, "0123456789abcdef", spanID("0123456789ABCDEF"))
+	assert.Len(t, spanID("140a0cea-bcd0-19d6-3cb2-3b37a58dcb8c"), 16)
+}
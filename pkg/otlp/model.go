@@ -0,0 +1,403 @@
+package otlp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/google/uuid"
+)
+
+// The types below are the OTLP/HTTP json encoding of the OpenTelemetry protocol; only the fields apmz sets are
+// included. 64 bit integers are encoded as strings, and trace and span ids as hex, as the protocol requires.
+type (
+	logsRequest struct {
+		ResourceLogs []resourceLogs `json:"resourceLogs"`
+	}
+
+	resourceLogs struct {
+		Resource  resource    `json:"resource"`
+		ScopeLogs []scopeLogs `json:"scopeLogs"`
+	}
+
+	scopeLogs struct {
+		Scope      scope       `json:"scope"`
+		LogRecords []logRecord `json:"logRecords"`
+	}
+
+	logRecord struct {
+		TimeUnixNano         string      `json:"timeUnixNano"`
+		ObservedTimeUnixNano string      `json:"observedTimeUnixNano"`
+		SeverityNumber       int         `json:"severityNumber"`
+		SeverityText         string      `json:"severityText"`
+		Body                 anyValue    `json:"body"`
+		Attributes           []attribute `json:"attributes,omitempty"`
+		TraceID              string      `json:"traceId,omitempty"`
+		SpanID               string      `json:"spanId,omitempty"`
+	}
+
+	metricsRequest struct {
+		ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+	}
+
+	resourceMetrics struct {
+		Resource     resource       `json:"resource"`
+		ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+	}
+
+	scopeMetrics struct {
+		Scope   scope    `json:"scope"`
+		Metrics []metric `json:"metrics"`
+	}
+
+	metric struct {
+		Name  string `json:"name"`
+		Gauge gauge  `json:"gauge"`
+	}
+
+	gauge struct {
+		DataPoints []dataPoint `json:"dataPoints"`
+	}
+
+	dataPoint struct {
+		TimeUnixNano string      `json:"timeUnixNano"`
+		AsDouble     float64     `json:"asDouble"`
+		Attributes   []attribute `json:"attributes,omitempty"`
+	}
+
+	tracesRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+
+	scopeSpans struct {
+		Scope scope  `json:"scope"`
+		Spans []span `json:"spans"`
+	}
+
+	span struct {
+		TraceID           string      `json:"traceId"`
+		SpanID            string      `json:"spanId"`
+		ParentSpanID      string      `json:"parentSpanId,omitempty"`
+		Name              string      `json:"name"`
+		Kind              int         `json:"kind"`
+		StartTimeUnixNano string      `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+		Attributes        []attribute `json:"attributes,omitempty"`
+		Status            status      `json:"status"`
+	}
+
+	status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+
+	resource struct {
+		Attributes []attribute `json:"attributes"`
+	}
+
+	scope struct {
+		Name string `json:"name"`
+	}
+
+	attribute struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+
+	anyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+
+	// record is a telemetry item converted to a single OTLP log record, gauge data point or span
+	record struct {
+		Resource resourceKey
+		Log      *logRecord
+		Metric   *metric
+		Span     *span
+	}
+
+	// resourceKey identifies the OTLP resource an item belongs to, from its cloud role tags
+	resourceKey struct {
+		ServiceName       string
+		ServiceInstanceID string
+	}
+)
+
+const (
+	scopeName          = "apmz"
+	defaultServiceName = "apmz"
+
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+
+	statusCodeOk    = 1
+	statusCodeError = 2
+)
+
+// convert maps an Application Insights telemetry item onto OTLP. Traces, events and exceptions become log records,
+// metrics become gauges, and requests, dependencies and availability results become spans. The item's own context
+// tags take precedence over the defaults.
+func convert(item apmz.Telemetry, defaults map[string]string) (*record, error) {
+	tags := make(contracts.ContextTags)
+	for k, v := range defaults {
+		tags[k] = v
+	}
+	for k, v := range item.ContextTags() {
+		tags[k] = v
+	}
+
+	r := &record{
+		Resource: resourceKey{
+			ServiceName:       tags.Cloud().GetRole(),
+			ServiceInstanceID: tags.Cloud().GetRoleInstance(),
+		},
+	}
+	if r.Resource.ServiceName == "" {
+		r.Resource.ServiceName = defaultServiceName
+	}
+
+	timestamp := item.Time()
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	attrs := attributes(item.GetProperties(), item.GetMeasurements())
+	if name := tags.Operation().GetName(); name != "" {
+		attrs = append(attrs, stringAttribute("apmz.operation.name", name))
+	}
+
+	switch t := item.(type) {
+	case *apmz.TraceTelemetry:
+		r.Log = newLogRecord(timestamp, t.SeverityLevel, t.Message, attrs, tags)
+	case *apmz.EventTelemetry:
+		attrs = append(attrs, stringAttribute("event.name", t.Name))
+		r.Log = newLogRecord(timestamp, contracts.Information, t.Name, attrs, tags)
+	case *apmz.ExceptionTelemetry:
+		data := t.TelemetryData().(*contracts.ExceptionData)
+		message := ""
+		if len(data.Exceptions) > 0 {
+			details := data.Exceptions[0]
+			message = details.Message
+			attrs = append(attrs,
+				stringAttribute("exception.type", details.TypeName),
+				stringAttribute("exception.message", details.Message),
+				stringAttribute("exception.stacktrace", stacktrace(details.ParsedStack)))
+		}
+		r.Log = newLogRecord(timestamp, t.SeverityLevel, message, attrs, tags)
+	case *apmz.MetricTelemetry:
+		r.Metric = newGauge(timestamp, t.Name, t.Value, attrs)
+	case *apmz.AggregateMetricTelemetry:
+		r.Metric = newGauge(timestamp, t.Name, t.Value, attrs)
+	case *apmz.RequestTelemetry:
+		attrs = appendNonEmpty(attrs, "url.full", t.URL)
+		attrs = appendNonEmpty(attrs, "apmz.response_code", t.ResponseCode)
+		attrs = appendNonEmpty(attrs, "apmz.source", t.Source)
+		r.Span = newSpan(timestamp, t.Duration, t.ID, t.Name, spanKindServer, t.Success, attrs, tags)
+	case *apmz.RemoteDependencyTelemetry:
+		attrs = appendNonEmpty(attrs, "apmz.dependency.type", t.Type)
+		attrs = appendNonEmpty(attrs, "apmz.dependency.target", t.Target)
+		attrs = appendNonEmpty(attrs, "apmz.dependency.data", t.Data)
+		attrs = appendNonEmpty(attrs, "apmz.result_code", t.ResultCode)
+		r.Span = newSpan(timestamp, t.Duration, t.ID, t.Name, spanKindClient, t.Success, attrs, tags)
+	case *apmz.AvailabilityTelemetry:
+		attrs = appendNonEmpty(attrs, "apmz.run_location", t.RunLocation)
+		attrs = appendNonEmpty(attrs, "apmz.message", t.Message)
+		r.Span = newSpan(timestamp, t.Duration, t.ID, t.Name, spanKindInternal, t.Success, attrs, tags)
+	default:
+		return nil, fmt.Errorf("unable to convert telemetry of type %T to OTLP", item)
+	}
+
+	return r, nil
+}
+
+func newLogRecord(timestamp time.Time, level contracts.SeverityLevel, body string, attrs []attribute, tags contracts.ContextTags) *logRecord {
+	number, text := severity(level)
+	log := &logRecord{
+		TimeUnixNano:         unixNano(timestamp),
+		ObservedTimeUnixNano: unixNano(time.Now()),
+		SeverityNumber:       number,
+		SeverityText:         text,
+		Body:                 stringValue(body),
+		Attributes:           attrs,
+	}
+
+	if operationID := tags.Operation().GetId(); operationID != "" {
+		log.TraceID = traceID(operationID)
+	}
+	if parentID := tags.Operation().GetParentId(); parentID != "" {
+		log.SpanID = spanID(parentID)
+	}
+	return log
+}
+
+func newGauge(timestamp time.Time, name string, value float64, attrs []attribute) *metric {
+	return &metric{
+		Name: name,
+		Gauge: gauge{
+			DataPoints: []dataPoint{
+				{
+					TimeUnixNano: unixNano(timestamp),
+					AsDouble:     value,
+					Attributes:   attrs,
+				},
+			},
+		},
+	}
+}
+
+func newSpan(start time.Time, duration time.Duration, id, name string, kind int, success bool, attrs []attribute, tags contracts.ContextTags) *span {
+	s := &span{
+		Name:              name,
+		Kind:              kind,
+		StartTimeUnixNano: unixNano(start),
+		EndTimeUnixNano:   unixNano(start.Add(duration)),
+		Attributes:        attrs,
+		Status:            status{Code: statusCodeOk},
+	}
+
+	if !success {
+		s.Status.Code = statusCodeError
+	}
+
+	if operationID := tags.Operation().GetId(); operationID != "" {
+		s.TraceID = traceID(operationID)
+	} else {
+		s.TraceID = randomID(16)
+	}
+
+	if id != "" {
+		s.SpanID = spanID(id)
+	} else {
+		s.SpanID = randomID(8)
+	}
+
+	if parentID := tags.Operation().GetParentId(); parentID != "" {
+		s.ParentSpanID = spanID(parentID)
+	}
+	return s
+}
+
+// severity maps an Application Insights severity level onto an OTLP severity number and text
+func severity(level contracts.SeverityLevel) (int, string) {
+	switch level {
+	case contracts.Verbose:
+		return 5, "DEBUG"
+	case contracts.Warning:
+		return 13, "WARN"
+	case contracts.Error:
+		return 17, "ERROR"
+	case contracts.Critical:
+		return 21, "FATAL"
+	default:
+		return 9, "INFO"
+	}
+}
+
+// traceID uses the operation id as the trace id if it is already one, as a uuid is once its dashes are removed, and
+// otherwise derives one from it, so all the items of an operation share a trace
+func traceID(operationID string) string {
+	if id := strings.ToLower(strings.Replace(operationID, "-", "", -1)); isHex(id, 32) {
+		return id
+	}
+	return hashID(operationID, 16)
+}
+
+// spanID uses the id as the span id if it is already one, and otherwise derives one from it, so a parent id refers to
+// the span created for the item with that id
+func spanID(id string) string {
+	if lower := strings.ToLower(id); isHex(lower, 16) {
+		return lower
+	}
+	return hashID(id, 8)
+}
+
+func hashID(id string, size int) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:size])
+}
+
+func randomID(size int) string {
+	id := uuid.New()
+	return hex.EncodeToString(id[:size])
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func stacktrace(frames []*contracts.StackFrame) string {
+	lines := make([]string, len(frames))
+	for i, frame := range frames {
+		lines[i] = fmt.Sprintf("at %s (%s:%d)", frame.Method, frame.FileName, frame.Line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// attributes converts properties and measurements into attributes, sorted by key so payloads are stable
+func attributes(properties map[string]string, measurements map[string]float64) []attribute {
+	var attrs []attribute
+	for _, k := range sortedKeys(properties) {
+		attrs = append(attrs, stringAttribute(k, properties[k]))
+	}
+
+	keys := make([]string, 0, len(measurements))
+	for k := range measurements {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := measurements[k]
+		attrs = append(attrs, attribute{Key: k, Value: anyValue{DoubleValue: &v}})
+	}
+	return attrs
+}
+
+func (rk resourceKey) attributes() []attribute {
+	attrs := []attribute{stringAttribute("service.name", rk.ServiceName)}
+	return appendNonEmpty(attrs, "service.instance.id", rk.ServiceInstanceID)
+}
+
+func appendNonEmpty(attrs []attribute, key, value string) []attribute {
+	if value == "" {
+		return attrs
+	}
+	return append(attrs, stringAttribute(key, value))
+}
+
+func stringAttribute(key, value string) attribute {
+	return attribute{Key: key, Value: stringValue(value)}
+}
+
+func stringValue(s string) anyValue {
+	return anyValue{StringValue: &s}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
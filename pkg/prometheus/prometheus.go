@@ -0,0 +1,226 @@
+// Package prometheus provides apmz destinations which expose metric telemetry to Prometheus, either through a
+// node_exporter textfile collector directory or by pushing to a Pushgateway.
+package prometheus
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+)
+
+type (
+	// sample is a single gauge value in the Prometheus text exposition format
+	sample struct {
+		Name   string
+		Labels map[string]string
+		Value  float64
+	}
+
+	// Option is a variadic optional configuration func for the textfile and Pushgateway sinks
+	Option func(o *options) error
+
+	options struct {
+		excluded []string
+		fileName string
+		client   *http.Client
+		tags     map[string]string
+	}
+)
+
+const (
+	defaultJob = "apmz"
+)
+
+// DefaultExcludedLabels are tags which are not turned into labels, because a value which changes every run, such as
+// the correlation id the bash helpers add, would create a new series each time
+var DefaultExcludedLabels = []string{"correlation_id"}
+
+// WithExcludedLabels sets the tags which are not turned into labels, replacing DefaultExcludedLabels
+func WithExcludedLabels(labels ...string) Option {
+	return func(o *options) error {
+		o.excluded = labels
+		return nil
+	}
+}
+
+// WithTags sets context tags, such as the cloud role, applied to every item which doesn't set them itself
+func WithTags(tags map[string]string) Option {
+	return func(o *options) error {
+		o.tags = tags
+		return nil
+	}
+}
+
+// WithHTTPClient sets the http client used to push
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) error {
+		o.client = client
+		return nil
+	}
+}
+
+// newSample converts metric telemetry into a sample, with its tags as labels; other telemetry is ignored
+func newSample(item apmz.Telemetry, excluded []string) (*sample, bool) {
+	var name string
+	var value float64
+	switch t := item.(type) {
+	case *apmz.MetricTelemetry:
+		name, value = t.Name, t.Value
+	case *apmz.AggregateMetricTelemetry:
+		name, value = t.Name, t.Value
+	default:
+		return nil, false
+	}
+
+	labels := make(map[string]string)
+	for k, v := range item.GetProperties() {
+		if !contains(excluded, k) {
+			labels[sanitize(k, false)] = v
+		}
+	}
+
+	return &sample{Name: sanitize(name, true), Labels: labels, Value: value}, true
+}
+
+// group returns the job and instance a sample is pushed under, from the cloud role tags; the item's own tags take
+// precedence over the defaults
+func group(item apmz.Telemetry, defaults map[string]string) (string, string) {
+	tags := make(contracts.ContextTags)
+	for k, v := range defaults {
+		tags[k] = v
+	}
+	for k, v := range item.ContextTags() {
+		tags[k] = v
+	}
+
+	job := tags.Cloud().GetRole()
+	if job == "" {
+		job = defaultJob
+	}
+	return job, tags.Cloud().GetRoleInstance()
+}
+
+// Series returns the sample's name and labels as they appear in the exposition format, identifying its series
+func (s sample) Series() string {
+	if len(s.Labels) == 0 {
+		return s.Name
+	}
+
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", k, escapeLabelValue(s.Labels[k]))
+	}
+	return fmt.Sprintf("%s{%s}", s.Name, strings.Join(pairs, ","))
+}
+
+// format writes series values in the text exposition format, grouped by metric name with a TYPE line for each
+func format(values map[string]string) string {
+	byName := make(map[string][]string)
+	for series := range values {
+		name := series
+		if i := strings.IndexByte(series, '{'); i >= 0 {
+			name = series[:i]
+		}
+		byName[name] = append(byName[name], series)
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+		series := byName[name]
+		sort.Strings(series)
+		for _, s := range series {
+			fmt.Fprintf(&b, "%s %s\n", s, values[s])
+		}
+	}
+	return b.String()
+}
+
+// parse reads series values from the text exposition format as written by format
+func parse(text string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if i := strings.LastIndexByte(line, ' '); i > 0 {
+			values[line[:i]] = line[i+1:]
+		}
+	}
+	return values
+}
+
+func newOptions(opts ...Option) (*options, error) {
+	o := &options{
+		excluded: DefaultExcludedLabels,
+		fileName: DefaultFileName,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sanitize replaces the characters which aren't allowed in a metric or label name with underscores
+func sanitize(name string, metric bool) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', metric && r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitize(t *testing.T) {
+	cases := []struct {
+		name     string
+		metric   bool
+		expected string
+	}{
+		{name: "myscript-duration", metric: true, expected: "myscript_duration"},
+		{name: "ns:requests_total", metric: true, expected: "ns:requests_total"},
+		{name: "ns:label", expected: "ns_label"},
+		{name: "azure.vmId", expected: "azure_vmId"},
+		{name: "1st", metric: true, expected: "_1st"},
+		{name: "", metric: true, expected: "_"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, c.expected, sanitize(c.name, c.metric))
+		})
+	}
+}
+
+func TestNewSample(t *testing.T) {
+	metric := apmz.NewMetricTelemetry("script-duration", 1.5)
+	metric.Properties["stage"] = `say "hi"`
+	metric.Properties["correlation_id"] = "run"
+	s, ok := newSample(metric, DefaultExcludedLabels)
+	assert.True(t, ok)
+	assert.Equal(t, `script_duration{stage="say \"hi\""}`, s.Series())
+	assert.Equal(t, 1.5, s.Value)
+
+	_, ok = newSample(apmz.NewTraceTelemetry("foo", contracts.Information), DefaultExcludedLabels)
+	assert.False(t, ok)
+}
+
+func TestFormatAndParse(t *testing.T) {
+	values := map[string]string{
+		`b_metric{x="1"}`: "2",
+		`a_metric`:        "1.5",
+		`b_metric{x="0"}`: "3",
+	}
+
+	text := format(values)
+	assert.Equal(t, "# TYPE a_metric gauge\na_metric 1.5\n# TYPE b_metric gauge\nb_metric{x=\"0\"} 3\nb_metric{x=\"1\"} 2\n", text)
+	assert.Equal(t, values, parse(text))
+}
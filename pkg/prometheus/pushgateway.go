@@ -0,0 +1,192 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/devigned/apmz-sdk/apmz"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+type (
+	// Pushgateway is a service.APMer which pushes metrics to a Prometheus Pushgateway, grouped by job and instance
+	// from the cloud role tags
+	Pushgateway struct {
+		url      string
+		excluded []string
+		tags     map[string]string
+		client   *http.Client
+
+		mu     sync.Mutex
+		groups map[groupKey]*pushGroup
+		report channel.Report
+	}
+
+	groupKey struct {
+		Job      string
+		Instance string
+	}
+
+	pushGroup struct {
+		Items   int
+		Samples map[string]string
+	}
+)
+
+var _ service.APMer = (*Pushgateway)(nil)
+
+// NewPushgateway creates a sink which pushes metrics to the Pushgateway at the url, such as http://localhost:9091
+func NewPushgateway(pushgatewayURL string, opts ...Option) (*Pushgateway, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(pushgatewayURL, "http://") && !strings.HasPrefix(pushgatewayURL, "https://") {
+		return nil, fmt.Errorf("Pushgateway url %q must be an http or https url", pushgatewayURL)
+	}
+
+	return &Pushgateway{
+		url:      strings.TrimSuffix(pushgatewayURL, "/"),
+		excluded: o.excluded,
+		tags:     o.tags,
+		client:   o.client,
+		groups:   make(map[groupKey]*pushGroup),
+	}, nil
+}
+
+// Track records metric telemetry; other telemetry is ignored
+func (p *Pushgateway) Track(item apmz.Telemetry) {
+	s, ok := newSample(item, p.excluded)
+	if !ok {
+		return
+	}
+
+	job, instance := group(item, p.tags)
+	key := groupKey{Job: job, Instance: instance}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report.Sent++
+	g, ok := p.groups[key]
+	if !ok {
+		g = &pushGroup{Samples: make(map[string]string)}
+		p.groups[key] = g
+	}
+	g.Items++
+	g.Samples[s.Series()] = formatValue(s.Value)
+}
+
+// Close pushes the recorded metrics. Failed pushes are retried with backoff until the context's deadline; without a
+// deadline, each push is tried once.
+func (p *Pushgateway) Close(ctx context.Context) []service.DeliveryResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.report.Sent == 0 {
+		return nil
+	}
+
+	keys := make([]groupKey, 0, len(p.groups))
+	for key := range p.groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Job < keys[j].Job || (keys[i].Job == keys[j].Job && keys[i].Instance < keys[j].Instance)
+	})
+
+	lastFailure := ""
+	channel.Retry(ctx, nil, func(retrying bool) bool {
+		if retrying {
+			for _, key := range keys {
+				p.report.Retried += p.groups[key].Items
+			}
+		}
+
+		var retry []groupKey
+		for _, key := range keys {
+			g := p.groups[key]
+			retryable, err := p.push(ctx, key, g)
+			switch {
+			case err == nil:
+				p.report.Accepted += g.Items
+			case retryable:
+				lastFailure = err.Error()
+				retry = append(retry, key)
+			default:
+				p.report.Rejected += g.Items
+				p.report.AddReason(err.Error())
+			}
+		}
+
+		keys = retry
+		return len(keys) > 0
+	})
+
+	for _, key := range keys {
+		p.report.Dropped += p.groups[key].Items
+		p.report.AddReason(lastFailure)
+	}
+
+	report := p.report
+	report.Reasons = append([]string(nil), p.report.Reasons...)
+	return []service.DeliveryResult{{Key: p.url, Report: report}}
+}
+
+// push posts a group's samples, replacing the metrics of the same name in the group but leaving the others, so
+// separate apmz invocations don't remove each other's metrics. It reports whether a failed push may succeed later.
+func (p *Pushgateway) push(ctx context.Context, key groupKey, g *pushGroup) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, p.url+groupPath(key), strings.NewReader(format(g.Samples)))
+	if err != nil {
+		return false, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("push failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	reason := fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		reason = fmt.Sprintf("%s: %s", reason, msg)
+	}
+	retryable := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	if retryable {
+		reason = "push failed: " + reason
+	}
+	return retryable, fmt.Errorf("%s", reason)
+}
+
+// groupPath is the Pushgateway path for the group; values containing a slash are base64 encoded, as the Pushgateway
+// requires
+func groupPath(key groupKey) string {
+	path := "/metrics" + pathLabel("job", key.Job)
+	if key.Instance != "" {
+		path += pathLabel("instance", key.Instance)
+	}
+	return path
+}
+
+func pathLabel(name, value string) string {
+	if strings.Contains(value, "/") {
+		return fmt.Sprintf("/%s@base64/%s", name, base64.RawURLEncoding.EncodeToString([]byte(value)))
+	}
+	return fmt.Sprintf("/%s/%s", name, url.PathEscape(value))
+}
@@ -0,0 +1,87 @@
+package prometheus
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+func TestPushgatewayPushesGroups(t *testing.T) {
+	var mu sync.Mutex
+	bodies := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bits, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, http.MethodPost, r.Method)
+		bodies[r.URL.EscapedPath()] = string(bits)
+	}))
+	defer srv.Close()
+
+	sink, err := NewPushgateway(srv.URL, WithTags(map[string]string{contracts.CloudRole: "ci"}))
+	require.NoError(t, err)
+
+	deploy := apmz.NewMetricTelemetry("deploy-duration", 4)
+	deploy.Tags.Cloud().SetRole("deploy")
+	deploy.Tags.Cloud().SetRoleInstance("vm/1")
+	sink.Track(deploy)
+	sink.Track(apmz.NewMetricTelemetry("other", 1))
+	sink.Track(apmz.NewTraceTelemetry("ignored", contracts.Information))
+
+	results := sink.Close(context.Background())
+	assert.Equal(t, []service.DeliveryResult{{Key: srv.URL, Report: channel.Report{Sent: 2, Accepted: 2}}}, results)
+	assert.Equal(t, map[string]string{
+		"/metrics/job/ci": "# TYPE other gauge\nother 1\n",
+		"/metrics/job/deploy/instance@base64/dm0vMQ": "# TYPE deploy_duration gauge\ndeploy_duration 4\n",
+	}, bodies)
+}
+
+func TestPushgatewayFailures(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		report channel.Report
+	}{
+		{
+			name:   "BadRequest",
+			status: http.StatusBadRequest,
+			body:   "invalid metric name",
+			report: channel.Report{Sent: 1, Rejected: 1, Reasons: []string{"400 Bad Request: invalid metric name"}},
+		},
+		{
+			name:   "Unavailable",
+			status: http.StatusServiceUnavailable,
+			report: channel.Report{Sent: 1, Dropped: 1, Reasons: []string{"push failed: 503 Service Unavailable"}},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(c.status)
+				_, _ = w.Write([]byte(c.body))
+			}))
+			defer srv.Close()
+
+			sink, err := NewPushgateway(srv.URL)
+			require.NoError(t, err)
+			sink.Track(apmz.NewMetricTelemetry("foo", 1))
+			results := sink.Close(context.Background())
+			assert.Equal(t, []service.DeliveryResult{{Key: srv.URL, Report: c.report}}, results)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+
+	"github.com/devigned/apmz/internal/atomicfile"
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+type (
+	// Textfile is a service.APMer which writes metrics into a node_exporter textfile collector directory. Each apmz
+	// invocation merges its metrics into the same file, so the latest value of every series is exposed.
+	Textfile struct {
+		path     string
+		excluded []string
+
+		mu      sync.Mutex
+		samples map[string]string
+		report  channel.Report
+	}
+)
+
+const (
+	// DefaultFileName is the name of the file written in the textfile collector directory
+	DefaultFileName = "apmz.prom"
+
+	lockRetry = 10 * time.Millisecond
+	lockWait  = 5 * time.Second
+	lockStale = 30 * time.Second
+)
+
+var _ service.APMer = (*Textfile)(nil)
+
+// WithFileName sets the name of the file written in the textfile collector directory; it must end in .prom for
+// node_exporter to read it
+func WithFileName(name string) Option {
+	return func(o *options) error {
+		if filepath.Ext(name) != ".prom" || filepath.Base(name) != name {
+			return fmt.Errorf("textfile name %q must be a file name ending in .prom", name)
+		}
+		o.fileName = name
+		return nil
+	}
+}
+
+// NewTextfile creates a sink which writes metrics into the textfile collector directory
+func NewTextfile(dir string, opts ...Option) (*Textfile, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir == "" {
+		return nil, fmt.Errorf("textfile collector directory must not be empty")
+	}
+
+	return &Textfile{
+		path:     filepath.Join(dir, o.fileName),
+		excluded: o.excluded,
+		samples:  make(map[string]string),
+	}, nil
+}
+
+// Track records metric telemetry; other telemetry is ignored
+func (t *Textfile) Track(item apmz.Telemetry) {
+	s, ok := newSample(item, t.excluded)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.Sent++
+	t.samples[s.Series()] = formatValue(s.Value)
+}
+
+// Close merges the recorded metrics into the textfile, replacing it atomically so node_exporter never reads a
+// partially written file
+func (t *Textfile) Close(ctx context.Context) []service.DeliveryResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.report.Sent == 0 {
+		return nil
+	}
+
+	if err := t.write(ctx); err != nil {
+		t.report.Dropped = t.report.Sent
+		t.report.Reasons = []string{err.Error()}
+	} else {
+		t.report.Accepted = t.report.Sent
+	}
+
+	report := t.report
+	report.Reasons = append([]string(nil), t.report.Reasons...)
+	return []service.DeliveryResult{{Key: t.path, Report: report}}
+}
+
+func (t *Textfile) write(ctx context.Context) error {
+	unlock, err := lock(ctx, t.path+".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	values := make(map[string]string)
+	if bits, err := ioutil.ReadFile(t.path); err == nil {
+		values = parse(string(bits))
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("unable to read textfile: %v", err)
+	}
+
+	for series, value := range t.samples {
+		values[series] = value
+	}
+
+	// the temporary file doesn't end in .prom, so node_exporter ignores it until it's renamed
+	if err := atomicfile.Write(t.path, []byte(format(values)), 0644); err != nil {
+		return fmt.Errorf("unable to write textfile: %v", err)
+	}
+	return nil
+}
+
+// lock serializes apmz processes updating the same textfile with a lock file. A lock left behind by a process which
+// died is broken once it is stale.
+func lock(ctx context.Context, path string) (func(), error) {
+	deadline := time.Now().Add(lockWait)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_ = file.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("unable to lock textfile: %v", err)
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockStale {
+			_ = os.Remove(path)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("unable to lock textfile: timed out waiting for %s", path)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("unable to lock textfile: %v", ctx.Err())
+		case <-time.After(lockRetry):
+		}
+	}
+}
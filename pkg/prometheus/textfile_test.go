@@ -0,0 +1,84 @@
+package prometheus
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+func TestTextfileMergesInvocations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-textfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	first, err := NewTextfile(dir)
+	require.NoError(t, err)
+	first.Track(apmz.NewMetricTelemetry("build-duration", 10))
+	first.Track(apmz.NewMetricTelemetry("test-duration", 3))
+	results := first.Close(context.Background())
+	path := filepath.Join(dir, DefaultFileName)
+	assert.Equal(t, []service.DeliveryResult{{Key: path, Report: channel.Report{Sent: 2, Accepted: 2}}}, results)
+
+	second, err := NewTextfile(dir)
+	require.NoError(t, err)
+	second.Track(apmz.NewMetricTelemetry("build-duration", 12))
+	second.Close(context.Background())
+
+	bits, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "# TYPE build_duration gauge\nbuild_duration 12\n# TYPE test_duration gauge\ntest_duration 3\n", string(bits))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary and lock files should be removed")
+}
+
+func TestTextfileIgnoresOtherTelemetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-textfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sink, err := NewTextfile(dir)
+	require.NoError(t, err)
+	sink.Track(apmz.NewTraceTelemetry("foo", contracts.Information))
+	assert.Nil(t, sink.Close(context.Background()))
+
+	_, err = os.Stat(filepath.Join(dir, DefaultFileName))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestTextfileUnwritable(t *testing.T) {
+	sink, err := NewTextfile(filepath.Join(os.TempDir(), "apmz-does-not-exist", "nested"))
+	require.NoError(t, err)
+	sink.Track(apmz.NewMetricTelemetry("foo", 1))
+	results := sink.Close(context.Background())
+	require.Len(t, results, 1)
+	assert.Equal(t, 1, results[0].Dropped)
+	assert.Len(t, results[0].Reasons, 1)
+}
+
+func TestWithFileName(t *testing.T) {
+	_, err := NewTextfile("dir", WithFileName("metrics.txt"))
+	assert.Error(t, err)
+
+	_, err = NewTextfile("dir", WithFileName("../metrics.prom"))
+	assert.Error(t, err)
+
+	sink, err := NewTextfile("dir", WithFileName("ci.prom"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("dir", "ci.prom"), sink.path)
+}
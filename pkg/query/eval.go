@@ -0,0 +1,344 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// eval evaluates the node against the value, which is made up of the maps, slices and scalars of decoded json
+func eval(n node, value interface{}) (interface{}, error) {
+	switch n.Type {
+	case nodeField:
+		if m, ok := value.(map[string]interface{}); ok {
+			return m[n.Value.(string)], nil
+		}
+		return nil, nil
+	case nodeSubexpression, nodeIndexExpression:
+		left, err := eval(n.Children[0], value)
+		if err != nil {
+			return nil, err
+		}
+		return eval(n.Children[1], left)
+	case nodeIndex:
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+
+		i := n.Value.(int)
+		if i < 0 {
+			i += len(list)
+		}
+		if i < 0 || i >= len(list) {
+			return nil, nil
+		}
+		return list[i], nil
+	case nodeSlice:
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		return slice(list, n.Value.(sliceValue)), nil
+	case nodeProjection:
+		left, err := eval(n.Children[0], value)
+		if err != nil {
+			return nil, err
+		}
+
+		list, ok := left.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		return project(list, n.Children[1], nil)
+	case nodeValueProjection:
+		left, err := eval(n.Children[0], value)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := left.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		return project(values(m), n.Children[1], nil)
+	case nodeFilterProjection:
+		left, err := eval(n.Children[0], value)
+		if err != nil {
+			return nil, err
+		}
+
+		list, ok := left.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		return project(list, n.Children[1], &n.Children[2])
+	case nodeFlatten:
+		left, err := eval(n.Children[0], value)
+		if err != nil {
+			return nil, err
+		}
+
+		list, ok := left.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+
+		flattened := []interface{}{}
+		for _, item := range list {
+			if inner, ok := item.([]interface{}); ok {
+				flattened = append(flattened, inner...)
+			} else {
+				flattened = append(flattened, item)
+			}
+		}
+		return flattened, nil
+	case nodeMultiSelectList:
+		if value == nil {
+			return nil, nil
+		}
+
+		list := make([]interface{}, len(n.Children))
+		for i, child := range n.Children {
+			v, err := eval(child, value)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+	case nodeMultiSelectHash:
+		if value == nil {
+			return nil, nil
+		}
+
+		m := make(map[string]interface{}, len(n.Children))
+		for _, kv := range n.Children {
+			v, err := eval(kv.Children[0], value)
+			if err != nil {
+				return nil, err
+			}
+			m[kv.Value.(string)] = v
+		}
+		return m, nil
+	case nodePipe:
+		left, err := eval(n.Children[0], value)
+		if err != nil {
+			return nil, err
+		}
+		return eval(n.Children[1], left)
+	case nodeOr, nodeAnd:
+		left, err := eval(n.Children[0], value)
+		if err != nil {
+			return nil, err
+		}
+
+		if truthy(left) == (n.Type == nodeOr) {
+			return left, nil
+		}
+		return eval(n.Children[1], value)
+	case nodeNot:
+		v, err := eval(n.Children[0], value)
+		if err != nil {
+			return nil, err
+		}
+		return !truthy(v), nil
+	case nodeComparator:
+		left, err := eval(n.Children[0], value)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := eval(n.Children[1], value)
+		if err != nil {
+			return nil, err
+		}
+		return compare(n.Value.(tokenType), left, right), nil
+	case nodeLiteral:
+		return n.Value, nil
+	case nodeCurrent:
+		return value, nil
+	case nodeFunction:
+		args := make([]interface{}, len(n.Children))
+		for i, child := range n.Children {
+			v, err := eval(child, value)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		return call(n.Value.(string), args)
+	default:
+		return nil, fmt.Errorf("unknown node type %d", n.Type)
+	}
+}
+
+// project evaluates the right hand side against each item which matches the condition, if any, dropping null
+// results
+func project(items []interface{}, right node, condition *node) (interface{}, error) {
+	projected := []interface{}{}
+	for _, item := range items {
+		if condition != nil {
+			matched, err := eval(*condition, item)
+			if err != nil {
+				return nil, err
+			}
+			if !truthy(matched) {
+				continue
+			}
+		}
+
+		v, err := eval(right, item)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			projected = append(projected, v)
+		}
+	}
+	return projected, nil
+}
+
+func slice(list []interface{}, s sliceValue) []interface{} {
+	step := 1
+	if s.Step != nil {
+		step = *s.Step
+	}
+
+	bound := func(i *int, def int) int {
+		if i == nil {
+			return def
+		}
+
+		v := *i
+		if v < 0 {
+			v += len(list)
+		}
+		switch {
+		case v < 0 && step > 0:
+			return 0
+		case v < 0:
+			return -1
+		case v > len(list) && step > 0:
+			return len(list)
+		case v >= len(list) && step < 0:
+			return len(list) - 1
+		default:
+			return v
+		}
+	}
+
+	sliced := []interface{}{}
+	if step > 0 {
+		for i := bound(s.Start, 0); i < bound(s.Stop, len(list)); i += step {
+			sliced = append(sliced, list[i])
+		}
+	} else {
+		for i := bound(s.Start, len(list)-1); i > bound(s.Stop, -1); i += step {
+			sliced = append(sliced, list[i])
+		}
+	}
+	return sliced
+}
+
+// values returns the values of the object ordered by their keys, so projections are stable
+func values(m map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vals := make([]interface{}, len(keys))
+	for i, k := range keys {
+		vals[i] = m[k]
+	}
+	return vals
+}
+
+// truthy is false for false, null and empty strings, lists and objects
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	default:
+		return true
+	}
+}
+
+// compare compares two values; ordering comparisons are only defined for numbers, and are null otherwise
+func compare(op tokenType, left, right interface{}) interface{} {
+	switch op {
+	case tokEQ:
+		return equal(left, right)
+	case tokNE:
+		return !equal(left, right)
+	}
+
+	l, lok := number(left)
+	r, rok := number(right)
+	if !lok || !rok {
+		return nil
+	}
+
+	switch op {
+	case tokLT:
+		return l < r
+	case tokLTE:
+		return l <= r
+	case tokGT:
+		return l > r
+	default:
+		return l >= r
+	}
+}
+
+func equal(left, right interface{}) bool {
+	return reflect.DeepEqual(normalize(left), normalize(right))
+}
+
+// normalize turns numbers into float64, so equal numbers compare equal however they were decoded
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(t))
+		for i, item := range t {
+			list[i] = normalize(item)
+		}
+		return list
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			m[k] = normalize(item)
+		}
+		return m
+	default:
+		if n, ok := number(v); ok {
+			return n
+		}
+		return v
+	}
+}
+
+func number(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	case float64:
+		return t, true
+	case int:
+		return float64(t), true
+	default:
+		return 0, false
+	}
+}
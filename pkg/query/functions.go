@@ -0,0 +1,273 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	function struct {
+		Arity int
+		Call  func(args []interface{}) (interface{}, error)
+	}
+)
+
+// functions are the built in functions a query can call; eg length(compute.publicKeys)
+var functions = map[string]function{
+	"length":      {Arity: 1, Call: length},
+	"keys":        {Arity: 1, Call: keys},
+	"values":      {Arity: 1, Call: objectValues},
+	"join":        {Arity: 2, Call: joinStrings},
+	"contains":    {Arity: 2, Call: contains},
+	"starts_with": {Arity: 2, Call: startsWith},
+	"ends_with":   {Arity: 2, Call: endsWith},
+	"to_string":   {Arity: 1, Call: toString},
+	"to_number":   {Arity: 1, Call: toNumber},
+	"type":        {Arity: 1, Call: typeOf},
+	"sort":        {Arity: 1, Call: sortList},
+}
+
+// checkCall returns an error if there's no function of the name or it takes a different number of arguments
+func checkCall(name string, argc int) error {
+	f, ok := functions[name]
+	if !ok {
+		return fmt.Errorf("unknown function %s(); expected one of %s", name, strings.Join(functionNames(), ", "))
+	}
+
+	if argc != f.Arity {
+		return fmt.Errorf("%s() takes %d arguments, but was given %d", name, f.Arity, argc)
+	}
+	return nil
+}
+
+func functionNames() []string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func call(name string, args []interface{}) (interface{}, error) {
+	if err := checkCall(name, len(args)); err != nil {
+		return nil, err
+	}
+
+	v, err := functions[name].Call(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %v", name, err)
+	}
+	return v, nil
+}
+
+func length(args []interface{}) (interface{}, error) {
+	switch t := args[0].(type) {
+	case string:
+		return json.Number(strconv.Itoa(utf8.RuneCountInString(t))), nil
+	case []interface{}:
+		return json.Number(strconv.Itoa(len(t))), nil
+	case map[string]interface{}:
+		return json.Number(strconv.Itoa(len(t))), nil
+	default:
+		return nil, fmt.Errorf("expected a string, array or object, but got %s", jsonType(args[0]))
+	}
+}
+
+func keys(args []interface{}) (interface{}, error) {
+	m, ok := args[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object, but got %s", jsonType(args[0]))
+	}
+
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	list := make([]interface{}, len(names))
+	for i, k := range names {
+		list[i] = k
+	}
+	return list, nil
+}
+
+func objectValues(args []interface{}) (interface{}, error) {
+	m, ok := args[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object, but got %s", jsonType(args[0]))
+	}
+	return values(m), nil
+}
+
+func joinStrings(args []interface{}) (interface{}, error) {
+	sep, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a string separator, but got %s", jsonType(args[0]))
+	}
+
+	list, ok := args[1].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array of strings, but got %s", jsonType(args[1]))
+	}
+
+	parts := make([]string, len(list))
+	for i, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected an array of strings, but it contains %s", jsonType(item))
+		}
+		parts[i] = s
+	}
+	return strings.Join(parts, sep), nil
+}
+
+func contains(args []interface{}) (interface{}, error) {
+	switch t := args[0].(type) {
+	case string:
+		s, ok := args[1].(string)
+		return ok && strings.Contains(t, s), nil
+	case []interface{}:
+		for _, item := range t {
+			if equal(item, args[1]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return nil, fmt.Errorf("expected a string or array, but got %s", jsonType(args[0]))
+	}
+}
+
+func startsWith(args []interface{}) (interface{}, error) {
+	s, prefix, err := twoStrings(args)
+	if err != nil {
+		return nil, err
+	}
+	return strings.HasPrefix(s, prefix), nil
+}
+
+func endsWith(args []interface{}) (interface{}, error) {
+	s, suffix, err := twoStrings(args)
+	if err != nil {
+		return nil, err
+	}
+	return strings.HasSuffix(s, suffix), nil
+}
+
+func toString(args []interface{}) (interface{}, error) {
+	if s, ok := args[0].(string); ok {
+		return s, nil
+	}
+
+	bits, err := json.Marshal(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return string(bits), nil
+}
+
+func toNumber(args []interface{}) (interface{}, error) {
+	switch t := args[0].(type) {
+	case json.Number:
+		return t, nil
+	case string:
+		if _, err := strconv.ParseFloat(t, 64); err != nil {
+			return nil, nil
+		}
+		return json.Number(t), nil
+	default:
+		if n, ok := number(t); ok {
+			return json.Number(strconv.FormatFloat(n, 'f', -1, 64)), nil
+		}
+		return nil, nil
+	}
+}
+
+func typeOf(args []interface{}) (interface{}, error) {
+	return jsonType(args[0]), nil
+}
+
+func sortList(args []interface{}) (interface{}, error) {
+	list, ok := args[0].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array, but got %s", jsonType(args[0]))
+	}
+
+	sorted := append([]interface{}(nil), list...)
+	if len(sorted) == 0 {
+		return sorted, nil
+	}
+
+	switch sorted[0].(type) {
+	case string:
+		for _, item := range sorted {
+			if _, ok := item.(string); !ok {
+				return nil, fmt.Errorf("expected an array of strings or numbers")
+			}
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].(string) < sorted[j].(string) })
+	default:
+		nums := make([]float64, len(sorted))
+		for i, item := range sorted {
+			n, ok := number(item)
+			if !ok {
+				return nil, fmt.Errorf("expected an array of strings or numbers")
+			}
+			nums[i] = n
+		}
+		sort.Sort(byNumber{items: sorted, nums: nums})
+	}
+	return sorted, nil
+}
+
+type byNumber struct {
+	items []interface{}
+	nums  []float64
+}
+
+func (b byNumber) Len() int           { return len(b.items) }
+func (b byNumber) Less(i, j int) bool { return b.nums[i] < b.nums[j] }
+func (b byNumber) Swap(i, j int) {
+	b.items[i], b.items[j] = b.items[j], b.items[i]
+	b.nums[i], b.nums[j] = b.nums[j], b.nums[i]
+}
+
+func twoStrings(args []interface{}) (string, string, error) {
+	a, ok := args[0].(string)
+	if !ok {
+		return "", "", fmt.Errorf("expected a string, but got %s", jsonType(args[0]))
+	}
+
+	b, ok := args[1].(string)
+	if !ok {
+		return "", "", fmt.Errorf("expected a string, but got %s", jsonType(args[1]))
+	}
+	return a, b, nil
+}
+
+// jsonType is the JMESPath name of the type of a value
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		if _, ok := number(v); ok {
+			return "number"
+		}
+		return "unknown"
+	}
+}
@@ -0,0 +1,266 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type (
+	tokenType int
+
+	token struct {
+		Type     tokenType
+		Value    string
+		Position int
+	}
+
+	lexer struct {
+		expression string
+		position   int
+	}
+
+	// SyntaxError is returned when an expression can't be parsed
+	SyntaxError struct {
+		Expression string
+		Position   int
+		Message    string
+	}
+)
+
+const (
+	tokEOF tokenType = iota
+	tokIdentifier
+	tokQuotedIdentifier
+	tokRawString
+	tokLiteral
+	tokNumber
+	tokDot
+	tokStar
+	tokLBracket
+	tokRBracket
+	tokFilter
+	tokFlatten
+	tokLBrace
+	tokRBrace
+	tokLParen
+	tokRParen
+	tokComma
+	tokColon
+	tokPipe
+	tokOr
+	tokAnd
+	tokNot
+	tokCurrent
+	tokEQ
+	tokNE
+	tokLT
+	tokLTE
+	tokGT
+	tokGTE
+)
+
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf("invalid query %q at position %d: %s", e.Expression, e.Position, e.Message)
+}
+
+func tokenize(expression string) ([]token, error) {
+	l := &lexer{expression: expression}
+	var tokens []token
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+		if t.Type == tokEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	for l.position < len(l.expression) && unicode.IsSpace(rune(l.expression[l.position])) {
+		l.position++
+	}
+
+	start := l.position
+	if start >= len(l.expression) {
+		return token{Type: tokEOF, Position: start}, nil
+	}
+
+	single := map[byte]tokenType{
+		'.': tokDot, '*': tokStar, ']': tokRBracket, '{': tokLBrace, '}': tokRBrace,
+		'(': tokLParen, ')': tokRParen, ',': tokComma, ':': tokColon, '@': tokCurrent,
+	}
+
+	c := l.expression[start]
+	if t, ok := single[c]; ok {
+		l.position++
+		return token{Type: t, Value: string(c), Position: start}, nil
+	}
+
+	switch {
+	case c == '[':
+		switch {
+		case l.peek(1) == '?':
+			return l.emit(tokFilter, 2), nil
+		case l.peek(1) == ']':
+			return l.emit(tokFlatten, 2), nil
+		default:
+			return l.emit(tokLBracket, 1), nil
+		}
+	case c == '|':
+		if l.peek(1) == '|' {
+			return l.emit(tokOr, 2), nil
+		}
+		return l.emit(tokPipe, 1), nil
+	case c == '&':
+		if l.peek(1) == '&' {
+			return l.emit(tokAnd, 2), nil
+		}
+		return token{}, l.errorf(start, "expression references are not supported")
+	case c == '!':
+		if l.peek(1) == '=' {
+			return l.emit(tokNE, 2), nil
+		}
+		return l.emit(tokNot, 1), nil
+	case c == '=':
+		if l.peek(1) == '=' {
+			return l.emit(tokEQ, 2), nil
+		}
+		return token{}, l.errorf(start, "expected ==")
+	case c == '<':
+		if l.peek(1) == '=' {
+			return l.emit(tokLTE, 2), nil
+		}
+		return l.emit(tokLT, 1), nil
+	case c == '>':
+		if l.peek(1) == '=' {
+			return l.emit(tokGTE, 2), nil
+		}
+		return l.emit(tokGT, 1), nil
+	case c == '"':
+		return l.quoted()
+	case c == '\'':
+		return l.rawString()
+	case c == '`':
+		return l.literal()
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '_' || isLetter(c):
+		for l.position < len(l.expression) && isIdentifierChar(l.expression[l.position]) {
+			l.position++
+		}
+		return token{Type: tokIdentifier, Value: l.expression[start:l.position], Position: start}, nil
+	default:
+		return token{}, l.errorf(start, fmt.Sprintf("unexpected character %q", c))
+	}
+}
+
+func (l *lexer) emit(t tokenType, width int) token {
+	tok := token{Type: t, Value: l.expression[l.position : l.position+width], Position: l.position}
+	l.position += width
+	return tok
+}
+
+func (l *lexer) peek(offset int) byte {
+	if l.position+offset >= len(l.expression) {
+		return 0
+	}
+	return l.expression[l.position+offset]
+}
+
+// delimited returns the text up to the closing delimiter, skipping escaped delimiters
+func (l *lexer) delimited(delimiter byte) (string, error) {
+	start := l.position
+	l.position++
+	for l.position < len(l.expression) {
+		switch l.expression[l.position] {
+		case '\\':
+			l.position += 2
+		case delimiter:
+			l.position++
+			return l.expression[start+1 : l.position-1], nil
+		default:
+			l.position++
+		}
+	}
+	return "", l.errorf(start, fmt.Sprintf("unclosed %c", delimiter))
+}
+
+func (l *lexer) quoted() (token, error) {
+	start := l.position
+	text, err := l.delimited('"')
+	if err != nil {
+		return token{}, err
+	}
+
+	var value string
+	if err := json.Unmarshal([]byte(`"`+text+`"`), &value); err != nil {
+		return token{}, l.errorf(start, "invalid quoted identifier")
+	}
+	return token{Type: tokQuotedIdentifier, Value: value, Position: start}, nil
+}
+
+func (l *lexer) rawString() (token, error) {
+	start := l.position
+	text, err := l.delimited('\'')
+	if err != nil {
+		return token{}, err
+	}
+	return token{Type: tokRawString, Value: strings.Replace(text, `\'`, `'`, -1), Position: start}, nil
+}
+
+func (l *lexer) literal() (token, error) {
+	start := l.position
+	text, err := l.delimited('`')
+	if err != nil {
+		return token{}, err
+	}
+	return token{Type: tokLiteral, Value: strings.Replace(text, "\\`", "`", -1), Position: start}, nil
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.position
+	l.position++
+	for l.position < len(l.expression) && isDigit(l.expression[l.position]) {
+		l.position++
+	}
+
+	value := l.expression[start:l.position]
+	if _, err := strconv.Atoi(value); err != nil {
+		return token{}, l.errorf(start, fmt.Sprintf("invalid number %q", value))
+	}
+	return token{Type: tokNumber, Value: value, Position: start}, nil
+}
+
+func (l *lexer) errorf(position int, message string) error {
+	return SyntaxError{Expression: l.expression, Position: position, Message: message}
+}
+
+// parseLiteral parses the json of a literal, keeping numbers as json.Number like the data being queried
+func parseLiteral(text string) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(text)))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || isLetter(c) || isDigit(c)
+}
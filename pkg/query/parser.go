@@ -0,0 +1,471 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type (
+	nodeType int
+
+	// node is a node of the syntax tree of an expression
+	node struct {
+		Type     nodeType
+		Value    interface{}
+		Children []node
+	}
+
+	// sliceValue is the start, stop and step of a slice, any of which may be omitted
+	sliceValue struct {
+		Start, Stop, Step *int
+	}
+
+	parser struct {
+		expression string
+		tokens     []token
+		index      int
+	}
+)
+
+const (
+	nodeField nodeType = iota
+	nodeSubexpression
+	nodeIndexExpression
+	nodeIndex
+	nodeSlice
+	nodeProjection
+	nodeValueProjection
+	nodeFilterProjection
+	nodeFlatten
+	nodeMultiSelectList
+	nodeMultiSelectHash
+	nodeKeyValue
+	nodePipe
+	nodeOr
+	nodeAnd
+	nodeNot
+	nodeComparator
+	nodeLiteral
+	nodeCurrent
+	nodeFunction
+)
+
+// bindingPowers decide how tightly each token binds to the expression on its left
+var bindingPowers = map[tokenType]int{
+	tokPipe:     1,
+	tokOr:       2,
+	tokAnd:      3,
+	tokEQ:       5,
+	tokNE:       5,
+	tokLT:       5,
+	tokLTE:      5,
+	tokGT:       5,
+	tokGTE:      5,
+	tokFlatten:  9,
+	tokStar:     20,
+	tokFilter:   21,
+	tokDot:      40,
+	tokNot:      45,
+	tokLBrace:   50,
+	tokLBracket: 55,
+	tokLParen:   60,
+}
+
+func parse(expression string) (node, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return node{}, err
+	}
+
+	p := &parser{expression: expression, tokens: tokens}
+	ast, err := p.parseExpression(0)
+	if err != nil {
+		return node{}, err
+	}
+
+	if p.current().Type != tokEOF {
+		return node{}, p.errorf(fmt.Sprintf("unexpected %q", p.current().Value))
+	}
+	return ast, nil
+}
+
+// parseExpression parses the expression with the nud of the current token and the led of each following token
+// which binds more tightly than bindingPower
+func (p *parser) parseExpression(bindingPower int) (node, error) {
+	tok := p.advance()
+	left, err := p.nud(tok)
+	if err != nil {
+		return node{}, err
+	}
+
+	for bindingPower < bindingPowers[p.current().Type] {
+		tok := p.advance()
+		if left, err = p.led(tok, left); err != nil {
+			return node{}, err
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) nud(tok token) (node, error) {
+	switch tok.Type {
+	case tokIdentifier:
+		return node{Type: nodeField, Value: tok.Value}, nil
+	case tokQuotedIdentifier:
+		if p.current().Type == tokLParen {
+			return node{}, p.errorf("quoted identifiers can't be function names")
+		}
+		return node{Type: nodeField, Value: tok.Value}, nil
+	case tokRawString:
+		return node{Type: nodeLiteral, Value: tok.Value}, nil
+	case tokLiteral:
+		v, err := parseLiteral(tok.Value)
+		if err != nil {
+			return node{}, SyntaxError{Expression: p.expression, Position: tok.Position, Message: fmt.Sprintf("invalid json literal: %v", err)}
+		}
+		return node{Type: nodeLiteral, Value: v}, nil
+	case tokCurrent:
+		return node{Type: nodeCurrent}, nil
+	case tokStar:
+		right, err := p.parseProjectionRHS(bindingPowers[tokStar])
+		if err != nil {
+			return node{}, err
+		}
+		return node{Type: nodeValueProjection, Children: []node{{Type: nodeCurrent}, right}}, nil
+	case tokFilter:
+		return p.filter(node{Type: nodeCurrent})
+	case tokFlatten:
+		right, err := p.parseProjectionRHS(bindingPowers[tokFlatten])
+		if err != nil {
+			return node{}, err
+		}
+		flatten := node{Type: nodeFlatten, Children: []node{{Type: nodeCurrent}}}
+		return node{Type: nodeProjection, Children: []node{flatten, right}}, nil
+	case tokLBracket:
+		switch p.current().Type {
+		case tokNumber, tokColon:
+			right, err := p.parseIndex()
+			if err != nil {
+				return node{}, err
+			}
+			return p.projectIfSlice(node{Type: nodeCurrent}, right)
+		case tokStar:
+			if p.lookahead(1).Type == tokRBracket {
+				p.advance()
+				p.advance()
+				right, err := p.parseProjectionRHS(bindingPowers[tokStar])
+				if err != nil {
+					return node{}, err
+				}
+				return node{Type: nodeProjection, Children: []node{{Type: nodeCurrent}, right}}, nil
+			}
+		}
+		return p.parseMultiSelectList()
+	case tokLBrace:
+		return p.parseMultiSelectHash()
+	case tokNot:
+		expr, err := p.parseExpression(bindingPowers[tokNot])
+		if err != nil {
+			return node{}, err
+		}
+		return node{Type: nodeNot, Children: []node{expr}}, nil
+	case tokLParen:
+		expr, err := p.parseExpression(0)
+		if err != nil {
+			return node{}, err
+		}
+		return expr, p.match(tokRParen)
+	case tokEOF:
+		return node{}, SyntaxError{Expression: p.expression, Position: tok.Position, Message: "unexpected end of query"}
+	default:
+		return node{}, SyntaxError{Expression: p.expression, Position: tok.Position, Message: fmt.Sprintf("unexpected %q", tok.Value)}
+	}
+}
+
+func (p *parser) led(tok token, left node) (node, error) {
+	switch tok.Type {
+	case tokDot:
+		if p.current().Type == tokStar {
+			p.advance()
+			right, err := p.parseProjectionRHS(bindingPowers[tokStar])
+			if err != nil {
+				return node{}, err
+			}
+			return node{Type: nodeValueProjection, Children: []node{left, right}}, nil
+		}
+
+		right, err := p.parseDotRHS(bindingPowers[tokDot])
+		if err != nil {
+			return node{}, err
+		}
+		return node{Type: nodeSubexpression, Children: []node{left, right}}, nil
+	case tokPipe:
+		right, err := p.parseExpression(bindingPowers[tokPipe])
+		if err != nil {
+			return node{}, err
+		}
+		return node{Type: nodePipe, Children: []node{left, right}}, nil
+	case tokOr, tokAnd:
+		right, err := p.parseExpression(bindingPowers[tok.Type])
+		if err != nil {
+			return node{}, err
+		}
+
+		t := nodeOr
+		if tok.Type == tokAnd {
+			t = nodeAnd
+		}
+		return node{Type: t, Children: []node{left, right}}, nil
+	case tokEQ, tokNE, tokLT, tokLTE, tokGT, tokGTE:
+		right, err := p.parseExpression(bindingPowers[tok.Type])
+		if err != nil {
+			return node{}, err
+		}
+		return node{Type: nodeComparator, Value: tok.Type, Children: []node{left, right}}, nil
+	case tokLParen:
+		if left.Type != nodeField {
+			return node{}, p.errorf("invalid function call")
+		}
+
+		var args []node
+		for p.current().Type != tokRParen {
+			arg, err := p.parseExpression(0)
+			if err != nil {
+				return node{}, err
+			}
+			args = append(args, arg)
+
+			if p.current().Type == tokComma {
+				p.advance()
+			} else if p.current().Type != tokRParen {
+				return node{}, p.errorf("expected , or )")
+			}
+		}
+		p.advance()
+
+		// unknown functions and wrong numbers of arguments are found when the query is compiled rather than searched
+		name, _ := left.Value.(string)
+		if err := checkCall(name, len(args)); err != nil {
+			return node{}, SyntaxError{Expression: p.expression, Position: tok.Position, Message: err.Error()}
+		}
+		return node{Type: nodeFunction, Value: left.Value, Children: args}, nil
+	case tokFilter:
+		return p.filter(left)
+	case tokFlatten:
+		right, err := p.parseProjectionRHS(bindingPowers[tokFlatten])
+		if err != nil {
+			return node{}, err
+		}
+		flatten := node{Type: nodeFlatten, Children: []node{left}}
+		return node{Type: nodeProjection, Children: []node{flatten, right}}, nil
+	case tokLBracket:
+		switch p.current().Type {
+		case tokNumber, tokColon:
+			right, err := p.parseIndex()
+			if err != nil {
+				return node{}, err
+			}
+			return p.projectIfSlice(left, right)
+		case tokStar:
+			p.advance()
+			if err := p.match(tokRBracket); err != nil {
+				return node{}, err
+			}
+			right, err := p.parseProjectionRHS(bindingPowers[tokStar])
+			if err != nil {
+				return node{}, err
+			}
+			return node{Type: nodeProjection, Children: []node{left, right}}, nil
+		default:
+			return node{}, p.errorf("expected a number, slice or * in brackets")
+		}
+	default:
+		return node{}, SyntaxError{Expression: p.expression, Position: tok.Position, Message: fmt.Sprintf("unexpected %q", tok.Value)}
+	}
+}
+
+// filter parses the condition and right hand side of a filter projection; eg [?status=='Scheduled'].id
+func (p *parser) filter(left node) (node, error) {
+	condition, err := p.parseExpression(0)
+	if err != nil {
+		return node{}, err
+	}
+
+	if err := p.match(tokRBracket); err != nil {
+		return node{}, err
+	}
+
+	right := node{Type: nodeCurrent}
+	if p.current().Type != tokFlatten {
+		if right, err = p.parseProjectionRHS(bindingPowers[tokFilter]); err != nil {
+			return node{}, err
+		}
+	}
+	return node{Type: nodeFilterProjection, Children: []node{left, right, condition}}, nil
+}
+
+// parseIndex parses an index, such as [0] or [-1], or a slice, such as [1:3] or [::2], after the opening bracket
+func (p *parser) parseIndex() (node, error) {
+	var parts [3]*int
+	part := 0
+	for p.current().Type != tokRBracket {
+		switch p.current().Type {
+		case tokColon:
+			part++
+			if part > 2 {
+				return node{}, p.errorf("too many colons in slice")
+			}
+		case tokNumber:
+			n, _ := strconv.Atoi(p.current().Value)
+			parts[part] = &n
+		default:
+			return node{}, p.errorf("expected a number or : in brackets")
+		}
+		p.advance()
+	}
+	p.advance()
+
+	if part == 0 {
+		if parts[0] == nil {
+			return node{}, p.errorf("expected an index")
+		}
+		return node{Type: nodeIndex, Value: *parts[0]}, nil
+	}
+
+	if parts[2] != nil && *parts[2] == 0 {
+		return node{}, p.errorf("slice step can't be 0")
+	}
+	return node{Type: nodeSlice, Value: sliceValue{Start: parts[0], Stop: parts[1], Step: parts[2]}}, nil
+}
+
+// projectIfSlice projects the rest of the expression over each item of a slice, but not of an index
+func (p *parser) projectIfSlice(left, right node) (node, error) {
+	index := node{Type: nodeIndexExpression, Children: []node{left, right}}
+	if right.Type != nodeSlice {
+		return index, nil
+	}
+
+	rhs, err := p.parseProjectionRHS(bindingPowers[tokStar])
+	if err != nil {
+		return node{}, err
+	}
+	return node{Type: nodeProjection, Children: []node{index, rhs}}, nil
+}
+
+// parseProjectionRHS parses what is applied to each item of a projection; tokens which bind loosely, such as a pipe,
+// end the projection
+func (p *parser) parseProjectionRHS(bindingPower int) (node, error) {
+	switch {
+	case bindingPowers[p.current().Type] < 10:
+		return node{Type: nodeCurrent}, nil
+	case p.current().Type == tokLBracket, p.current().Type == tokFilter:
+		return p.parseExpression(bindingPower)
+	case p.current().Type == tokDot:
+		p.advance()
+		return p.parseDotRHS(bindingPower)
+	default:
+		return node{}, p.errorf(fmt.Sprintf("unexpected %q after projection", p.current().Value))
+	}
+}
+
+func (p *parser) parseDotRHS(bindingPower int) (node, error) {
+	switch p.current().Type {
+	case tokIdentifier, tokQuotedIdentifier, tokStar:
+		return p.parseExpression(bindingPower)
+	case tokLBracket:
+		p.advance()
+		return p.parseMultiSelectList()
+	case tokLBrace:
+		p.advance()
+		return p.parseMultiSelectHash()
+	default:
+		return node{}, p.errorf("expected a field, [ or { after .")
+	}
+}
+
+// parseMultiSelectList parses a list of expressions, such as [name, location], after the opening bracket
+func (p *parser) parseMultiSelectList() (node, error) {
+	var children []node
+	for {
+		expr, err := p.parseExpression(0)
+		if err != nil {
+			return node{}, err
+		}
+		children = append(children, expr)
+
+		if p.current().Type == tokRBracket {
+			p.advance()
+			return node{Type: nodeMultiSelectList, Children: children}, nil
+		}
+
+		if err := p.match(tokComma); err != nil {
+			return node{}, err
+		}
+	}
+}
+
+// parseMultiSelectHash parses an object of expressions, such as {name: name, size: vmSize}, after the opening brace
+func (p *parser) parseMultiSelectHash() (node, error) {
+	var children []node
+	for {
+		key := p.advance()
+		if key.Type != tokIdentifier && key.Type != tokQuotedIdentifier {
+			return node{}, SyntaxError{Expression: p.expression, Position: key.Position, Message: "expected a key"}
+		}
+
+		if err := p.match(tokColon); err != nil {
+			return node{}, err
+		}
+
+		value, err := p.parseExpression(0)
+		if err != nil {
+			return node{}, err
+		}
+		children = append(children, node{Type: nodeKeyValue, Value: key.Value, Children: []node{value}})
+
+		if p.current().Type == tokRBrace {
+			p.advance()
+			return node{Type: nodeMultiSelectHash, Children: children}, nil
+		}
+
+		if err := p.match(tokComma); err != nil {
+			return node{}, err
+		}
+	}
+}
+
+func (p *parser) current() token {
+	return p.tokens[p.index]
+}
+
+func (p *parser) lookahead(n int) token {
+	if p.index+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.index+n]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.index]
+	if p.index < len(p.tokens)-1 {
+		p.index++
+	}
+	return tok
+}
+
+func (p *parser) match(t tokenType) error {
+	if p.current().Type != t {
+		return p.errorf(fmt.Sprintf("unexpected %q", p.current().Value))
+	}
+	p.advance()
+	return nil
+}
+
+// errorf returns a syntax error at the current token; running out of tokens is reported as such, since what was
+// expected is usually obvious from the query
+func (p *parser) errorf(message string) error {
+	if p.current().Type == tokEOF {
+		message = "unexpected end of query"
+	}
+	return SyntaxError{Expression: p.expression, Position: p.current().Position, Message: message}
+}
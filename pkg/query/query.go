@@ -0,0 +1,83 @@
+// Package query selects and reshapes printed output with a subset of JMESPath, such as "compute.location" or
+// "events[?eventType=='Reboot'].eventId", so scripts don't need jq. It supports fields, indexes, slices, projections,
+// filters, multi-selects, pipes, comparisons and the length, keys, values, join, contains, starts_with, ends_with,
+// to_string, to_number, type and sort functions, but not expression references (&expr) or the other built in
+// functions.
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+type (
+	// Expression is a compiled query. The zero value is an empty expression, which selects everything.
+	Expression struct {
+		text string
+		ast  *node
+	}
+)
+
+// Compile parses a query expression
+func Compile(text string) (*Expression, error) {
+	e := new(Expression)
+	if err := e.Set(text); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Search applies the expression to an object, returning the maps, slices and scalars of the json of the result.
+// Numbers are returned as json.Number.
+func (e *Expression) Search(obj interface{}) (interface{}, error) {
+	bits, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(bits))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	if e.IsEmpty() {
+		return v, nil
+	}
+	return eval(*e.ast, v)
+}
+
+// IsEmpty is true if there is no expression
+func (e *Expression) IsEmpty() bool {
+	return e == nil || e.ast == nil
+}
+
+// String returns the text of the expression
+func (e *Expression) String() string {
+	if e == nil {
+		return ""
+	}
+	return e.text
+}
+
+// Set compiles the text of the expression, so it can be used as a flag
+func (e *Expression) Set(text string) error {
+	if text == "" {
+		*e = Expression{}
+		return nil
+	}
+
+	ast, err := parse(text)
+	if err != nil {
+		return err
+	}
+
+	*e = Expression{text: text, ast: &ast}
+	return nil
+}
+
+// Type describes the values of the flag
+func (e *Expression) Type() string {
+	return "query"
+}
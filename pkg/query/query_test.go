@@ -0,0 +1,165 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDocument = `{
+  "compute": {
+    "location": "westus2",
+    "vmSize": "Standard_DS1_v2",
+    "publicKeys": [{"path": "/home/a", "keyData": "a"}, {"path": "/home/b", "keyData": "b"}],
+    "tags": "env:prod;team:ops"
+  },
+  "network": {
+    "interface": [
+      {"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.0.4"}, {"privateIpAddress": "10.0.0.5"}]}},
+      {"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.1.4"}]}}
+    ]
+  },
+  "events": [
+    {"eventId": "1", "eventType": "Reboot", "notBefore": 10},
+    {"eventId": "2", "eventType": "Freeze", "notBefore": 20},
+    {"eventId": "3", "eventType": "Reboot", "notBefore": 30}
+  ],
+  "numbers": [3, 1, 2],
+  "quoted-key": true
+}`
+
+func TestSearch(t *testing.T) {
+	var doc interface{}
+	require.NoError(t, json.Unmarshal([]byte(testDocument), &doc))
+
+	cases := []struct {
+		query  string
+		expect string
+	}{
+		{query: "compute.location", expect: `"westus2"`},
+		{query: "compute.missing", expect: `null`},
+		{query: "compute.location.nested", expect: `null`},
+		{query: `"quoted-key"`, expect: `true`},
+		{query: "compute.publicKeys[0].path", expect: `"/home/a"`},
+		{query: "compute.publicKeys[-1].keyData", expect: `"b"`},
+		{query: "compute.publicKeys[5]", expect: `null`},
+		{query: "compute.publicKeys[*].path", expect: `["/home/a","/home/b"]`},
+		{query: "compute.publicKeys[].keyData", expect: `["a","b"]`},
+		{query: "network.interface[*].ipv4.ipAddress[*].privateIpAddress", expect: `[["10.0.0.4","10.0.0.5"],["10.0.1.4"]]`},
+		{query: "network.interface[].ipv4.ipAddress[].privateIpAddress", expect: `["10.0.0.4","10.0.0.5","10.0.1.4"]`},
+		{query: "network.interface[0].ipv4.ipAddress[0].privateIpAddress", expect: `"10.0.0.4"`},
+		{query: "events[?eventType=='Reboot'].eventId", expect: `["1","3"]`},
+		{query: "events[?notBefore > `15`].eventId", expect: `["2","3"]`},
+		{query: "events[?eventType=='Reboot' && notBefore >= `30`].eventId | [0]", expect: `"3"`},
+		{query: "events[?eventType=='Freeze' || eventId=='1'].eventId", expect: `["1","2"]`},
+		{query: "events[?!(eventType=='Reboot')].eventId", expect: `["2"]`},
+		{query: "events[1:].eventId", expect: `["2","3"]`},
+		{query: "events[::-1].eventId", expect: `["3","2","1"]`},
+		{query: "events[:2].eventId", expect: `["1","2"]`},
+		{query: "compute.{location: location, size: vmSize}", expect: `{"location":"westus2","size":"Standard_DS1_v2"}`},
+		{query: "[compute.location, compute.vmSize]", expect: `["westus2","Standard_DS1_v2"]`},
+		{query: "events[*].[eventId, eventType]", expect: `[["1","Reboot"],["2","Freeze"],["3","Reboot"]]`},
+		{query: "events[0].*", expect: `["1","Reboot",10]`},
+		{query: "compute.publicKeys | length(@)", expect: `2`},
+		{query: "length(events)", expect: `3`},
+		{query: "keys(compute)", expect: `["location","publicKeys","tags","vmSize"]`},
+		{query: "join(',', compute.publicKeys[*].path)", expect: `"/home/a,/home/b"`},
+		{query: "contains(compute.tags, 'env:prod')", expect: `true`},
+		{query: "events[?starts_with(eventType, 'Re')].eventId", expect: `["1","3"]`},
+		{query: "sort(numbers)", expect: `[1,2,3]`},
+		{query: "to_string(numbers[0])", expect: `"3"`},
+		{query: "type(compute)", expect: `"object"`},
+		{query: "compute.location == 'westus2'", expect: `true`},
+		{query: "`{\"a\": 1}`.a", expect: `1`},
+		{query: "@", expect: ""},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.query, func(t *testing.T) {
+			t.Parallel()
+			e, err := Compile(c.query)
+			require.NoError(t, err)
+
+			v, err := e.Search(doc)
+			require.NoError(t, err)
+
+			if c.expect == "" {
+				assert.Equal(t, doc, normalizeJSON(t, v))
+				return
+			}
+			bits, err := json.Marshal(v)
+			require.NoError(t, err)
+			assert.JSONEq(t, c.expect, string(bits))
+		})
+	}
+}
+
+func TestSearchKeepsLargeNumbers(t *testing.T) {
+	e, err := Compile("size")
+	require.NoError(t, err)
+
+	v, err := e.Search(map[string]int64{"size": 1234567890123})
+	require.NoError(t, err)
+	assert.Equal(t, json.Number("1234567890123"), v)
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := []string{
+		"compute.",
+		"compute[",
+		"events[?eventType=='Reboot'",
+		"'unclosed",
+		"compute.location = 'westus2'",
+		"[1:2:0]",
+		"{location}",
+		"#",
+		"unknown(@)",
+		"max(`[1, 2]`)",
+		"length(@, @)",
+		"join(',')",
+	}
+
+	for _, c := range cases {
+		_, err := Compile(c)
+		if assert.Error(t, err, c) {
+			assert.IsType(t, SyntaxError{}, err, c)
+		}
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	cases := []string{
+		"length(`1`)",
+		"join(',', numbers)",
+	}
+
+	for _, c := range cases {
+		e, err := Compile(c)
+		require.NoError(t, err, c)
+
+		_, err = e.Search(map[string]interface{}{"numbers": []int{1}})
+		assert.Error(t, err, c)
+	}
+}
+
+func TestEmptyExpression(t *testing.T) {
+	var e Expression
+	assert.True(t, e.IsEmpty())
+	require.NoError(t, e.Set("a"))
+	assert.False(t, e.IsEmpty())
+	assert.Equal(t, "a", e.String())
+	require.NoError(t, e.Set(""))
+	assert.True(t, e.IsEmpty())
+}
+
+func normalizeJSON(t *testing.T, v interface{}) interface{} {
+	bits, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	var normalized interface{}
+	require.NoError(t, json.Unmarshal(bits, &normalized))
+	return normalized
+}
@@ -0,0 +1,212 @@
+package route
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/telemetry"
+)
+
+type (
+	// Filter is a compiled rule, which decides whether telemetry is sent to a destination
+	Filter struct {
+		types    []string
+		severity contracts.SeverityLevel
+		names    []*regexp.Regexp
+		tags     map[string]*regexp.Regexp
+	}
+
+	// filteredSink only tracks the telemetry matching its filter
+	filteredSink struct {
+		service.APMer
+		filter *Filter
+	}
+
+	// filteredClient only tracks the telemetry matching its filter; everything else, such as the channel the
+	// delivery result is read from, is the underlying client's
+	filteredClient struct {
+		apmz.TelemetryClient
+		filter *Filter
+	}
+)
+
+// NewFilter compiles a rule
+func NewFilter(rule Rule) (*Filter, error) {
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	f := &Filter{severity: contracts.Verbose}
+	for _, t := range rule.Types {
+		f.types = append(f.types, strings.ToLower(t))
+	}
+
+	for i, s := range severities {
+		if s == strings.ToLower(rule.Severity) {
+			f.severity = contracts.SeverityLevel(i)
+		}
+	}
+
+	for _, name := range rule.Names {
+		re, err := compile(name)
+		if err != nil {
+			return nil, err
+		}
+		f.names = append(f.names, re)
+	}
+
+	if len(rule.Tags) > 0 {
+		f.tags = make(map[string]*regexp.Regexp, len(rule.Tags))
+		for k, v := range rule.Tags {
+			re, err := compile(v)
+			if err != nil {
+				return nil, err
+			}
+			f.tags[k] = re
+		}
+	}
+	return f, nil
+}
+
+// Matches is true if the item matches every field of the rule
+func (f *Filter) Matches(item apmz.Telemetry) bool {
+	if len(f.types) > 0 && !contains(f.types, typeName(item)) {
+		return false
+	}
+
+	if severity(item) < f.severity {
+		return false
+	}
+
+	if len(f.names) > 0 && !matchesAny(f.names, name(item)) {
+		return false
+	}
+
+	for k, re := range f.tags {
+		value, ok := item.GetProperties()[k]
+		if !ok {
+			value, ok = item.ContextTags()[k]
+		}
+
+		if !ok || !re.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Sink wraps a sink so it is only sent the telemetry matching the filter
+func (f *Filter) Sink(sink service.APMer) service.APMer {
+	return filteredSink{APMer: sink, filter: f}
+}
+
+// Client wraps an Application Insights client so it is only sent the telemetry matching the filter
+func (f *Filter) Client(client apmz.TelemetryClient) apmz.TelemetryClient {
+	return filteredClient{TelemetryClient: client, filter: f}
+}
+
+// Track tracks the item if it matches the filter
+func (fs filteredSink) Track(item apmz.Telemetry) {
+	if fs.filter.Matches(item) {
+		fs.APMer.Track(item)
+	}
+}
+
+// Track tracks the item if it matches the filter
+func (fc filteredClient) Track(item apmz.Telemetry) {
+	if fc.filter.Matches(item) {
+		fc.TelemetryClient.Track(item)
+	}
+}
+
+// compile turns a pattern, where * matches any characters and ? matches one, into an anchored regular expression
+func compile(pattern string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	return re, nil
+}
+
+func matchesAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// typeName is the event type of the item's kind, or else the type of its data, so items which can't be written to a
+// batch, such as aggregate metrics, still have a type
+func typeName(item apmz.Telemetry) string {
+	if kind, ok := service.DefaultTelemetryKinds.KindOf(item); ok {
+		return string(kind.Type)
+	}
+
+	kind, _ := service.DefaultTelemetryKinds.KindOfData(item.TelemetryData().BaseType())
+	return string(kind.Type)
+}
+
+// severity is the severity level of the item. Failed requests, dependencies and availability results are errors, and
+// items without a severity, such as events, metrics and aggregate metrics, are information.
+func severity(item apmz.Telemetry) contracts.SeverityLevel {
+	switch t := item.(type) {
+	case *apmz.TraceTelemetry:
+		return t.SeverityLevel
+	case *telemetry.ExceptionTelemetry:
+		return t.SeverityLevel
+	case *apmz.ExceptionTelemetry:
+		return t.SeverityLevel
+	case *apmz.RequestTelemetry:
+		return failedIsError(t.Success)
+	case *apmz.RemoteDependencyTelemetry:
+		return failedIsError(t.Success)
+	case *apmz.AvailabilityTelemetry:
+		return failedIsError(t.Success)
+	default:
+		return contracts.Information
+	}
+}
+
+func failedIsError(success bool) contracts.SeverityLevel {
+	if success {
+		return contracts.Information
+	}
+	return contracts.Error
+}
+
+// name is the name of the item, or the message of traces and exceptions
+func name(item apmz.Telemetry) string {
+	switch t := item.(type) {
+	case *apmz.TraceTelemetry:
+		return t.Message
+	case *telemetry.ExceptionTelemetry:
+		return t.Message
+	case *apmz.ExceptionTelemetry:
+		// the message is formatted from the error as the item is sent
+		return t.TelemetryData().(*contracts.ExceptionData).Exceptions[0].Message
+	case *apmz.EventTelemetry:
+		return t.Name
+	case *apmz.MetricTelemetry:
+		return t.Name
+	case *apmz.AggregateMetricTelemetry:
+		return t.Name
+	case *apmz.RequestTelemetry:
+		return t.Name
+	case *apmz.RemoteDependencyTelemetry:
+		return t.Name
+	case *apmz.AvailabilityTelemetry:
+		return t.Name
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,120 @@
+package route
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/service"
+	"github.com/devigned/apmz/pkg/telemetry"
+)
+
+type countingSink struct {
+	tracked int
+}
+
+func (cs *countingSink) Track(item apmz.Telemetry) {
+	cs.tracked++
+}
+
+func (cs *countingSink) Close(ctx context.Context) []service.DeliveryResult {
+	return nil
+}
+
+func TestFilterMatches(t *testing.T) {
+	info := apmz.NewTraceTelemetry("deploy started", contracts.Information)
+	info.Properties["env"] = "prod"
+	info.Tags.Cloud().SetRole("deploy")
+	failed := apmz.NewRequestTelemetry("GET", "http://example.com/health", time.Second, "500")
+	failed.Success = false
+	exception := telemetry.NewExceptionTelemetry("ScriptError", "deploy failed")
+	metric := apmz.NewMetricTelemetry("deploy-duration", 10)
+	sdkException := apmz.NewExceptionTelemetry("deploy panicked")
+	sdkException.SeverityLevel = contracts.Critical
+	aggregate := apmz.NewAggregateMetricTelemetry("deploy-steps")
+	all := []apmz.Telemetry{info, failed, exception, metric, sdkException, aggregate}
+
+	cases := []struct {
+		name    string
+		rule    Rule
+		matches []apmz.Telemetry
+	}{
+		{
+			name:    "Everything",
+			matches: all,
+		},
+		{
+			name:    "Types",
+			rule:    Rule{Types: []string{"Trace", "metric"}},
+			matches: []apmz.Telemetry{info, metric, aggregate},
+		},
+		{
+			name:    "Errors",
+			rule:    Rule{Severity: "error"},
+			matches: []apmz.Telemetry{failed, exception, sdkException},
+		},
+		{
+			name:    "Names",
+			rule:    Rule{Names: []string{"deploy*", "GET *"}},
+			matches: all,
+		},
+		{
+			name:    "SingleCharacter",
+			rule:    Rule{Names: []string{"deploy ??????"}},
+			matches: []apmz.Telemetry{exception},
+		},
+		{
+			name:    "AggregateMetricName",
+			rule:    Rule{Names: []string{"deploy-steps"}, Severity: "information"},
+			matches: []apmz.Telemetry{aggregate},
+		},
+		{
+			name:    "PropertyTag",
+			rule:    Rule{Tags: map[string]string{"env": "pr*"}},
+			matches: []apmz.Telemetry{info},
+		},
+		{
+			name:    "ContextTag",
+			rule:    Rule{Tags: map[string]string{contracts.CloudRole: "deploy"}},
+			matches: []apmz.Telemetry{info},
+		},
+		{
+			name:    "AllFields",
+			rule:    Rule{Types: []string{"exception", "trace"}, Severity: "warning", Names: []string{"deploy*"}},
+			matches: []apmz.Telemetry{exception, sdkException},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			f, err := NewFilter(c.rule)
+			require.NoError(t, err)
+
+			var matched []apmz.Telemetry
+			for _, item := range all {
+				if f.Matches(item) {
+					matched = append(matched, item)
+				}
+			}
+			assert.Equal(t, c.matches, matched)
+		})
+	}
+}
+
+func TestFilterSink(t *testing.T) {
+	f, err := NewFilter(Rule{Severity: "error"})
+	require.NoError(t, err)
+
+	sink := new(countingSink)
+	filtered := f.Sink(sink)
+	filtered.Track(apmz.NewTraceTelemetry("fine", contracts.Information))
+	filtered.Track(apmz.NewTraceTelemetry("broken", contracts.Error))
+	assert.Equal(t, 1, sink.tracked)
+}
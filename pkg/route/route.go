@@ -0,0 +1,260 @@
+// Package route parses destination URIs, such as appinsights://<key> or https://hooks.example.com/alerts, and the
+// rules which decide which telemetry is sent to each destination. A rule can be given in the URI's fragment, eg
+// "https://hooks.example.com/alerts#severity=error", or in a routes file.
+package route
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/devigned/apmz/pkg/service"
+)
+
+type (
+	// Destination is where telemetry is sent and which of it is sent there
+	Destination struct {
+		// URI identifies the destination, without a fragment
+		URI string `yaml:"uri"`
+		// Match selects the telemetry sent to the destination; an empty rule sends everything
+		Match Rule `yaml:"match,omitempty"`
+	}
+
+	// Rule selects telemetry by type, severity, name and tags. Empty fields match everything, and an item must match
+	// every field which is set.
+	Rule struct {
+		// Types are the telemetry types to match; eg "trace" or "exception"
+		Types []string `yaml:"types,omitempty"`
+		// Severity is the minimum severity to match; eg "warning" matches warnings, errors and critical items. Failed
+		// requests, dependencies and availability results are errors, and other items without a severity are
+		// information.
+		Severity string `yaml:"severity,omitempty"`
+		// Names are patterns, where * matches any characters and ? matches one, for the name or message of the item
+		Names []string `yaml:"names,omitempty"`
+		// Tags are patterns for the values of custom properties or context tags, keyed by their names
+		Tags map[string]string `yaml:"tags,omitempty"`
+	}
+
+	// Config is the routes file, listing the destinations telemetry is sent to
+	Config struct {
+		Destinations []Destination `yaml:"destinations"`
+	}
+)
+
+const (
+	// AppInsights sends to the Application Insights resource of the instrumentation key in the host, eg
+	// appinsights://<key>?endpoint=https://westus2-0.in.applicationinsights.azure.com/
+	AppInsights = "appinsights"
+	// OTLP sends to an OTLP/HTTP endpoint over http, eg otlp://localhost:4318
+	OTLP = "otlp"
+	// OTLPHTTPS sends to an OTLP/HTTP endpoint over https
+	OTLPHTTPS = "otlp+https"
+	// Pushgateway pushes metrics to a Prometheus Pushgateway over http, eg pushgateway://localhost:9091
+	Pushgateway = "pushgateway"
+	// PushgatewayHTTPS pushes metrics to a Prometheus Pushgateway over https
+	PushgatewayHTTPS = "pushgateway+https"
+	// Textfile writes metrics to a node_exporter textfile collector directory, eg textfile:///var/lib/node_exporter
+	Textfile = "textfile"
+	// StatsD sends to a StatsD agent over UDP, eg statsd://localhost:8125, or a unix domain socket, eg
+	// statsd:///var/run/datadog/dsd.socket
+	StatsD = "statsd"
+	// File appends telemetry to a file as batch events, eg file:///var/log/apmz.jsonl
+	File = "file"
+	// HTTP posts telemetry as batch events to a webhook, eg https://hooks.example.com/alerts
+	HTTP = "http"
+	// HTTPS posts telemetry as batch events to a webhook over https
+	HTTPS = "https"
+)
+
+var (
+	schemes = []string{AppInsights, OTLP, OTLPHTTPS, Pushgateway, PushgatewayHTTPS, Textfile, StatsD, File, HTTP, HTTPS}
+
+	severities = []string{"verbose", "information", "warning", "error", "critical"}
+)
+
+// Parse parses a destination URI, with an optional rule in its fragment; eg
+// "https://hooks.example.com/alerts#type=trace|exception&severity=error&name=deploy*&tag.env=prod". Alternatives are
+// separated by "|", and tags are given as tag.<name>.
+func Parse(uri string) (Destination, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return Destination{}, fmt.Errorf("destination %q is not a valid uri: %v", uri, err)
+	}
+
+	rule, err := ParseRule(u.Fragment)
+	if err != nil {
+		return Destination{}, fmt.Errorf("destination %q: %v", uri, err)
+	}
+
+	u.Fragment = ""
+	d := Destination{URI: u.String(), Match: rule}
+	if err := d.Validate(); err != nil {
+		return Destination{}, err
+	}
+	return d, nil
+}
+
+// ParseRule parses a rule written as a query string; eg "type=trace|exception&severity=error"
+func ParseRule(s string) (Rule, error) {
+	var rule Rule
+	if s == "" {
+		return rule, nil
+	}
+
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return rule, fmt.Errorf("rule %q is not a valid query string: %v", s, err)
+	}
+
+	for key, vals := range values {
+		switch {
+		case key == "type":
+			rule.Types = append(rule.Types, alternatives(vals)...)
+		case key == "name":
+			rule.Names = append(rule.Names, alternatives(vals)...)
+		case key == "severity":
+			rule.Severity = vals[len(vals)-1]
+		case strings.HasPrefix(key, "tag.") && len(key) > len("tag."):
+			if rule.Tags == nil {
+				rule.Tags = make(map[string]string)
+			}
+			rule.Tags[strings.TrimPrefix(key, "tag.")] = vals[len(vals)-1]
+		default:
+			return rule, fmt.Errorf("unknown rule field %q; expected type, severity, name or tag.<name>", key)
+		}
+	}
+	return rule, nil
+}
+
+// Load reads the destinations from a routes file
+func Load(path string) ([]Destination, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.UnmarshalStrict(b, &config); err != nil {
+		return nil, fmt.Errorf("unable to read routes file %s: %v", path, err)
+	}
+
+	destinations := make([]Destination, len(config.Destinations))
+	for i, d := range config.Destinations {
+		parsed, err := Parse(d.URI)
+		if err != nil {
+			return nil, fmt.Errorf("routes file %s: %v", path, err)
+		}
+
+		if !parsed.Match.IsZero() && !d.Match.IsZero() {
+			return nil, fmt.Errorf("routes file %s: destination %q has a rule in both its uri and match", path, d.URI)
+		}
+
+		if parsed.Match.IsZero() {
+			parsed.Match = d.Match
+		}
+
+		if err := parsed.Validate(); err != nil {
+			return nil, fmt.Errorf("routes file %s: %v", path, err)
+		}
+		destinations[i] = parsed
+	}
+	return destinations, nil
+}
+
+// Merge returns the destinations with the overrides applied. An override replaces the destination with the same URI,
+// and is otherwise added to the end.
+func Merge(destinations, overrides []Destination) []Destination {
+	merged := append([]Destination(nil), destinations...)
+	for _, o := range overrides {
+		replaced := false
+		for i, d := range merged {
+			if d.URI == o.URI {
+				merged[i] = o
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			merged = append(merged, o)
+		}
+	}
+	return merged
+}
+
+// Scheme returns the scheme of the destination URI
+func (d Destination) Scheme() string {
+	u, err := url.Parse(d.URI)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme
+}
+
+// Validate checks the destination has a known scheme, the parts of the URI it needs and a valid rule
+func (d Destination) Validate() error {
+	u, err := url.Parse(d.URI)
+	if err != nil {
+		return fmt.Errorf("destination %q is not a valid uri: %v", d.URI, err)
+	}
+
+	switch u.Scheme {
+	case AppInsights, OTLP, OTLPHTTPS, Pushgateway, PushgatewayHTTPS, HTTP, HTTPS:
+		if u.Host == "" {
+			return fmt.Errorf("destination %q must have a host", d.URI)
+		}
+	case Textfile, File:
+		if u.Host != "" || u.Path == "" {
+			return fmt.Errorf("destination %q must have an absolute path; eg %s:///path", d.URI, u.Scheme)
+		}
+	case StatsD:
+		if u.Host == "" && u.Path == "" {
+			return fmt.Errorf("destination %q must have a host and port or a socket path", d.URI)
+		}
+	default:
+		return fmt.Errorf("destination %q has an unknown scheme; expected one of %s", d.URI, strings.Join(schemes, ", "))
+	}
+
+	if _, err := NewFilter(d.Match); err != nil {
+		return fmt.Errorf("destination %q: %v", d.URI, err)
+	}
+	return nil
+}
+
+// IsZero is true if the rule has no fields set, so it matches everything
+func (r Rule) IsZero() bool {
+	return len(r.Types) == 0 && r.Severity == "" && len(r.Names) == 0 && len(r.Tags) == 0
+}
+
+// Validate checks the types and severity are known and the patterns are valid
+func (r Rule) Validate() error {
+	for _, t := range r.Types {
+		if !contains(service.DefaultTelemetryKinds.TypeNames(), strings.ToLower(t)) {
+			return fmt.Errorf("unknown telemetry type %q; expected one of %s", t, strings.Join(service.DefaultTelemetryKinds.TypeNames(), ", "))
+		}
+	}
+
+	if r.Severity != "" && !contains(severities, strings.ToLower(r.Severity)) {
+		return fmt.Errorf("unknown severity %q; expected one of %s", r.Severity, strings.Join(severities, ", "))
+	}
+	return nil
+}
+
+func alternatives(values []string) []string {
+	var alts []string
+	for _, v := range values {
+		alts = append(alts, strings.Split(v, "|")...)
+	}
+	return alts
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
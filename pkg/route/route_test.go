@@ -0,0 +1,155 @@
+package route
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name   string
+		uri    string
+		expect Destination
+		err    string
+	}{
+		{
+			name:   "AppInsights",
+			uri:    "appinsights://key",
+			expect: Destination{URI: "appinsights://key"},
+		},
+		{
+			name: "WebhookWithRule",
+			uri:  "https://hooks.example.com/alerts?token=secret#type=trace|exception&severity=error&name=deploy*&tag.env=prod",
+			expect: Destination{
+				URI: "https://hooks.example.com/alerts?token=secret",
+				Match: Rule{
+					Types:    []string{"trace", "exception"},
+					Severity: "error",
+					Names:    []string{"deploy*"},
+					Tags:     map[string]string{"env": "prod"},
+				},
+			},
+		},
+		{
+			name:   "StatsdSocket",
+			uri:    "statsd:///var/run/datadog/dsd.socket",
+			expect: Destination{URI: "statsd:///var/run/datadog/dsd.socket"},
+		},
+		{
+			name:   "File",
+			uri:    "file:///var/log/apmz.jsonl#type=trace",
+			expect: Destination{URI: "file:///var/log/apmz.jsonl", Match: Rule{Types: []string{"trace"}}},
+		},
+		{
+			name: "UnknownScheme",
+			uri:  "kafka://broker:9092",
+			err:  `destination "kafka://broker:9092" has an unknown scheme`,
+		},
+		{
+			name: "MissingHost",
+			uri:  "otlp:///v1",
+			err:  `destination "otlp:///v1" must have a host`,
+		},
+		{
+			name: "RelativeFile",
+			uri:  "file://apmz.jsonl",
+			err:  `destination "file://apmz.jsonl" must have an absolute path`,
+		},
+		{
+			name: "UnknownField",
+			uri:  "appinsights://key#level=error",
+			err:  `unknown rule field "level"`,
+		},
+		{
+			name: "UnknownType",
+			uri:  "appinsights://key#type=log",
+			err:  `unknown telemetry type "log"`,
+		},
+		{
+			name: "UnknownSeverity",
+			uri:  "appinsights://key#severity=fatal",
+			err:  `unknown severity "fatal"`,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			d, err := Parse(c.uri)
+			if c.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), c.err)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, c.expect, d)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apmz-routes")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "routes.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`destinations:
+  - uri: appinsights://key
+  - uri: https://hooks.example.com/alerts
+    match:
+      severity: error
+      tags:
+        env: prod
+  - uri: file:///var/log/apmz.jsonl#type=trace
+`), 0644))
+
+	destinations, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Destination{
+		{URI: "appinsights://key"},
+		{URI: "https://hooks.example.com/alerts", Match: Rule{Severity: "error", Tags: map[string]string{"env": "prod"}}},
+		{URI: "file:///var/log/apmz.jsonl", Match: Rule{Types: []string{"trace"}}},
+	}, destinations)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`destinations:
+  - uri: appinsights://key#type=trace
+    match:
+      severity: error
+`), 0644))
+	_, err = Load(path)
+	assert.Error(t, err, "a rule should not be given in both the uri and match")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`destinations:
+  - uri: appinsights://key
+    match:
+      level: error
+`), 0644))
+	_, err = Load(path)
+	assert.Error(t, err, "unknown fields should not be ignored")
+}
+
+func TestMerge(t *testing.T) {
+	file := []Destination{
+		{URI: "appinsights://key"},
+		{URI: "https://hooks.example.com/alerts", Match: Rule{Severity: "error"}},
+	}
+	flags := []Destination{
+		{URI: "https://hooks.example.com/alerts", Match: Rule{Severity: "critical"}},
+		{URI: "otlp://localhost:4318"},
+	}
+
+	assert.Equal(t, []Destination{
+		{URI: "appinsights://key"},
+		{URI: "https://hooks.example.com/alerts", Match: Rule{Severity: "critical"}},
+		{URI: "otlp://localhost:4318"},
+	}, Merge(file, flags))
+	assert.Equal(t, Rule{Severity: "error"}, file[1].Match, "the destinations should not be modified")
+}
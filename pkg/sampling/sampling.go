@@ -0,0 +1,153 @@
+// Package sampling decides which telemetry to send when only a percentage of it is wanted. Decisions are made from a
+// hash of the correlation id, so every item from a sampled-in script run is kept. Items without one are sampled at random.
+package sampling
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+)
+
+type (
+	// Sampler decides whether to keep an item and at what rate it was sampled
+	Sampler struct {
+		// Percentage of items to keep, between 0 and 100
+		Percentage float64
+		// TypeRates overrides the percentage for the base type of telemetry data, such as "MessageData" for traces or
+		// "RemoteDependencyData" for dependencies
+		TypeRates map[string]float64
+		// KeepErrors keeps error traces, exceptions and failed requests, dependencies and availability results
+		// regardless of the rate
+		KeepErrors bool
+	}
+)
+
+const (
+	// CorrelationIDProperty is the custom property the bash helpers use to correlate the events of a script run
+	CorrelationIDProperty = "correlation_id"
+
+	// metricData is not sampled by the percentage, since sampled metric values can't be extrapolated like counts can
+	metricData = "MetricData"
+)
+
+var (
+	randomMu sync.Mutex
+	random   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// New creates a new sampler, validating the percentages
+func New(percentage float64, typeRates map[string]float64, keepErrors bool) (*Sampler, error) {
+	if err := ValidPercentage(percentage); err != nil {
+		return nil, err
+	}
+
+	for t, rate := range typeRates {
+		if err := ValidPercentage(rate); err != nil {
+			return nil, fmt.Errorf("%s: %w", t, err)
+		}
+	}
+
+	return &Sampler{
+		Percentage: percentage,
+		TypeRates:  typeRates,
+		KeepErrors: keepErrors,
+	}, nil
+}
+
+// Sample returns the rate, as a percentage, at which the item is sampled and whether it should be kept
+func (s *Sampler) Sample(item apmz.Telemetry) (float64, bool) {
+	data := item.TelemetryData()
+	rate := s.dataRate(data.BaseType(), data)
+	if rate >= 100 {
+		return 100, true
+	}
+
+	id := correlationID(item)
+	if id == "" {
+		// an uncorrelated item has no run to keep together, and hashing the empty id would decide for every item alike
+		return rate, randomScore() < rate
+	}
+	return rate, score(id) < rate
+}
+
+// Rate returns the rate, as a percentage, at which the item of the envelope was sampled, so Application Insights can
+// extrapolate counts from the items which were kept
+func (s *Sampler) Rate(envelope *contracts.Envelope) float64 {
+	data, ok := envelope.Data.(*contracts.Data)
+	if !ok {
+		return 100
+	}
+	return s.dataRate(data.BaseType, data.BaseData)
+}
+
+func (s *Sampler) dataRate(baseType string, baseData interface{}) float64 {
+	rate := s.rate(baseType)
+	if rate >= 100 || (s.KeepErrors && isError(baseData)) {
+		return 100
+	}
+	return rate
+}
+
+func (s *Sampler) rate(baseType string) float64 {
+	if rate, ok := s.TypeRates[baseType]; ok {
+		return rate
+	}
+
+	if baseType == metricData {
+		return 100
+	}
+	return s.Percentage
+}
+
+// score hashes the correlation id into the range [0, 100)
+func score(id string) float64 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return float64(h.Sum32()%10000) / 100
+}
+
+// randomScore draws a score in the range [0, 100)
+func randomScore() float64 {
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	return random.Float64() * 100
+}
+
+// correlationID prefers the bash helpers' correlation id property and falls back to the operation id; it's empty when
+// the item has neither
+func correlationID(item apmz.Telemetry) string {
+	if id := item.GetProperties()[CorrelationIDProperty]; id != "" {
+		return id
+	}
+	return item.ContextTags()[contracts.OperationId]
+}
+
+func isError(baseData interface{}) bool {
+	switch d := baseData.(type) {
+	case *contracts.MessageData:
+		return d.SeverityLevel >= contracts.Error
+	case *contracts.ExceptionData:
+		return true
+	case *contracts.RequestData:
+		return !d.Success
+	case *contracts.RemoteDependencyData:
+		return !d.Success
+	case *contracts.AvailabilityData:
+		return !d.Success
+	default:
+		return false
+	}
+}
+
+// ValidPercentage checks that a sample rate is a percentage, between 0 and 100
+func ValidPercentage(percentage float64) error {
+	if percentage < 0 || percentage > 100 {
+		return fmt.Errorf("sample rate %v must be between 0 and 100", percentage)
+	}
+	return nil
+}
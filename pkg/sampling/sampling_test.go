@@ -0,0 +1,166 @@
+package sampling
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/telemetry"
+)
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name       string
+		percentage float64
+		typeRates  map[string]float64
+		err        string
+	}{
+		{name: "Valid", percentage: 10, typeRates: map[string]float64{"MessageData": 1, "MetricData": 50}},
+		{name: "PercentageOutOfRange", percentage: 101, err: "sample rate 101 must be between 0 and 100"},
+		{name: "TypeRateOutOfRange", percentage: 10, typeRates: map[string]float64{"MessageData": -1}, err: "MessageData: sample rate -1 must be between 0 and 100"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := New(c.percentage, c.typeRates, true)
+			if c.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.percentage, s.Percentage)
+		})
+	}
+}
+
+func TestSamplerSample(t *testing.T) {
+	cases := []struct {
+		name    string
+		sampler Sampler
+		item    apmz.Telemetry
+		rate    float64
+		keep    bool
+	}{
+		{
+			name:    "NoneKept",
+			sampler: Sampler{Percentage: 0},
+			item:    correlated(apmz.NewTraceTelemetry("msg", contracts.Information), "run"),
+			rate:    0,
+			keep:    false,
+		},
+		{
+			name:    "KeepErrorTrace",
+			sampler: Sampler{Percentage: 0, KeepErrors: true},
+			item:    correlated(apmz.NewTraceTelemetry("msg", contracts.Error), "run"),
+			rate:    100,
+			keep:    true,
+		},
+		{
+			name:    "KeepFailedDependency",
+			sampler: Sampler{Percentage: 0, KeepErrors: true},
+			item:    correlated(apmz.NewRemoteDependencyTelemetry("dep", "http", "target", false), "run"),
+			rate:    100,
+			keep:    true,
+		},
+		{
+			name:    "ErrorsNotKept",
+			sampler: Sampler{Percentage: 0},
+			item:    correlated(telemetry.NewExceptionTelemetry("ScriptError", "boom"), "run"),
+			rate:    0,
+			keep:    false,
+		},
+		{
+			name:    "MetricsNotSampledByPercentage",
+			sampler: Sampler{Percentage: 0},
+			item:    correlated(apmz.NewMetricTelemetry("metric", 1), "run"),
+			rate:    100,
+			keep:    true,
+		},
+		{
+			name:    "TypeRateOverridesPercentage",
+			sampler: Sampler{Percentage: 100, TypeRates: map[string]float64{"EventData": 0}},
+			item:    correlated(apmz.NewEventTelemetry("event"), "run"),
+			rate:    0,
+			keep:    false,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			rate, keep := c.sampler.Sample(c.item)
+			assert.Equal(t, c.rate, rate)
+			assert.Equal(t, c.keep, keep)
+		})
+	}
+}
+
+func TestSamplerSampleIsConsistentPerCorrelationID(t *testing.T) {
+	s := Sampler{Percentage: 50}
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("run-%d", i)
+		_, traceKept := s.Sample(correlated(apmz.NewTraceTelemetry("msg", contracts.Information), id))
+		_, requestKept := s.Sample(correlated(apmz.NewRequestTelemetry("GET", "https://example.com", time.Second, "200"), id))
+		require.Equal(t, traceKept, requestKept, "items of run %s should have the same sampling decision", id)
+		if traceKept {
+			kept++
+		}
+	}
+
+	assert.InDelta(t, 500, kept, 100)
+}
+
+func TestSamplerSampleWithoutCorrelationID(t *testing.T) {
+	s := Sampler{Percentage: 50}
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		if _, keep := s.Sample(apmz.NewEventTelemetry("event")); keep {
+			kept++
+		}
+	}
+
+	assert.InDelta(t, 500, kept, 100)
+}
+
+func TestSamplerRate(t *testing.T) {
+	s := Sampler{Percentage: 10, TypeRates: map[string]float64{"EventData": 50}, KeepErrors: true}
+	cases := map[string]struct {
+		item apmz.Telemetry
+		rate float64
+	}{
+		"Trace":      {item: apmz.NewTraceTelemetry("msg", contracts.Information), rate: 10},
+		"ErrorTrace": {item: apmz.NewTraceTelemetry("msg", contracts.Error), rate: 100},
+		"Event":      {item: apmz.NewEventTelemetry("event"), rate: 50},
+		"Metric":     {item: apmz.NewMetricTelemetry("metric", 1), rate: 100},
+	}
+
+	for name, c := range cases {
+		data := contracts.NewData()
+		data.BaseType = c.item.TelemetryData().BaseType()
+		data.BaseData = c.item.TelemetryData()
+		envelope := contracts.NewEnvelope()
+		envelope.Data = data
+		assert.Equal(t, c.rate, s.Rate(envelope), name)
+	}
+	assert.Equal(t, float64(100), s.Rate(contracts.NewEnvelope()))
+}
+
+// correlated correlates traces by the bash helpers' property and everything else by operation id
+func correlated(item apmz.Telemetry, correlationID string) apmz.Telemetry {
+	if _, ok := item.(*apmz.TraceTelemetry); ok {
+		item.GetProperties()[CorrelationIDProperty] = correlationID
+	} else {
+		item.ContextTags()[contracts.OperationId] = correlationID
+	}
+	return item
+}
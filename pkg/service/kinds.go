@@ -0,0 +1,199 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/devigned/apmz-sdk/apmz"
+
+	"github.com/devigned/apmz/pkg/telemetry"
+)
+
+type (
+	// TelemetryKind describes a kind of telemetry which can be written to and read from a batch of events
+	TelemetryKind struct {
+		// Type is the event type of the telemetry
+		Type EventType
+		// Version is the version of the serialized form of the telemetry item
+		Version int
+		// Aliases are other discriminators which should be read as this kind, such as the reflect derived type names
+		// written by earlier versions of apmz; eg "TraceTelemetry"
+		Aliases []string
+		// New returns a new, empty telemetry item of this kind to unmarshal into
+		New func() apmz.Telemetry
+	}
+
+	// TelemetryKindRegistry holds the telemetry kinds keyed by their discriminators, Go types and the base types of
+	// their data
+	TelemetryKindRegistry struct {
+		mu            sync.RWMutex
+		discriminated map[string]TelemetryKind
+		typed         map[reflect.Type]TelemetryKind
+		based         map[string]TelemetryKind
+	}
+
+	// UnknownKindError is returned when an event discriminator is not registered
+	UnknownKindError struct {
+		Type string
+	}
+)
+
+var (
+	// DefaultTelemetryKinds is the registry used to marshal and unmarshal batch events
+	DefaultTelemetryKinds = NewTelemetryKindRegistry()
+)
+
+func init() {
+	kinds := []TelemetryKind{
+		{Type: Trace, Version: 1, Aliases: []string{"TraceTelemetry"}, New: func() apmz.Telemetry { return &apmz.TraceTelemetry{} }},
+		{Type: Metric, Version: 1, Aliases: []string{"MetricTelemetry"}, New: func() apmz.Telemetry { return &apmz.MetricTelemetry{} }},
+		{Type: CustomEvent, Version: 1, Aliases: []string{"EventTelemetry"}, New: func() apmz.Telemetry { return &apmz.EventTelemetry{} }},
+		{Type: Dependency, Version: 1, Aliases: []string{"RemoteDependencyTelemetry"}, New: func() apmz.Telemetry { return &apmz.RemoteDependencyTelemetry{} }},
+		{Type: Request, Version: 1, Aliases: []string{"RequestTelemetry"}, New: func() apmz.Telemetry { return &apmz.RequestTelemetry{} }},
+		{Type: Exception, Version: 1, Aliases: []string{"ExceptionTelemetry"}, New: func() apmz.Telemetry { return &telemetry.ExceptionTelemetry{} }},
+		{Type: Availability, Version: 1, Aliases: []string{"AvailabilityTelemetry"}, New: func() apmz.Telemetry { return &apmz.AvailabilityTelemetry{} }},
+	}
+
+	for _, kind := range kinds {
+		if err := DefaultTelemetryKinds.Register(kind); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterTelemetryKind adds a kind of telemetry to the DefaultTelemetryKinds
+func RegisterTelemetryKind(kind TelemetryKind) error {
+	return DefaultTelemetryKinds.Register(kind)
+}
+
+// NewTelemetryKindRegistry creates a new, empty registry of telemetry kinds
+func NewTelemetryKindRegistry() *TelemetryKindRegistry {
+	return &TelemetryKindRegistry{
+		discriminated: make(map[string]TelemetryKind),
+		typed:         make(map[reflect.Type]TelemetryKind),
+		based:         make(map[string]TelemetryKind),
+	}
+}
+
+// Discriminator is the stable, versioned name of the kind written to batch events; eg "trace/v1"
+func (kind TelemetryKind) Discriminator() string {
+	return fmt.Sprintf("%s/v%d", kind.Type, kind.Version)
+}
+
+// BaseType is the base type of the kind's data in an Application Insights envelope; eg "MessageData" for traces
+func (kind TelemetryKind) BaseType() string {
+	return kind.New().TelemetryData().BaseType()
+}
+
+// Register adds a kind of telemetry to the registry. Registering a discriminator or alias which is already registered
+// is an error.
+func (r *TelemetryKindRegistry) Register(kind TelemetryKind) error {
+	if kind.Type == "" || kind.Version < 1 || kind.New == nil {
+		return fmt.Errorf("telemetry kind requires a type, a version greater than 0 and a constructor: %+v", kind)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := append([]string{kind.Discriminator()}, kind.Aliases...)
+	for _, name := range names {
+		if _, ok := r.discriminated[name]; ok {
+			return fmt.Errorf("telemetry kind %q is already registered", name)
+		}
+	}
+
+	for _, name := range names {
+		r.discriminated[name] = kind
+	}
+
+	// the latest version of a Go type is used when writing events
+	t := reflect.TypeOf(kind.New())
+	if existing, ok := r.typed[t]; !ok || existing.Version < kind.Version {
+		r.typed[t] = kind
+	}
+
+	if existing, ok := r.based[kind.BaseType()]; !ok || existing.Version < kind.Version {
+		r.based[kind.BaseType()] = kind
+	}
+	return nil
+}
+
+// Lookup finds the kind of telemetry for a discriminator or alias
+func (r *TelemetryKindRegistry) Lookup(discriminator string) (TelemetryKind, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kind, ok := r.discriminated[discriminator]
+	return kind, ok
+}
+
+// KindOf finds the kind of telemetry for a telemetry item
+func (r *TelemetryKindRegistry) KindOf(item apmz.Telemetry) (TelemetryKind, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kind, ok := r.typed[reflect.TypeOf(item)]
+	return kind, ok
+}
+
+// KindOfData finds the latest kind of telemetry for the base type of an envelope's data; eg "MessageData"
+func (r *TelemetryKindRegistry) KindOfData(baseType string) (TelemetryKind, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kind, ok := r.based[baseType]
+	return kind, ok
+}
+
+// KindOfType finds the latest kind of telemetry for an event type; eg "trace"
+func (r *TelemetryKindRegistry) KindOfType(eventType EventType) (TelemetryKind, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, kind := range r.based {
+		if kind.Type == eventType {
+			return kind, true
+		}
+	}
+	return TelemetryKind{}, false
+}
+
+// TypeNames returns the event types of the registered kinds in sorted order
+func (r *TelemetryKindRegistry) TypeNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.based))
+	for _, kind := range r.based {
+		names = append(names, string(kind.Type))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEvent wraps a telemetry item in an event using the discriminator of its kind. Items which have not been
+// registered fall back to the reflect derived type name.
+func (r *TelemetryKindRegistry) NewEvent(item apmz.Telemetry) Event {
+	if kind, ok := r.KindOf(item); ok {
+		return Event{
+			Type: kind.Discriminator(),
+			Item: item,
+		}
+	}
+
+	t := reflect.TypeOf(item)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return Event{
+		Type: t.Name(),
+		Item: item,
+	}
+}
+
+func (e UnknownKindError) Error() string {
+	return fmt.Sprintf("don't know how to unmarshal type: %v", e.Type)
+}
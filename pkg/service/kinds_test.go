@@ -0,0 +1,98 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/telemetry"
+)
+
+func TestEventRoundTrip(t *testing.T) {
+	cases := []struct {
+		name          string
+		item          apmz.Telemetry
+		discriminator string
+	}{
+		{name: "Trace", item: apmz.NewTraceTelemetry("foo", contracts.Warning), discriminator: "trace/v1"},
+		{name: "Metric", item: apmz.NewMetricTelemetry("foo", 1.5), discriminator: "metric/v1"},
+		{name: "Event", item: apmz.NewEventTelemetry("foo"), discriminator: "event/v1"},
+		{name: "Dependency", item: apmz.NewRemoteDependencyTelemetry("foo", "HTTP", "bar", true), discriminator: "dependency/v1"},
+		{name: "Request", item: apmz.NewRequestTelemetry("GET", "http://foo", 0, "200"), discriminator: "request/v1"},
+		{name: "Exception", item: telemetry.NewExceptionTelemetry("ScriptError", "foo"), discriminator: "exception/v1"},
+		{name: "Availability", item: apmz.NewAvailabilityTelemetry("foo", 0, true), discriminator: "availability/v1"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			evt := DefaultTelemetryKinds.NewEvent(c.item)
+			assert.Equal(t, c.discriminator, evt.Type)
+
+			bits, err := json.Marshal(evt)
+			require.NoError(t, err)
+
+			var actual Event
+			require.NoError(t, json.Unmarshal(bits, &actual))
+			assert.Equal(t, c.discriminator, actual.Type)
+			assert.IsType(t, c.item, actual.Item)
+		})
+	}
+}
+
+func TestEventUnmarshalLegacyTypeNames(t *testing.T) {
+	legacy := `{"type":"TraceTelemetry","item":{"Properties":{"code":"0"},"Message":"script-exit","SeverityLevel":1}}`
+	var evt Event
+	require.NoError(t, json.Unmarshal([]byte(legacy), &evt))
+	trace, ok := evt.Item.(*apmz.TraceTelemetry)
+	require.True(t, ok)
+	assert.Equal(t, "script-exit", trace.Message)
+	assert.Equal(t, contracts.Information, trace.SeverityLevel)
+}
+
+func TestEventUnmarshalUnknownType(t *testing.T) {
+	var evt Event
+	err := json.Unmarshal([]byte(`{"type":"pageview/v1","item":{}}`), &evt)
+	var unknown UnknownKindError
+	if assert.True(t, errors.As(err, &unknown)) {
+		assert.Equal(t, "pageview/v1", unknown.Type)
+	}
+}
+
+func TestTelemetryKindRegistryRegister(t *testing.T) {
+	r := NewTelemetryKindRegistry()
+	newTrace := func() apmz.Telemetry { return &apmz.TraceTelemetry{} }
+	require.NoError(t, r.Register(TelemetryKind{Type: Trace, Version: 1, Aliases: []string{"TraceTelemetry"}, New: newTrace}))
+	require.NoError(t, r.Register(TelemetryKind{Type: Trace, Version: 2, New: newTrace}))
+
+	assert.Error(t, r.Register(TelemetryKind{Type: Trace, Version: 2, New: newTrace}), "duplicate discriminator")
+	assert.Error(t, r.Register(TelemetryKind{Type: Metric, Version: 1, Aliases: []string{"TraceTelemetry"}, New: newTrace}), "duplicate alias")
+	assert.Error(t, r.Register(TelemetryKind{Type: Metric, Version: 1}), "missing constructor")
+
+	kind, ok := r.Lookup("TraceTelemetry")
+	require.True(t, ok)
+	assert.Equal(t, "trace/v1", kind.Discriminator())
+	assert.Equal(t, "trace/v2", r.NewEvent(apmz.NewTraceTelemetry("foo", contracts.Verbose)).Type)
+}
+
+func TestTelemetryKindRegistryTypes(t *testing.T) {
+	assert.Equal(t, []string{"availability", "dependency", "event", "exception", "metric", "request", "trace"}, DefaultTelemetryKinds.TypeNames())
+
+	kind, ok := DefaultTelemetryKinds.KindOfData("MessageData")
+	require.True(t, ok)
+	assert.Equal(t, Trace, kind.Type)
+
+	kind, ok = DefaultTelemetryKinds.KindOfType(Dependency)
+	require.True(t, ok)
+	assert.Equal(t, "RemoteDependencyData", kind.BaseType())
+
+	_, ok = DefaultTelemetryKinds.KindOfData("PageViewData")
+	assert.False(t, ok)
+	_, ok = DefaultTelemetryKinds.KindOfType("pageview")
+	assert.False(t, ok)
+}
@@ -4,14 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"reflect"
 	"sync"
 	"time"
 
 	"github.com/devigned/apmz-sdk/apmz"
 
 	"github.com/devigned/apmz/pkg/azmeta"
+	"github.com/devigned/apmz/pkg/channel"
 	"github.com/devigned/apmz/pkg/format"
+	"github.com/devigned/apmz/pkg/sampling"
+	"github.com/devigned/apmz/pkg/spool"
+	"github.com/devigned/apmz/pkg/xcobra"
 )
 
 type (
@@ -21,6 +24,7 @@ type (
 		PrinterFactory  func() format.Printer
 		APIKeysFactory  func() []string
 		MetadataFactory func() (Metadater, error)
+		SpoolFactory    func() (*spool.Spool, error)
 	}
 
 	// CommandServicer provides all functionality needed for command execution
@@ -29,6 +33,7 @@ type (
 		GetAPMer() (APMer, error)
 		GetPrinter() format.Printer
 		GetKeys() []string
+		GetSpool() (*spool.Spool, error)
 	}
 
 	// Metadater abstracts the underlying implementation of the instance metadata service
@@ -43,13 +48,34 @@ type (
 	// APMer provides the behaviors needed to send events to Azure Application Insights
 	APMer interface {
 		Track(telemetry apmz.Telemetry)
-		Close(ctx context.Context)
+		Close(ctx context.Context) []DeliveryResult
+	}
+
+	// DeliveryResult is the outcome of sending telemetry to a single destination
+	DeliveryResult struct {
+		// Key identifies the destination, such as an instrumentation key or an OTLP endpoint
+		Key string
+		channel.Report
 	}
 
 	// APMZProxy will proxy calls to the APMZ client or print if running locally
 	APMZProxy struct {
 		Printer format.Printer
 		Clients []apmz.TelemetryClient
+		// Sinks are destinations other than Application Insights, such as an OpenTelemetry collector, which also
+		// receive every item
+		Sinks        []APMer
+		FlushTimeout time.Duration
+		// Tags are context tags, such as the operation id, applied to every item which doesn't set them itself
+		Tags map[string]string
+		// Properties are custom properties applied to every item which doesn't set them itself
+		Properties map[string]string
+		// Sampler, if set, decides which items are sent, before they're sent to every destination, so each destination
+		// is sent the same sample
+		Sampler *sampling.Sampler
+
+		mu         sync.Mutex
+		sampledOut int
 	}
 
 	// EventType represents the enumeration of all the event types the Batch command understands
@@ -67,6 +93,19 @@ const (
 	Trace EventType = "trace"
 	// Metric is a "customMetrics" event type in Application Insights
 	Metric EventType = "metric"
+	// CustomEvent is a "customEvents" event type in Application Insights
+	CustomEvent EventType = "event"
+	// Dependency is a "dependencies" event type in Application Insights
+	Dependency EventType = "dependency"
+	// Request is a "requests" event type in Application Insights
+	Request EventType = "request"
+	// Exception is an "exceptions" event type in Application Insights
+	Exception EventType = "exception"
+	// Availability is an "availabilityResults" event type in Application Insights
+	Availability EventType = "availability"
+
+	// UndeliveredExitCode is the exit code used when telemetry was not accepted by every destination
+	UndeliveredExitCode = 4
 )
 
 // GetAPMer returns an instance of an Azure Application Insights client
@@ -89,36 +128,80 @@ func (r *Registry) GetKeys() []string {
 	return r.APIKeysFactory()
 }
 
+// GetSpool will return the spool for telemetry which could not be delivered
+func (r *Registry) GetSpool() (*spool.Spool, error) {
+	return r.SpoolFactory()
+}
+
 // Track will either send to the client or print depending if the proxy printer is set
-func (apmzp APMZProxy) Track(item apmz.Telemetry) {
-	if apmzp.Printer != nil {
-		t := reflect.TypeOf(item)
-		if t.Kind() == reflect.Ptr {
-			t = t.Elem()
-		}
+func (apmzp *APMZProxy) Track(item apmz.Telemetry) {
+	setMissing(item.ContextTags(), apmzp.Tags)
+	setMissing(item.GetProperties(), apmzp.Properties)
 
-		evt := Event{
-			Type: t.Name(),
-			Item: item,
-		}
+	if apmzp.Printer != nil {
+		// printed events are sampled when they're sent by batch
+		evt := DefaultTelemetryKinds.NewEvent(item)
 		_ = apmzp.Printer.Print(evt)
 		return
 	}
 
+	if apmzp.Sampler != nil {
+		if _, keep := apmzp.Sampler.Sample(item); !keep {
+			apmzp.mu.Lock()
+			apmzp.sampledOut++
+			apmzp.mu.Unlock()
+			return
+		}
+	}
+
 	for _, client := range apmzp.Clients {
 		client.Track(item)
 	}
+
+	for _, sink := range apmzp.Sinks {
+		sink.Track(item)
+	}
 }
 
-// Close will flush and close the underlying App Insights clients
-func (apmzp APMZProxy) Close(ctx context.Context) {
+// Close will flush and close the underlying App Insights clients and sinks and return the delivery result for each.
+// Failed sends are retried until the flush timeout; without one, each send is tried once. Destinations are closed
+// independently, so a failing destination doesn't hold up the others.
+func (apmzp *APMZProxy) Close(ctx context.Context) []DeliveryResult {
+	if apmzp.Printer != nil {
+		return nil
+	}
+
+	sinkCtx := context.Background()
+	if apmzp.FlushTimeout > 0 {
+		var cancel context.CancelFunc
+		sinkCtx, cancel = context.WithTimeout(sinkCtx, apmzp.FlushTimeout)
+		defer cancel()
+	}
+
+	var mu sync.Mutex
+	sinkResults := make([][]DeliveryResult, len(apmzp.Sinks))
 	done := make(chan struct{})
 	wg := sync.WaitGroup{}
-	wg.Add(len(apmzp.Clients))
+	wg.Add(len(apmzp.Clients) + len(apmzp.Sinks))
+	for i, sink := range apmzp.Sinks {
+		i, s := i, sink
+		go func() {
+			res := s.Close(sinkCtx)
+			mu.Lock()
+			sinkResults[i] = res
+			mu.Unlock()
+			wg.Done()
+		}()
+	}
+
 	for _, client := range apmzp.Clients {
 		c := client
 		go func() {
-			c.Channel().Close(30 * time.Second)
+			if apmzp.FlushTimeout > 0 {
+				<-c.Channel().Close(apmzp.FlushTimeout)
+			} else {
+				<-c.Channel().Close()
+			}
 			wg.Done()
 		}()
 	}
@@ -135,7 +218,76 @@ func (apmzp APMZProxy) Close(ctx context.Context) {
 	case <-ctx.Done():
 	}
 
-	return
+	var results []DeliveryResult
+	for _, client := range apmzp.Clients {
+		if reporter, ok := client.Channel().(interface{ Report() channel.Report }); ok {
+			results = append(results, DeliveryResult{
+				Key:    client.InstrumentationKey(),
+				Report: reporter.Report(),
+			})
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, res := range sinkResults {
+		results = append(results, res...)
+	}
+
+	apmzp.mu.Lock()
+	defer apmzp.mu.Unlock()
+	for i := range results {
+		results[i].SampledOut += apmzp.sampledOut
+	}
+	return results
+}
+
+// Delivered is true if every item sent was accepted
+func (dr DeliveryResult) Delivered() bool {
+	return dr.Sent == dr.Accepted
+}
+
+// ReportDelivery prints the delivery result for each destination and returns an error with UndeliveredExitCode if
+// any telemetry was not accepted
+func ReportDelivery(printer format.Printer, results []DeliveryResult) error {
+	undelivered := 0
+	for _, r := range results {
+		summary := fmt.Sprintf("%s: %d sent, %d accepted, %d rejected, %d retried, %d dropped, %d spooled",
+			r.Key, r.Sent, r.Accepted, r.Rejected, r.Retried, r.Dropped, r.Spooled)
+		if r.SampledOut > 0 {
+			summary += fmt.Sprintf(", %d sampled out", r.SampledOut)
+		}
+		if unconfirmed := r.Unconfirmed(); unconfirmed > 0 {
+			summary += fmt.Sprintf(", %d unconfirmed", unconfirmed)
+		}
+		printer.ErrPrintf("%s\n", summary)
+
+		for _, reason := range r.Reasons {
+			printer.ErrPrintf("%s: %s\n", r.Key, reason)
+		}
+
+		if !r.Delivered() {
+			undelivered++
+		}
+	}
+
+	if undelivered > 0 {
+		return xcobra.ErrorWithCode{Code: UndeliveredExitCode}
+	}
+	return nil
+}
+
+// setMissing copies the values which aren't already in the destination; a nil destination is left alone
+func setMissing(dst, values map[string]string) {
+	if dst == nil {
+		return
+	}
+
+	for k, v := range values {
+		if _, ok := dst[k]; !ok {
+			dst[k] = v
+		}
+	}
 }
 
 // UnmarshalJSON takes json bytes and turns them into an event
@@ -150,24 +302,20 @@ func (evt *Event) UnmarshalJSON(b []byte) error {
 	}
 
 	evt.Type = tmp.Type
-	var telemetry apmz.Telemetry
-	switch evt.Type {
-	case "TraceTelemetry":
-		tt := &apmz.TraceTelemetry{}
-		if err := json.Unmarshal(*tmp.Item, tt); err != nil {
-			return err
-		}
-		telemetry = tt
-	case "MetricTelemetry":
-		mt := &apmz.MetricTelemetry{}
-		if err := json.Unmarshal(*tmp.Item, mt); err != nil {
-			return err
-		}
-		telemetry = mt
-	default:
-		return fmt.Errorf("don't know how to unmarshal type: %v", evt.Type)
+	kind, ok := DefaultTelemetryKinds.Lookup(evt.Type)
+	if !ok {
+		return UnknownKindError{Type: evt.Type}
+	}
+
+	if tmp.Item == nil {
+		return fmt.Errorf("event of type %v does not have an item", evt.Type)
+	}
+
+	item := kind.New()
+	if err := json.Unmarshal(*tmp.Item, item); err != nil {
+		return err
 	}
 
-	evt.Item = telemetry
+	evt.Item = item
 	return nil
 }
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/sampling"
+	"github.com/devigned/apmz/pkg/xcobra"
+)
+
+type capturePrinter struct {
+	errLines []string
+}
+
+func (cp *capturePrinter) Print(obj interface{}) error {
+	return nil
+}
+
+func (cp *capturePrinter) Printf(format string, args ...interface{}) {}
+
+func (cp *capturePrinter) ErrPrintf(format string, args ...interface{}) {
+	cp.errLines = append(cp.errLines, fmt.Sprintf(format, args...))
+}
+
+type fakeSink struct {
+	key      string
+	tracked  int
+	deadline bool
+}
+
+func (fs *fakeSink) Track(item apmz.Telemetry) {
+	fs.tracked++
+}
+
+func (fs *fakeSink) Close(ctx context.Context) []DeliveryResult {
+	_, fs.deadline = ctx.Deadline()
+	return []DeliveryResult{{Key: fs.key, Report: channel.Report{Sent: fs.tracked, Accepted: fs.tracked}}}
+}
+
+func TestAPMZProxyCloseIsolatesClients(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"itemsReceived":1,"itemsAccepted":1,"errors":[]}`))
+	}))
+	defer ok.Close()
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"itemsReceived":1,"itemsAccepted":0,"errors":[{"index":0,"statusCode":400,"message":"invalid key"}]}`))
+	}))
+	defer bad.Close()
+
+	proxy := APMZProxy{
+		Clients: []apmz.TelemetryClient{
+			newTestClient(t, "good", ok.URL),
+			newTestClient(t, "bad", bad.URL),
+		},
+	}
+	proxy.Track(apmz.NewTraceTelemetry("foo", contracts.Information))
+	results := proxy.Close(context.Background())
+	require.Len(t, results, 2)
+	assert.Equal(t, DeliveryResult{Key: "good", Report: channel.Report{Sent: 1, Accepted: 1}}, results[0])
+	assert.Equal(t, DeliveryResult{Key: "bad", Report: channel.Report{Sent: 1, Rejected: 1, Reasons: []string{"400 invalid key"}}}, results[1])
+
+	p := new(capturePrinter)
+	err := ReportDelivery(p, results)
+	var ewc xcobra.ErrorWithCode
+	if assert.True(t, errors.As(err, &ewc)) {
+		assert.Equal(t, UndeliveredExitCode, ewc.Code)
+	}
+	assert.Equal(t, []string{
+		"good: 1 sent, 1 accepted, 0 rejected, 0 retried, 0 dropped, 0 spooled\n",
+		"bad: 1 sent, 0 accepted, 1 rejected, 0 retried, 0 dropped, 0 spooled\n",
+		"bad: 400 invalid key\n",
+	}, p.errLines)
+}
+
+func TestAPMZProxySendsToSinks(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"itemsReceived":1,"itemsAccepted":1,"errors":[]}`))
+	}))
+	defer ok.Close()
+
+	sink := &fakeSink{key: "http://localhost:4318"}
+	proxy := APMZProxy{
+		Clients:      []apmz.TelemetryClient{newTestClient(t, "good", ok.URL)},
+		Sinks:        []APMer{sink},
+		FlushTimeout: time.Second,
+	}
+	proxy.Track(apmz.NewTraceTelemetry("foo", contracts.Information))
+	results := proxy.Close(context.Background())
+	assert.Equal(t, []DeliveryResult{
+		{Key: "good", Report: channel.Report{Sent: 1, Accepted: 1}},
+		{Key: "http://localhost:4318", Report: channel.Report{Sent: 1, Accepted: 1}},
+	}, results)
+	assert.True(t, sink.deadline, "sinks should be bounded by the flush timeout")
+}
+
+func TestAPMZProxySamplesBeforeEveryDestination(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"itemsReceived":1,"itemsAccepted":1,"errors":[]}`))
+	}))
+	defer ok.Close()
+
+	sampler, err := sampling.New(0, nil, true)
+	require.NoError(t, err)
+
+	sink := &fakeSink{key: "http://localhost:4318"}
+	proxy := APMZProxy{
+		Clients: []apmz.TelemetryClient{newTestClient(t, "good", ok.URL)},
+		Sinks:   []APMer{sink},
+		Sampler: sampler,
+	}
+	proxy.Track(apmz.NewTraceTelemetry("sampled out", contracts.Information))
+	proxy.Track(apmz.NewTraceTelemetry("kept", contracts.Error))
+	results := proxy.Close(context.Background())
+	assert.Equal(t, []DeliveryResult{
+		{Key: "good", Report: channel.Report{Sent: 1, SampledOut: 1, Accepted: 1}},
+		{Key: "http://localhost:4318", Report: channel.Report{Sent: 1, SampledOut: 1, Accepted: 1}},
+	}, results)
+}
+
+func TestAPMZProxyTrackAppliesTagsAndProperties(t *testing.T) {
+	proxy := APMZProxy{
+		Printer:    new(capturePrinter),
+		Tags:       map[string]string{contracts.OperationId: "session", contracts.CloudRole: "myscript"},
+		Properties: map[string]string{"azure.vmId": "vm-id", "foo": "default"},
+	}
+
+	trace := apmz.NewTraceTelemetry("foo", contracts.Information)
+	req := apmz.NewRequestTelemetry("GET", "http://foo", 0, "200")
+	req.Tags.Operation().SetId("own")
+	req.Properties["foo"] = "own"
+	proxy.Track(trace)
+	proxy.Track(req)
+
+	assert.Equal(t, "session", trace.Tags[contracts.OperationId])
+	assert.Equal(t, "myscript", trace.Tags[contracts.CloudRole])
+	assert.Equal(t, "own", req.Tags[contracts.OperationId])
+	assert.Equal(t, "myscript", req.Tags[contracts.CloudRole])
+	assert.Equal(t, map[string]string{"azure.vmId": "vm-id", "foo": "default"}, trace.Properties)
+	assert.Equal(t, map[string]string{"azure.vmId": "vm-id", "foo": "own"}, req.Properties)
+}
+
+func TestReportDeliveryAllAccepted(t *testing.T) {
+	p := new(capturePrinter)
+	err := ReportDelivery(p, []DeliveryResult{{Key: "good", Report: channel.Report{Sent: 2, Accepted: 2}}})
+	assert.NoError(t, err)
+	assert.Len(t, p.errLines, 1)
+}
+
+func newTestClient(t *testing.T, key, endpoint string) apmz.TelemetryClient {
+	ch, err := channel.New(endpoint)
+	require.NoError(t, err)
+
+	client := apmz.NewTelemetryClient(key)
+	client.Channel().Stop()
+	client.SetChannel(ch)
+	return client
+}
@@ -0,0 +1,237 @@
+// Package spool provides a durable, on-disk store for telemetry which could not be delivered, so it can be sent
+// later with `apmz flush`.
+package spool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/devigned/apmz/internal/atomicfile"
+)
+
+type (
+	// Spool is a directory of items which could not be delivered
+	Spool struct {
+		Dir      string
+		MaxBytes int64
+		MaxAge   time.Duration
+	}
+
+	// Item is a set of envelopes which could not be delivered to an endpoint
+	Item struct {
+		Endpoint  string            `json:"endpoint"`
+		Created   time.Time         `json:"created"`
+		Attempts  int               `json:"attempts"`
+		Envelopes []json.RawMessage `json:"envelopes"`
+	}
+
+	// Entry is a reference to an item in the spool
+	Entry struct {
+		Path    string
+		Size    int64
+		Created time.Time
+	}
+
+	// Option is a variadic optional configuration func
+	Option func(s *Spool) error
+)
+
+const (
+	// DefaultMaxBytes is the default cap on the total size of the spool
+	DefaultMaxBytes int64 = 100 * 1024 * 1024
+
+	// DefaultMaxAge is the default age after which spooled items expire
+	DefaultMaxAge = 7 * 24 * time.Hour
+
+	itemExt = ".json"
+)
+
+// New creates a new spool in the directory
+func New(dir string, opts ...Option) (*Spool, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("spool directory must be specified")
+	}
+
+	s := &Spool{
+		Dir:      dir,
+		MaxBytes: DefaultMaxBytes,
+		MaxAge:   DefaultMaxAge,
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// WithMaxBytes caps the total size of the spool; the oldest items are removed first. A cap of 0 disables the cap.
+func WithMaxBytes(max int64) Option {
+	return func(s *Spool) error {
+		if max < 0 {
+			return fmt.Errorf("spool max bytes must not be negative")
+		}
+		s.MaxBytes = max
+		return nil
+	}
+}
+
+// WithMaxAge expires items older than the max age. An age of 0 disables expiration.
+func WithMaxAge(age time.Duration) Option {
+	return func(s *Spool) error {
+		if age < 0 {
+			return fmt.Errorf("spool max age must not be negative")
+		}
+		s.MaxAge = age
+		return nil
+	}
+}
+
+// Write persists an item to the spool and then trims the spool to its size and age limits
+func (s *Spool) Write(item Item) error {
+	if item.Created.IsZero() {
+		item.Created = time.Now()
+	}
+
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%s%s", item.Created.UnixNano(), uuid.New().String(), itemExt)
+	if err := s.writeFile(filepath.Join(s.Dir, name), item); err != nil {
+		return err
+	}
+
+	_, err := s.Trim()
+	return err
+}
+
+// Update rewrites an existing item in the spool
+func (s *Spool) Update(entry Entry, item Item) error {
+	return s.writeFile(entry.Path, item)
+}
+
+// Read loads the item for an entry
+func (s *Spool) Read(entry Entry) (*Item, error) {
+	bits, err := ioutil.ReadFile(entry.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var item Item
+	if err := json.Unmarshal(bits, &item); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal spooled item %s: %w", entry.Path, err)
+	}
+	return &item, nil
+}
+
+// Remove deletes the item for an entry from the spool
+func (s *Spool) Remove(entry Entry) error {
+	err := os.Remove(entry.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Entries lists the items in the spool, oldest first
+func (s *Spool) Entries() ([]Entry, error) {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), itemExt) {
+			continue
+		}
+
+		created, err := createdFromName(info.Name())
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, Entry{
+			Path:    filepath.Join(s.Dir, info.Name()),
+			Size:    info.Size(),
+			Created: created,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// Trim removes expired items and then the oldest items until the spool is within its size cap. It returns the number
+// of items removed.
+func (s *Spool) Trim() (int, error) {
+	entries, err := s.Entries()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	var total int64
+	var kept []Entry
+	for _, entry := range entries {
+		if s.MaxAge > 0 && time.Since(entry.Created) > s.MaxAge {
+			if err := s.Remove(entry); err != nil {
+				return removed, err
+			}
+			removed++
+			continue
+		}
+		total += entry.Size
+		kept = append(kept, entry)
+	}
+
+	for i := 0; s.MaxBytes > 0 && total > s.MaxBytes && i < len(kept); i++ {
+		if err := s.Remove(kept[i]); err != nil {
+			return removed, err
+		}
+		total -= kept[i].Size
+		removed++
+	}
+
+	return removed, nil
+}
+
+// writeFile writes to a temporary file and renames it into place, so readers never see a partially written item
+func (s *Spool) writeFile(path string, item Item) error {
+	bits, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.Write(path, bits, 0600)
+}
+
+func createdFromName(name string) (time.Time, error) {
+	idx := strings.IndexByte(name, '-')
+	if idx < 0 {
+		return time.Time{}, fmt.Errorf("%s is not a spooled item", name)
+	}
+
+	nanos, err := strconv.ParseInt(name[:idx], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nanos), nil
+}
@@ -0,0 +1,99 @@
+package spool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpoolWriteAndRead(t *testing.T) {
+	s := newTestSpool(t)
+	item := Item{
+		Endpoint:  "http://localhost/v2/track",
+		Envelopes: []json.RawMessage{json.RawMessage(`{"name":"one"}`)},
+	}
+	require.NoError(t, s.Write(item))
+
+	entries, err := s.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	actual, err := s.Read(entries[0])
+	require.NoError(t, err)
+	assert.Equal(t, item.Endpoint, actual.Endpoint)
+	assert.Equal(t, item.Envelopes, actual.Envelopes)
+	assert.False(t, actual.Created.IsZero())
+
+	require.NoError(t, s.Remove(entries[0]))
+	entries, err = s.Entries()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestSpoolEntriesMissingDir(t *testing.T) {
+	s, err := New("/does/not/exist/apmz-spool")
+	require.NoError(t, err)
+	entries, err := s.Entries()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestSpoolTrim(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     []Option
+		created  []time.Time
+		expected int
+	}{
+		{
+			name:     "ExpiresOldItems",
+			opts:     []Option{WithMaxAge(time.Hour)},
+			created:  []time.Time{time.Now().Add(-2 * time.Hour), time.Now()},
+			expected: 1,
+		},
+		{
+			name:     "CapsTotalSize",
+			opts:     []Option{WithMaxBytes(1)},
+			created:  []time.Time{time.Now().Add(-time.Minute), time.Now()},
+			expected: 0,
+		},
+		{
+			name:     "NoLimits",
+			opts:     []Option{WithMaxAge(0), WithMaxBytes(0)},
+			created:  []time.Time{time.Now().Add(-30 * 24 * time.Hour), time.Now()},
+			expected: 2,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s := newTestSpool(t, c.opts...)
+			for _, created := range c.created {
+				require.NoError(t, s.Write(Item{Endpoint: "http://localhost", Created: created}))
+			}
+
+			entries, err := s.Entries()
+			require.NoError(t, err)
+			assert.Len(t, entries, c.expected)
+		})
+	}
+}
+
+func newTestSpool(t *testing.T, opts ...Option) *Spool {
+	dir, err := ioutil.TempDir("", "apmz-spool")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	s, err := New(dir, opts...)
+	require.NoError(t, err)
+	return s
+}
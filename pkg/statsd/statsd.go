@@ -0,0 +1,344 @@
+// Package statsd provides an apmz destination which sends metrics to a StatsD or DogStatsD agent over UDP or a unix
+// domain socket, with tags in the DogStatsD format. Traces can optionally be sent as DogStatsD events.
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+type (
+	// Sink is a service.APMer which writes metrics, and optionally traces as events, to a StatsD agent
+	Sink struct {
+		address    string
+		network    string
+		path       string
+		metricType MetricType
+		events     bool
+		excluded   []string
+		tags       map[string]string
+		maxPacket  int
+
+		mu     sync.Mutex
+		conn   net.Conn
+		packet []string
+		size   int
+		items  int
+		report channel.Report
+	}
+
+	// MetricType is the StatsD type metric telemetry is sent as
+	MetricType string
+
+	// Option is a variadic optional configuration func
+	Option func(s *Sink) error
+)
+
+const (
+	// Gauge sends metrics as StatsD gauges
+	Gauge MetricType = "gauge"
+	// Timer sends metrics as StatsD timers; the value is sent as is, so it should be in milliseconds
+	Timer MetricType = "timer"
+
+	unixPrefix = "unix://"
+
+	// packet sizes which avoid fragmentation over UDP, and the default buffer of the DogStatsD unix socket
+	maxUDPPacket  = 1432
+	maxUnixPacket = 8192
+
+	writeTimeout = 5 * time.Second
+)
+
+// DefaultExcludedTags are tags which are not sent, because a value which changes every run, such as the correlation
+// id the bash helpers add, would create a new context for every script run
+var DefaultExcludedTags = []string{"correlation_id"}
+
+var _ service.APMer = (*Sink)(nil)
+
+// New creates a sink which sends to the agent at the address, either host:port for UDP, or unix:///path/to/socket for
+// a unix domain socket
+func New(address string, opts ...Option) (*Sink, error) {
+	s := &Sink{
+		address:    address,
+		network:    "udp",
+		path:       address,
+		metricType: Gauge,
+		excluded:   DefaultExcludedTags,
+		maxPacket:  maxUDPPacket,
+	}
+
+	if strings.HasPrefix(address, unixPrefix) {
+		s.network = "unixgram"
+		s.path = strings.TrimPrefix(address, unixPrefix)
+		s.maxPacket = maxUnixPacket
+	} else if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("StatsD address %q must be host:port or unix:///path/to/socket", address)
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// WithMetricType sets the StatsD type metrics are sent as
+func WithMetricType(metricType MetricType) Option {
+	return func(s *Sink) error {
+		switch metricType {
+		case Gauge, Timer:
+			s.metricType = metricType
+			return nil
+		default:
+			return fmt.Errorf("StatsD metric type %q must be %q or %q", metricType, Gauge, Timer)
+		}
+	}
+}
+
+// WithEvents sends traces as DogStatsD events
+func WithEvents() Option {
+	return func(s *Sink) error {
+		s.events = true
+		return nil
+	}
+}
+
+// WithExcludedTags sets the tags which are not sent, replacing DefaultExcludedTags
+func WithExcludedTags(tags ...string) Option {
+	return func(s *Sink) error {
+		s.excluded = tags
+		return nil
+	}
+}
+
+// WithTags sets context tags, such as the cloud role, applied to every item which doesn't set them itself
+func WithTags(tags map[string]string) Option {
+	return func(s *Sink) error {
+		s.tags = tags
+		return nil
+	}
+}
+
+// Track writes metric telemetry, and traces if events are enabled; other telemetry is ignored. Lines are buffered
+// into packets, which are written once full.
+func (s *Sink) Track(item apmz.Telemetry) {
+	line, ok := s.line(item)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report.Sent++
+	if s.size > 0 && s.size+1+len(line) > s.maxPacket {
+		s.flush()
+	}
+
+	if s.size > 0 {
+		s.size++
+	}
+	s.packet = append(s.packet, line)
+	s.size += len(line)
+	s.items++
+}
+
+// Close writes the buffered packet and closes the connection. StatsD over UDP gives no acknowledgement, so items
+// written without error are counted as accepted.
+func (s *Sink) Close(ctx context.Context) []service.DeliveryResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flush()
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+
+	if s.report.Sent == 0 {
+		return nil
+	}
+
+	report := s.report
+	report.Reasons = append([]string(nil), s.report.Reasons...)
+	return []service.DeliveryResult{{Key: s.address, Report: report}}
+}
+
+// flush writes the buffered lines as a single packet; the caller must hold the lock
+func (s *Sink) flush() {
+	if len(s.packet) == 0 {
+		return
+	}
+
+	items := s.items
+	packet := strings.Join(s.packet, "\n")
+	s.packet, s.size, s.items = nil, 0, 0
+
+	if err := s.write([]byte(packet)); err != nil {
+		s.report.Dropped += items
+		s.report.AddReason(err.Error())
+		return
+	}
+	s.report.Accepted += items
+}
+
+func (s *Sink) write(packet []byte) error {
+	if s.conn == nil {
+		conn, err := net.Dial(s.network, s.path)
+		if err != nil {
+			return fmt.Errorf("unable to connect: %v", err)
+		}
+		s.conn = conn
+	}
+
+	if err := s.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return fmt.Errorf("unable to write: %v", err)
+	}
+
+	if _, err := s.conn.Write(packet); err != nil {
+		return fmt.Errorf("unable to write: %v", err)
+	}
+	return nil
+}
+
+// line formats the item in the DogStatsD datagram format
+func (s *Sink) line(item apmz.Telemetry) (string, bool) {
+	switch t := item.(type) {
+	case *apmz.MetricTelemetry:
+		return s.metric(t.Name, t.Value, item), true
+	case *apmz.AggregateMetricTelemetry:
+		return s.metric(t.Name, t.Value, item), true
+	case *apmz.TraceTelemetry:
+		if !s.events {
+			return "", false
+		}
+		return s.event(t, item), true
+	default:
+		return "", false
+	}
+}
+
+func (s *Sink) metric(name string, value float64, item apmz.Telemetry) string {
+	kind := "g"
+	if s.metricType == Timer {
+		kind = "ms"
+	}
+	return fmt.Sprintf("%s:%s|%s%s", sanitize(name), formatValue(value), kind, s.encodeTags(item))
+}
+
+// event formats a trace as a DogStatsD event, titled by its message and with its severity as the alert type
+func (s *Sink) event(trace *apmz.TraceTelemetry, item apmz.Telemetry) string {
+	title := escapeEventText(trace.Message)
+	text := title
+	timestamp := trace.Time()
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	return fmt.Sprintf("_e{%d,%d}:%s|%s|d:%d|t:%s%s",
+		len(title), len(text), title, text, timestamp.Unix(), alertType(trace.SeverityLevel), s.encodeTags(item))
+}
+
+// encodeTags encodes the item's tags, and its cloud role as the service tag, in the DogStatsD format
+func (s *Sink) encodeTags(item apmz.Telemetry) string {
+	tags := make(map[string]string)
+	for k, v := range item.GetProperties() {
+		if !contains(s.excluded, k) {
+			tags[k] = v
+		}
+	}
+
+	contextTags := make(contracts.ContextTags)
+	for k, v := range s.tags {
+		contextTags[k] = v
+	}
+	for k, v := range item.ContextTags() {
+		contextTags[k] = v
+	}
+
+	if role := contextTags.Cloud().GetRole(); role != "" {
+		if _, ok := tags["service"]; !ok {
+			tags["service"] = role
+		}
+	}
+
+	if len(tags) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s:%s", sanitize(k), sanitizeTag(tags[k]))
+	}
+	return "|#" + strings.Join(pairs, ",")
+}
+
+func alertType(level contracts.SeverityLevel) string {
+	switch level {
+	case contracts.Warning:
+		return "warning"
+	case contracts.Error, contracts.Critical:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+func formatValue(v float64) string {
+	return fmt.Sprintf("%g", v)
+}
+
+// sanitize replaces the characters which delimit the StatsD format in a metric name
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ', '\n', '\r', '\t':
+			return '_'
+		default:
+			return r
+		}
+	}, name)
+}
+
+// sanitizeTag replaces the characters which delimit tags in the DogStatsD format
+func sanitizeTag(tag string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '|', ',', ' ', '\n', '\r', '\t':
+			return '_'
+		default:
+			return r
+		}
+	}, tag)
+}
+
+func escapeEventText(text string) string {
+	return strings.NewReplacer("\r\n", `\n`, "\n", `\n`, "|", "_").Replace(text)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
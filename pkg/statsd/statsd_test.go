@@ -0,0 +1,158 @@
+package statsd
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New("localhost")
+	assert.Error(t, err)
+
+	_, err = New("localhost:8125", WithMetricType("counter"))
+	assert.Error(t, err)
+
+	s, err := New("unix:///var/run/datadog/dsd.socket")
+	require.NoError(t, err)
+	assert.Equal(t, "unixgram", s.network)
+	assert.Equal(t, "/var/run/datadog/dsd.socket", s.path)
+}
+
+func TestSinkLines(t *testing.T) {
+	metric := apmz.NewMetricTelemetry("deploy duration", 1.5)
+	metric.Properties["stage"] = "prod,east"
+	metric.Properties["correlation_id"] = "run"
+	trace := apmz.NewTraceTelemetry("deploy\nfailed", contracts.Error)
+	trace.Timestamp = time.Unix(1577836800, 0)
+
+	cases := []struct {
+		name     string
+		opts     []Option
+		item     apmz.Telemetry
+		expected string
+	}{
+		{
+			name:     "Gauge",
+			item:     metric,
+			expected: "deploy_duration:1.5|g|#service:ci,stage:prod_east",
+		},
+		{
+			name:     "Timer",
+			opts:     []Option{WithMetricType(Timer)},
+			item:     metric,
+			expected: "deploy_duration:1.5|ms|#service:ci,stage:prod_east",
+		},
+		{
+			name:     "Event",
+			opts:     []Option{WithEvents()},
+			item:     trace,
+			expected: `_e{14,14}:deploy\nfailed|deploy\nfailed|d:1577836800|t:error|#service:ci`,
+		},
+		{
+			name: "TraceWithoutEvents",
+			item: trace,
+		},
+		{
+			name: "OtherTelemetry",
+			item: apmz.NewEventTelemetry("deployed"),
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			opts := append([]Option{WithTags(map[string]string{contracts.CloudRole: "ci"})}, c.opts...)
+			s, err := New("localhost:8125", opts...)
+			require.NoError(t, err)
+
+			line, ok := s.line(c.item)
+			assert.Equal(t, c.expected != "", ok)
+			assert.Equal(t, c.expected, line)
+		})
+	}
+}
+
+func TestSinkSendsOverUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s, err := New(conn.LocalAddr().String())
+	require.NoError(t, err)
+	s.maxPacket = 12
+	s.Track(apmz.NewMetricTelemetry("a", 1))
+	s.Track(apmz.NewMetricTelemetry("b", 2))
+	s.Track(apmz.NewMetricTelemetry("c", 3))
+	results := s.Close(context.Background())
+	assert.Equal(t, []service.DeliveryResult{{Key: conn.LocalAddr().String(), Report: channel.Report{Sent: 3, Accepted: 3}}}, results)
+
+	assert.Equal(t, "a:1|g\nb:2|g", readPacket(t, conn))
+	assert.Equal(t, "c:3|g", readPacket(t, conn))
+}
+
+func TestSinkSendsOverUnixSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix datagram sockets are not supported on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "apmz-statsd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dsd.socket")
+	conn, err := net.ListenPacket("unixgram", path)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s, err := New("unix://" + path)
+	require.NoError(t, err)
+	s.Track(apmz.NewMetricTelemetry("a", 1))
+	results := s.Close(context.Background())
+	require.Len(t, results, 1)
+	assert.Equal(t, channel.Report{Sent: 1, Accepted: 1}, results[0].Report)
+	assert.Equal(t, "a:1|g", readPacket(t, conn))
+}
+
+func TestSinkDropsWhenSocketIsMissing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix datagram sockets are not supported on windows")
+	}
+
+	s, err := New("unix:///apmz/does/not/exist.socket")
+	require.NoError(t, err)
+	s.Track(apmz.NewMetricTelemetry("a", 1))
+	results := s.Close(context.Background())
+	require.Len(t, results, 1)
+	assert.Equal(t, 1, results[0].Dropped)
+	assert.Len(t, results[0].Reasons, 1)
+}
+
+func TestSinkWithoutMetrics(t *testing.T) {
+	s, err := New("localhost:8125")
+	require.NoError(t, err)
+	s.Track(apmz.NewTraceTelemetry("foo", contracts.Information))
+	assert.Nil(t, s.Close(context.Background()))
+}
+
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 65536)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
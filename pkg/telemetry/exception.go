@@ -0,0 +1,205 @@
+// Package telemetry provides Application Insights telemetry items which are not offered by the apmz-sdk or need
+// more control over the data contract than the apmz-sdk offers.
+package telemetry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+)
+
+type (
+	// ExceptionTelemetry represents an exception or failure which occurred during execution of a script. Unlike the
+	// apmz-sdk ExceptionTelemetry, the stack is provided by the caller rather than the current goroutine and the
+	// problem id used for grouping can be specified.
+	ExceptionTelemetry struct {
+		apmz.BaseTelemetry
+		apmz.BaseTelemetryMeasurements
+
+		// ProblemID identifies where the exception occurred and is used for grouping exceptions
+		ProblemID string
+
+		// TypeName is the type of the exception; eg "ScriptError"
+		TypeName string
+
+		// Message describes the exception
+		Message string
+
+		// Stack is the raw text of the stack, if it was provided as text
+		Stack string
+
+		// Frames is the parsed stack with the innermost frame first
+		Frames []*contracts.StackFrame
+
+		// SeverityLevel of the exception
+		SeverityLevel contracts.SeverityLevel
+	}
+)
+
+// NewExceptionTelemetry creates a new exception telemetry item with the specified type and message
+func NewExceptionTelemetry(typeName, message string) *ExceptionTelemetry {
+	return &ExceptionTelemetry{
+		TypeName:      typeName,
+		Message:       message,
+		SeverityLevel: contracts.Error,
+		BaseTelemetry: apmz.BaseTelemetry{
+			Timestamp:  time.Now(),
+			Tags:       make(contracts.ContextTags),
+			Properties: make(map[string]string),
+		},
+		BaseTelemetryMeasurements: apmz.BaseTelemetryMeasurements{
+			Measurements: make(map[string]float64),
+		},
+	}
+}
+
+// DefaultProblemID builds a problem id from the type name and the innermost stack frame
+func (et *ExceptionTelemetry) DefaultProblemID() string {
+	if len(et.Frames) == 0 || et.Frames[0].Method == "" {
+		return et.TypeName
+	}
+	return fmt.Sprintf("%s at %s", et.TypeName, et.Frames[0].Method)
+}
+
+// TelemetryData gets the TelemetryData for an ExceptionTelemetry
+func (et *ExceptionTelemetry) TelemetryData() apmz.TelemetryData {
+	details := contracts.NewExceptionDetails()
+	details.TypeName = et.TypeName
+	details.Message = et.Message
+	details.Stack = et.Stack
+	details.ParsedStack = et.Frames
+	details.HasFullStack = len(et.Frames) > 0
+
+	data := contracts.NewExceptionData()
+	data.ProblemId = et.ProblemID
+	if data.ProblemId == "" {
+		data.ProblemId = et.DefaultProblemID()
+	}
+	data.SeverityLevel = et.SeverityLevel
+	data.Exceptions = []*contracts.ExceptionDetails{details}
+	data.Properties = et.Properties
+	data.Measurements = et.Measurements
+	return data
+}
+
+// ParseBashFrames builds stack frames from the bash FUNCNAME, BASH_SOURCE and line arrays, where lines[i] is the line
+// currently executing in frame i.
+//
+// From within a bash function, the frames of the caller can be passed as:
+// "${FUNCNAME[@]:1}" "${BASH_SOURCE[@]:1}" "${BASH_LINENO[@]}"
+func ParseBashFrames(funcNames, sources, lines []string) ([]*contracts.StackFrame, error) {
+	frames := make([]*contracts.StackFrame, len(funcNames))
+	for i, name := range funcNames {
+		frame := &contracts.StackFrame{
+			Level:  i,
+			Method: name,
+		}
+
+		if i < len(sources) {
+			frame.FileName = sources[i]
+		}
+
+		if i < len(lines) && lines[i] != "" {
+			line, err := strconv.Atoi(lines[i])
+			if err != nil {
+				return nil, fmt.Errorf("line %q of frame %d is not a number: %w", lines[i], i, err)
+			}
+			frame.Line = line
+		}
+
+		frames[i] = frame
+	}
+	return frames, nil
+}
+
+// ParseGoStack builds stack frames from a Go-style stack trace, such as the output of a panic or debug.Stack(). The
+// first "panic: " message found is returned along with the frames of the first goroutine.
+func ParseGoStack(reader io.Reader) (string, []*contracts.StackFrame, error) {
+	var message string
+	var frames []*contracts.StackFrame
+	var current *contracts.StackFrame
+	inGoroutine := false
+
+	// lines are read whole, however long, as a panic message can be arbitrarily long
+	r := bufio.NewReader(reader)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return message, frames, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			if inGoroutine && len(frames) > 0 {
+				return message, frames, nil
+			}
+		case strings.HasPrefix(line, "panic: ") && message == "":
+			message = strings.TrimPrefix(line, "panic: ")
+		case strings.HasPrefix(line, "goroutine "):
+			if inGoroutine && len(frames) > 0 {
+				return message, frames, nil
+			}
+			inGoroutine = true
+		case inGoroutine && strings.HasPrefix(line, "\t") && current != nil:
+			current.FileName, current.Line = splitGoFileLine(trimmed)
+			current = nil
+		case inGoroutine:
+			current = goFrame(len(frames), trimmed)
+			frames = append(frames, current)
+		}
+
+		if err == io.EOF {
+			return message, frames, nil
+		}
+	}
+}
+
+// goFrame builds a stack frame from a Go function line like "github.com/foo/bar.(*Baz).Run(0x1, 0x2)"
+func goFrame(level int, fn string) *contracts.StackFrame {
+	if idx := strings.LastIndexByte(fn, '('); idx > 0 && strings.HasSuffix(fn, ")") {
+		fn = fn[:idx]
+	}
+	fn = strings.TrimPrefix(fn, "created by ")
+
+	frame := &contracts.StackFrame{
+		Level:  level,
+		Method: fn,
+	}
+
+	lastSlash := strings.LastIndexByte(fn, '/')
+	if lastSlash < 0 {
+		lastSlash = 0
+	}
+
+	if firstDot := strings.IndexByte(fn[lastSlash:], '.'); firstDot >= 0 {
+		frame.Assembly = fn[:lastSlash+firstDot]
+		frame.Method = fn[lastSlash+firstDot+1:]
+	}
+	return frame
+}
+
+// splitGoFileLine splits a Go stack file location like "/src/main.go:12 +0x1d" into the file and line
+func splitGoFileLine(loc string) (string, int) {
+	if idx := strings.LastIndex(loc, " +0x"); idx > 0 {
+		loc = loc[:idx]
+	}
+
+	idx := strings.LastIndexByte(loc, ':')
+	if idx < 0 {
+		return loc, 0
+	}
+
+	line, err := strconv.Atoi(loc[idx+1:])
+	if err != nil {
+		return loc, 0
+	}
+	return loc[:idx], line
+}
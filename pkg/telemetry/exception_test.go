@@ -0,0 +1,190 @@
+package telemetry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGoStack(t *testing.T) {
+	longMessage := strings.Repeat("x", 100*1024)
+
+	cases := []struct {
+		name    string
+		stack   string
+		message string
+		frames  []*contracts.StackFrame
+	}{
+		{
+			name: "Panic",
+			stack: `panic: boom
+
+goroutine 1 [running]:
+main.explode(...)
+	/src/main.go:12
+main.main()
+	/src/main.go:7 +0x39
+`,
+			message: "boom",
+			frames: []*contracts.StackFrame{
+				{Level: 0, Assembly: "main", Method: "explode", FileName: "/src/main.go", Line: 12},
+				{Level: 1, Assembly: "main", Method: "main", FileName: "/src/main.go", Line: 7},
+			},
+		},
+		{
+			name: "OnlyFirstOfMultipleGoroutines",
+			stack: `panic: boom
+
+goroutine 1 [running]:
+github.com/foo/bar.(*Baz).Run(0x1, 0x2)
+	/src/bar/baz.go:30 +0x1d
+
+goroutine 6 [chan receive]:
+main.worker(0xc000010000)
+	/src/worker.go:20 +0x5d
+`,
+			message: "boom",
+			frames: []*contracts.StackFrame{
+				{Level: 0, Assembly: "github.com/foo/bar", Method: "(*Baz).Run", FileName: "/src/bar/baz.go", Line: 30},
+			},
+		},
+		{
+			name: "GoroutinesWithoutBlankLines",
+			stack: `goroutine 1 [running]:
+main.main()
+	/src/main.go:7
+goroutine 2 [running]:
+main.other()
+	/src/other.go:1
+`,
+			frames: []*contracts.StackFrame{
+				{Level: 0, Assembly: "main", Method: "main", FileName: "/src/main.go", Line: 7},
+			},
+		},
+		{
+			name: "CreatedBy",
+			stack: `goroutine 6 [running]:
+main.worker(0xc000010000)
+	/src/worker.go:20 +0x5d
+created by main.main
+	/src/main.go:5 +0x1d
+`,
+			frames: []*contracts.StackFrame{
+				{Level: 0, Assembly: "main", Method: "worker", FileName: "/src/worker.go", Line: 20},
+				{Level: 1, Assembly: "main", Method: "main", FileName: "/src/main.go", Line: 5},
+			},
+		},
+		{
+			name: "LineOver64KB",
+			stack: "panic: " + longMessage + `
+
+goroutine 1 [running]:
+main.main()
+	/src/main.go:7
+`,
+			message: longMessage,
+			frames: []*contracts.StackFrame{
+				{Level: 0, Assembly: "main", Method: "main", FileName: "/src/main.go", Line: 7},
+			},
+		},
+		{
+			name:  "WithoutTrailingNewline",
+			stack: "goroutine 1 [running]:\nmain.main()\n\t/src/main.go:7",
+			frames: []*contracts.StackFrame{
+				{Level: 0, Assembly: "main", Method: "main", FileName: "/src/main.go", Line: 7},
+			},
+		},
+		{
+			name:  "NoGoroutine",
+			stack: "something went wrong\n",
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			message, frames, err := ParseGoStack(strings.NewReader(c.stack))
+			require.NoError(t, err)
+			assert.Equal(t, c.message, message)
+			assert.Equal(t, c.frames, frames)
+		})
+	}
+}
+
+func TestParseBashFrames(t *testing.T) {
+	cases := []struct {
+		name      string
+		funcNames []string
+		sources   []string
+		lines     []string
+		frames    []*contracts.StackFrame
+		err       string
+	}{
+		{
+			name:      "Frames",
+			funcNames: []string{"migrate", "main"},
+			sources:   []string{"./lib.sh", "./deploy.sh"},
+			lines:     []string{"42", "10"},
+			frames: []*contracts.StackFrame{
+				{Level: 0, Method: "migrate", FileName: "./lib.sh", Line: 42},
+				{Level: 1, Method: "main", FileName: "./deploy.sh", Line: 10},
+			},
+		},
+		{
+			name:      "FewerSourcesAndLines",
+			funcNames: []string{"migrate", "main"},
+			sources:   []string{"./lib.sh"},
+			lines:     []string{"42"},
+			frames: []*contracts.StackFrame{
+				{Level: 0, Method: "migrate", FileName: "./lib.sh", Line: 42},
+				{Level: 1, Method: "main"},
+			},
+		},
+		{
+			name:      "MoreSourcesAndLinesThanFuncNames",
+			funcNames: []string{"main"},
+			sources:   []string{"./deploy.sh", "./extra.sh"},
+			lines:     []string{"10", "0"},
+			frames: []*contracts.StackFrame{
+				{Level: 0, Method: "main", FileName: "./deploy.sh", Line: 10},
+			},
+		},
+		{
+			name:      "EmptyLine",
+			funcNames: []string{"main"},
+			lines:     []string{""},
+			frames: []*contracts.StackFrame{
+				{Level: 0, Method: "main"},
+			},
+		},
+		{
+			name:   "NoFuncNames",
+			frames: []*contracts.StackFrame{},
+		},
+		{
+			name:      "LineNotANumber",
+			funcNames: []string{"main"},
+			lines:     []string{"ten"},
+			err:       `line "ten" of frame 0 is not a number`,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			frames, err := ParseBashFrames(c.funcNames, c.sources, c.lines)
+			if c.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.frames, frames)
+		})
+	}
+}
@@ -0,0 +1,274 @@
+// Package webhook provides an apmz destination which posts telemetry to a webhook as JSON arrays of batch events,
+// such as to raise an alert when a script fails. Large batches are posted in chunks as they're tracked.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+type (
+	// Sink is a service.APMer which posts telemetry to a webhook
+	Sink struct {
+		url       string
+		key       string
+		headers   map[string]string
+		batchSize int
+		client    *http.Client
+
+		mu          sync.Mutex
+		events      []service.Event
+		pending     []service.Event
+		retryAt     time.Time
+		closed      bool
+		report      channel.Report
+		lastFailure string
+	}
+
+	// Option is a variadic optional configuration func
+	Option func(s *Sink) error
+)
+
+var _ service.APMer = (*Sink)(nil)
+
+// New creates a sink which posts to the webhook at the url, such as https://hooks.example.com/alerts
+func New(webhookURL string, opts ...Option) (*Sink, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook url %q must be an http or https url", webhookURL)
+	}
+
+	// webhook urls often carry a secret in their query, which shouldn't be printed with the delivery result
+	key := *u
+	key.User = nil
+	key.RawQuery = ""
+	s := &Sink{
+		url:       webhookURL,
+		key:       key.String(),
+		batchSize: channel.DefaultBatchSize,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// WithHeaders sets headers sent with each post, such as for authentication
+func WithHeaders(headers map[string]string) Option {
+	return func(s *Sink) error {
+		s.headers = headers
+		return nil
+	}
+}
+
+// WithBatchSize sets the number of events sent in a single post
+func WithBatchSize(size int) Option {
+	return func(s *Sink) error {
+		if size < 1 {
+			return fmt.Errorf("batch size must be greater than 0")
+		}
+		s.batchSize = size
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client used to post to the webhook
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Sink) error {
+		s.client = client
+		return nil
+	}
+}
+
+// Track buffers the item as a batch event, posting the buffer once it reaches the batch size
+func (s *Sink) Track(item apmz.Telemetry) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+
+	s.report.Sent++
+	s.events = append(s.events, service.DefaultTelemetryKinds.NewEvent(item))
+	if len(s.events) < s.batchSize {
+		s.mu.Unlock()
+		return
+	}
+
+	batch := s.events
+	s.events = nil
+	throttled := time.Now().Before(s.retryAt)
+	s.mu.Unlock()
+
+	if throttled {
+		s.retain(batch)
+		return
+	}
+	s.retain(s.send(context.Background(), batch))
+}
+
+// Close posts the buffered events and any which previously failed. Failed posts are retried with backoff until the
+// context's deadline; without a deadline, each post is tried once. Whatever could not be posted is dropped.
+func (s *Sink) Close(ctx context.Context) []service.DeliveryResult {
+	s.mu.Lock()
+	s.closed = true
+	if s.report.Sent == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.report.Retried += len(s.pending)
+	remaining := append(s.pending, s.events...)
+	s.pending = nil
+	s.events = nil
+	s.mu.Unlock()
+
+	channel.Retry(ctx, s.notBefore, func(retrying bool) bool {
+		if retrying {
+			s.mu.Lock()
+			s.report.Retried += len(remaining)
+			s.mu.Unlock()
+		}
+
+		var retry []service.Event
+		for start := 0; start < len(remaining); start += s.batchSize {
+			end := start + s.batchSize
+			if end > len(remaining) {
+				end = len(remaining)
+			}
+			retry = append(retry, s.send(ctx, remaining[start:end])...)
+		}
+
+		remaining = retry
+		return len(remaining) > 0
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(remaining); n > 0 {
+		s.report.Dropped += n
+		s.report.AddReason(s.lastFailure)
+	}
+
+	report := s.report
+	report.Reasons = append([]string(nil), s.report.Reasons...)
+	return []service.DeliveryResult{{Key: s.key, Report: report}}
+}
+
+// send posts a batch of events and returns those which should be retried
+func (s *Sink) send(ctx context.Context, batch []service.Event) []service.Event {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.report.Rejected += len(batch)
+		s.report.AddReason(err.Error())
+		return nil
+	}
+
+	retryable, err := s.post(ctx, body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err == nil:
+		s.report.Accepted += len(batch)
+		s.retryAt = time.Time{}
+	case !retryable:
+		s.report.Rejected += len(batch)
+		s.report.AddReason(err.Error())
+	default:
+		s.lastFailure = err.Error()
+		s.retryAt = time.Now().Add(channel.MinBackoff)
+		return batch
+	}
+	return nil
+}
+
+// retain keeps events for another attempt when the sink is closed. At most channel.MaxPendingBatches batches are
+// kept, so a long outage doesn't grow memory without bound, and the rest are dropped.
+func (s *Sink) retain(batch []service.Event) {
+	if len(batch) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keep := channel.Retainable(len(s.pending), len(batch), s.batchSize)
+	s.pending = append(s.pending, batch[:keep]...)
+	if dropped := len(batch) - keep; dropped > 0 {
+		s.report.Dropped += dropped
+		s.report.AddReason("retry buffer full: " + s.lastFailure)
+	}
+}
+
+// notBefore returns when the next post should be made after a failure
+func (s *Sink) notBefore() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.retryAt
+}
+
+// post sends the body, reporting whether a failed post may succeed later
+func (s *Sink) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("post failed: %v", scrub(err))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	msg, _ := ioutil.ReadAll(res.Body)
+	reason := fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	if trimmed := strings.TrimSpace(string(msg)); trimmed != "" {
+		reason = fmt.Sprintf("%s: %s", reason, trimmed)
+	}
+	retryable := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	if retryable {
+		reason = "post failed: " + reason
+	}
+	return retryable, fmt.Errorf("%s", reason)
+}
+
+// scrub removes the url from a transport error, since it may carry a secret
+func scrub(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devigned/apmz-sdk/apmz"
+	"github.com/devigned/apmz-sdk/apmz/contracts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/devigned/apmz/pkg/channel"
+	"github.com/devigned/apmz/pkg/service"
+)
+
+func TestSinkPostsEvents(t *testing.T) {
+	var events []service.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		bits, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(bits, &events))
+	}))
+	defer srv.Close()
+
+	sink, err := New(srv.URL+"/alerts?token=secret", WithHeaders(map[string]string{"Authorization": "secret"}))
+	require.NoError(t, err)
+	sink.Track(apmz.NewTraceTelemetry("deploy failed", contracts.Error))
+	sink.Track(apmz.NewMetricTelemetry("deploy-duration", 3))
+
+	results := sink.Close(context.Background())
+	assert.Equal(t, []service.DeliveryResult{{Key: srv.URL + "/alerts", Report: channel.Report{Sent: 2, Accepted: 2}}}, results)
+	require.Len(t, events, 2)
+	assert.Equal(t, "trace/v1", events[0].Type)
+	assert.Equal(t, "deploy failed", events[0].Item.(*apmz.TraceTelemetry).Message)
+}
+
+func TestSinkPostsInBatches(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []service.Event
+		bits, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(bits, &events))
+		mu.Lock()
+		defer mu.Unlock()
+		sizes = append(sizes, len(events))
+	}))
+	defer srv.Close()
+
+	sink, err := New(srv.URL, WithBatchSize(2))
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		sink.Track(apmz.NewTraceTelemetry("foo", contracts.Information))
+	}
+
+	mu.Lock()
+	assert.Equal(t, []int{2, 2}, sizes)
+	mu.Unlock()
+
+	results := sink.Close(context.Background())
+	assert.Equal(t, channel.Report{Sent: 5, Accepted: 5}, results[0].Report)
+	assert.Equal(t, []int{2, 2, 1}, sizes)
+}
+
+func TestSinkFailures(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   int
+		deadline time.Duration
+		report   channel.Report
+	}{
+		{
+			name:   "BadRequest",
+			status: http.StatusBadRequest,
+			report: channel.Report{Sent: 1, Rejected: 1, Reasons: []string{"400 Bad Request: nope"}},
+		},
+		{
+			name:   "UnavailableWithoutDeadline",
+			status: http.StatusServiceUnavailable,
+			report: channel.Report{Sent: 1, Dropped: 1, Reasons: []string{"post failed: 503 Service Unavailable: nope"}},
+		},
+		{
+			name:     "UnavailableThenAccepted",
+			status:   http.StatusServiceUnavailable,
+			deadline: 5 * time.Second,
+			report:   channel.Report{Sent: 1, Accepted: 1, Retried: 1},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) > 1 {
+					return
+				}
+				w.WriteHeader(c.status)
+				_, _ = w.Write([]byte("nope"))
+			}))
+			defer srv.Close()
+
+			sink, err := New(srv.URL)
+			require.NoError(t, err)
+			sink.Track(apmz.NewTraceTelemetry("foo", contracts.Error))
+
+			ctx := context.Background()
+			if c.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, c.deadline)
+				defer cancel()
+			}
+
+			results := sink.Close(ctx)
+			require.Len(t, results, 1)
+			assert.Equal(t, c.report, results[0].Report)
+		})
+	}
+}
+
+func TestNewRequiresHTTPURL(t *testing.T) {
+	_, err := New("ftp://example.com")
+	assert.Error(t, err)
+}
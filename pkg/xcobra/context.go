@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/devigned/tab"
 	"github.com/spf13/cobra"
@@ -17,6 +19,19 @@ type (
 	}
 )
 
+var (
+	// shutdownSignals cancel a command's context. SIGTERM is included so that commands get a chance to finish up, such
+	// as flushing telemetry, when a VM is being evicted.
+	shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+	// signalCtx is canceled by the first shutdown signal, once signalOnce has started handling them
+	signalOnce sync.Once
+	signalCtx  context.Context
+)
+
+// forcedExitCode is used when a second shutdown signal arrives before the command has finished
+const forcedExitCode = 130
+
 func (ewc ErrorWithCode) Error() string {
 	return fmt.Sprintf("failed with error code: %d", ewc.Code)
 }
@@ -30,18 +45,8 @@ func NewErrorWithCode(code int) *ErrorWithCode {
 
 // RunWithCtx will run a command which will respect os signals and propagate the context to children
 func RunWithCtx(run func(ctx context.Context, cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	// Wait for a signal to quit:
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, os.Kill)
-
-	go func() {
-		<-signalChan
-		cancel()
-	}()
-
 	return func(cmd *cobra.Command, args []string) {
+		ctx, cancel := context.WithCancel(signalContext())
 		ctx, span := tab.StartSpan(ctx, cmd.Name()+".Run")
 		defer span.End()
 		defer cancel()
@@ -71,18 +76,8 @@ func RunWithCtx(run func(ctx context.Context, cmd *cobra.Command, args []string)
 
 // PostRunWithCtxE will run a command which will respect os signals and propagate the context to children
 func PostRunWithCtxE(run func(ctx context.Context, cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) error {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	// Wait for a signal to quit:
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, os.Kill)
-
-	go func() {
-		<-signalChan
-		cancel()
-	}()
-
 	return func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithCancel(signalContext())
 		ctx, span := tab.StartSpan(ctx, cmd.Name()+".Run")
 		defer span.End()
 		defer cancel()
@@ -90,3 +85,23 @@ func PostRunWithCtxE(run func(ctx context.Context, cmd *cobra.Command, args []st
 		return run(ctx, cmd, args)
 	}
 }
+
+// signalContext returns the context shared by every command of the process, which is canceled on the first shutdown
+// signal. The signals are handled once, when a command first runs, and the second exits the process.
+func signalContext() context.Context {
+	signalOnce.Do(func() {
+		var cancel context.CancelFunc
+		signalCtx, cancel = context.WithCancel(context.Background())
+
+		signalChan := make(chan os.Signal, 2)
+		signal.Notify(signalChan, shutdownSignals...)
+
+		go func() {
+			<-signalChan
+			cancel()
+			<-signalChan
+			ExitWithCode(ErrorWithCode{Code: forcedExitCode})
+		}()
+	})
+	return signalCtx
+}
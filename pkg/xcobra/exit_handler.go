@@ -8,7 +8,10 @@ import (
 
 // ExitWithCode will exit the program with a error code if provided, else 1
 func ExitWithCode(err error) {
-	if e, ok := err.(ErrorWithCode); ok {
+	switch e := err.(type) {
+	case ErrorWithCode:
+		os.Exit(e.Code)
+	case *ErrorWithCode:
 		os.Exit(e.Code)
 	}
 	os.Exit(1)